@@ -0,0 +1,111 @@
+package mca
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// alignWriter aligns the trailing comments of each block of
+// lines to the same column, padding with spaces.
+//
+// A block is a run of lines with trailing comments, so only one
+// symbol's instructions are buffered at a time. Lines without a
+// comment, like labels, and lines that are only a comment end
+// the block and are written unchanged.
+type alignWriter struct {
+	w        io.Writer
+	tabwidth int
+	buf      []byte
+	block    []string
+}
+
+var _ io.Writer = (*alignWriter)(nil)
+
+func (aw *alignWriter) Write(p []byte) (int, error) {
+	aw.buf = append(aw.buf, p...)
+	for {
+		i := bytes.IndexByte(aw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := aw.writeLine(string(aw.buf[:i])); err != nil {
+			return 0, err
+		}
+		aw.buf = aw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes the current block and any buffered partial line.
+func (aw *alignWriter) Flush() error {
+	if err := aw.flushBlock(); err != nil {
+		return err
+	}
+	if len(aw.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(aw.w, string(aw.buf))
+	aw.buf = aw.buf[:0]
+	return err
+}
+
+func (aw *alignWriter) writeLine(s string) error {
+	if i := strings.Index(s, "// "); i > 0 {
+		aw.block = append(aw.block, s)
+		return nil
+	}
+	if err := aw.flushBlock(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(aw.w, s+"\n")
+	return err
+}
+
+// flushBlock writes the current block with its comments aligned
+// two columns past its widest instruction.
+func (aw *alignWriter) flushBlock() error {
+	if len(aw.block) == 0 {
+		return nil
+	}
+	col := 0
+	for _, s := range aw.block {
+		code, _ := splitComment(s)
+		if n := aw.columns(code); n > col {
+			col = n
+		}
+	}
+	col += 2
+
+	var b strings.Builder
+	for _, s := range aw.block {
+		code, comment := splitComment(s)
+		b.WriteString(code)
+		b.WriteString(strings.Repeat(" ", col-aw.columns(code)))
+		b.WriteString(comment)
+		b.WriteString("\n")
+	}
+	aw.block = aw.block[:0]
+	_, err := io.WriteString(aw.w, b.String())
+	return err
+}
+
+// splitComment splits s into the code before its trailing
+// comment, without trailing whitespace, and the comment.
+func splitComment(s string) (code, comment string) {
+	i := strings.Index(s, "// ")
+	return strings.TrimRight(s[:i], " \t"), s[i:]
+}
+
+// columns returns the number of columns that s occupies.
+func (aw *alignWriter) columns(s string) int {
+	col := 0
+	for _, c := range s {
+		if c == '\t' {
+			col += aw.tabwidth - col%aw.tabwidth
+		} else {
+			col++
+		}
+	}
+	return col
+}
@@ -0,0 +1,714 @@
+package mca
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// insnKind describes how an instruction affects control flow.
+type insnKind int
+
+const (
+	// kindOther is an instruction that falls through to the
+	// next instruction.
+	kindOther insnKind = iota
+	// kindBranch is a conditional branch.
+	kindBranch
+	// kindJump is an unconditional jump.
+	kindJump
+	// kindCall is a subroutine call.
+	kindCall
+	// kindReturn is a subroutine return.
+	kindReturn
+)
+
+func (k insnKind) String() string {
+	switch k {
+	case kindOther:
+		return "other"
+	case kindBranch:
+		return "branch"
+	case kindJump:
+		return "jump"
+	case kindCall:
+		return "call"
+	case kindReturn:
+		return "return"
+	default:
+		return "insnKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// Arch describes the GNU assembly syntax that "go tool objdump
+// -gnu" prints for a particular architecture.
+type Arch struct {
+	// Name is the GOARCH name.
+	Name string
+	// kind classifies a mnemonic.
+	kind func(mnemonic string) insnKind
+	// llvm rewrites GNU assembly that LLVM's assembly parser
+	// (and so llvm-mca) rejects into an equivalent spelling.
+	llvm func(mnemonic, operands string) (string, string)
+	// pcrel rewrites the instruction's PC-relative operands to
+	// a fixed displacement.
+	pcrel func(mnemonic, operands string) (string, string)
+	// move reports whether the instruction is a plain
+	// register-to-register move and, if so, its registers.
+	move func(mnemonic string, operands []string) (src, dst string, ok bool)
+	// stack reports whether the instruction stores a register
+	// to, or loads a register from, a stack slot.
+	stack func(mnemonic string, operands []string) stackAccess
+	// class returns the instruction's energy class and whether
+	// it accesses memory.
+	class func(mnemonic string, operands []string) (energyClass, bool)
+	// stops are the returns at which fix stops by default, as
+	// mnemonics or as whole instructions.
+	stops []string
+	// minLen and maxLen bound the length of an instruction's
+	// encoding, in bytes.
+	minLen, maxLen int
+	// trailers begin text after an instruction that its
+	// assembler would not read as part of it: comments, and the
+	// ';' that separates statements.
+	trailers []string
+}
+
+// stackAccess is how an instruction moves a register to or from
+// the stack.
+type stackAccess int
+
+const (
+	// stackNone does not move a register to or from the stack.
+	stackNone stackAccess = iota
+	// stackSpill stores a register to a stack slot.
+	stackSpill
+	// stackReload loads a register from a stack slot.
+	stackReload
+)
+
+var (
+	archAMD64 = &Arch{Name: "amd64", kind: x86Kind, llvm: x86LLVM, pcrel: x86PCRel, move: x86Move, stack: x86Stack, class: x86Class, stops: x86Stops, minLen: 1, maxLen: 15, trailers: x86Trailers}
+	arch386   = &Arch{Name: "386", kind: x86Kind, llvm: x86LLVM, pcrel: x86PCRel, move: x86Move, stack: x86Stack, class: x86Class, stops: x86Stops, minLen: 1, maxLen: 15, trailers: x86Trailers}
+	archARM64 = &Arch{Name: "arm64", kind: arm64Kind, llvm: arm64LLVM, pcrel: arm64PCRel, move: arm64Move, stack: arm64Stack, class: arm64Class, stops: arm64Stops, minLen: 4, maxLen: 4, trailers: arm64Trailers}
+)
+
+var (
+	x86Stops = []string{"ret", "retq", "retl", "retw"}
+	// br x30 is a return that does not predict as one.
+	arm64Stops = []string{"ret", "retaa", "retab", "br x30"}
+)
+
+var (
+	x86Trailers = []string{"//", "#", ";"}
+	// '#' begins an immediate on arm64.
+	arm64Trailers = []string{"//", ";"}
+)
+
+// arches is the set of known architectures, keyed by GOARCH.
+var arches = map[string]*Arch{
+	archAMD64.Name: archAMD64,
+	arch386.Name:   arch386,
+	archARM64.Name: archARM64,
+}
+
+// LookupArch returns the architecture with the GOARCH name s.
+func LookupArch(s string) (*Arch, bool) {
+	a, ok := arches[s]
+	return a, ok
+}
+
+// ArchNames returns the GOARCH names of the known architectures,
+// sorted.
+func ArchNames() []string {
+	var names []string
+	for name := range arches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ISAAuto is the -isa value that detects the architecture.
+const ISAAuto = "auto"
+
+// ParseISA parses an -isa flag: a GOARCH name from arches or
+// ISAAuto, for which it returns nil.
+func ParseISA(s string) (*Arch, error) {
+	if s == ISAAuto {
+		return nil, nil
+	}
+	if a, ok := LookupArch(s); ok {
+		return a, nil
+	}
+	names := append([]string{ISAAuto}, ArchNames()...)
+	return nil, fmt.Errorf("unknown -isa %q (want one of %s)", s, strings.Join(names, ", "))
+}
+
+// DetectArch returns the architecture of the disassembled lines,
+// or nil if they do not tell. Only x86 registers begin with '%',
+// every arm64 instruction is four bytes, and 386 code has no
+// 64-bit registers, which begin with "%r", but amd64 code need not
+// have any either, so 386 is never detected.
+func DetectArch(lines []Line) *Arch {
+	var n, x86, x64, four int
+	for _, l := range lines {
+		if l.GNUAsm == "" {
+			continue
+		}
+		n++
+		if strings.Contains(l.GNUAsm, "%") {
+			x86++
+		}
+		if strings.Contains(l.GNUAsm, "%r") {
+			x64++
+		}
+		if len(l.Instr) == 4 {
+			four++
+		}
+	}
+	switch {
+	case n == 0:
+		return nil
+	case x64 > 0:
+		return archAMD64
+	case x86 == 0 && four == n:
+		return archARM64
+	}
+	return nil
+}
+
+// Classify sets l's control-flow kind and, for direct branches
+// and calls, its target.
+//
+// A nil arch classifies l using every known architecture, which
+// works because their branch mnemonics do not overlap.
+func (a *Arch) Classify(l *Line) {
+	m, ops := SplitInsn(l.GNUAsm)
+	if a != nil {
+		l.kind = a.kind(m)
+	} else {
+		l.kind = x86Kind(m)
+		if l.kind == kindOther {
+			l.kind = arm64Kind(m)
+		}
+	}
+	l.Target, l.HasTarget, l.TargetSym = 0, false, ""
+	switch l.kind {
+	case kindBranch, kindJump, kindCall:
+		l.Target, l.HasTarget, l.TargetSym = parseTarget(l.Offset, ops)
+		if sym, ok := goAsmSym(l.GoAsm); ok && l.TargetSym == "" {
+			l.TargetSym = sym
+		}
+	}
+}
+
+// llvmAsm rewrites the GNU assembly s into the dialect that
+// LLVM's assembly parser accepts.
+//
+// A nil arch applies the rewrites for every known architecture.
+func (a *Arch) llvmAsm(s string) string {
+	if a != nil {
+		return rewriteInsn(s, a.llvm)
+	}
+	return rewriteInsn(s, func(m, ops string) (string, string) {
+		return arm64LLVM(x86LLVM(m, ops))
+	})
+}
+
+// normalize strips trailing comments from the GNU assembly s and
+// rewrites its PC-relative operands to a fixed displacement, for
+// Config.Normalize.
+//
+// A nil arch applies the rewrites for every known architecture.
+func (a *Arch) normalize(s string) string {
+	s = a.stripTrailer(s)
+	if a != nil {
+		return rewriteInsn(s, a.pcrel)
+	}
+	return rewriteInsn(s, func(m, ops string) (string, string) {
+		return arm64PCRel(x86PCRel(m, ops))
+	})
+}
+
+// stripTrailer removes the comments, like Go frame annotations,
+// and anything else after the instruction from the GNU assembly s,
+// which would confuse llvm-mca's parser.
+//
+// A nil arch only strips what no known architecture reads as part
+// of an instruction.
+func (a *Arch) stripTrailer(s string) string {
+	trailers := arm64Trailers
+	if a != nil {
+		trailers = a.trailers
+	}
+	for _, t := range trailers {
+		if i := strings.Index(s, t); i >= 0 {
+			s = s[:i]
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+// rewriteInsn rewrites the mnemonic and operands of the GNU
+// assembly s with f, keeping any prefixes.
+func rewriteInsn(s string, f func(mnemonic, operands string) (string, string)) string {
+	s = strings.TrimSpace(s)
+	m, ops := SplitInsn(s)
+	prefix := s[:strings.Index(s, m)]
+	m2, ops2 := f(m, ops)
+	if m2 == m && ops2 == ops {
+		return s
+	}
+	if ops2 == "" {
+		return prefix + m2
+	}
+	return prefix + m2 + " " + ops2
+}
+
+// regMove reports whether l is a plain register-to-register
+// move and, if so, its registers.
+//
+// A nil arch tries every known architecture.
+func (a *Arch) regMove(l Line) (src, dst string, ok bool) {
+	m, ops := l.mnemonic(), l.operands()
+	if a != nil {
+		return a.move(m, ops)
+	}
+	if src, dst, ok = x86Move(m, ops); ok {
+		return src, dst, ok
+	}
+	return arm64Move(m, ops)
+}
+
+// stackAccess reports whether l spills a register to, or
+// reloads a register from, a stack slot.
+//
+// A nil arch tries every known architecture.
+func (a *Arch) stackAccess(l Line) stackAccess {
+	m, ops := l.mnemonic(), l.operands()
+	if a != nil {
+		return a.stack(m, ops)
+	}
+	if k := x86Stack(m, ops); k != stackNone {
+		return k
+	}
+	return arm64Stack(m, ops)
+}
+
+// checkLen reports an error if n bytes is not a plausible length
+// for the encoding of one instruction, which suggests that the
+// line was misparsed, like an encoding that ran into the next
+// column.
+//
+// A nil arch accepts any length.
+func (a *Arch) checkLen(n int) error {
+	if a == nil || a.maxLen == 0 || a.minLen <= n && n <= a.maxLen {
+		return nil
+	}
+	if a.minLen == a.maxLen {
+		return fmt.Errorf("%d-byte encoding, but %s instructions are %d bytes", n, a.Name, a.minLen)
+	}
+	return fmt.Errorf("%d-byte encoding, but %s instructions are %d to %d bytes", n, a.Name, a.minLen, a.maxLen)
+}
+
+// stopAt returns the returns at which fix stops by default.
+//
+// A nil arch returns those of every known architecture.
+func (a *Arch) stopAt() []string {
+	if a != nil {
+		return a.stops
+	}
+	return append(x86Stops[:len(x86Stops):len(x86Stops)], arm64Stops...)
+}
+
+// isStop reports whether l is one of stops, which are either
+// mnemonics or whole instructions.
+func (l Line) isStop(stops []string) bool {
+	m, s := l.mnemonic(), AsmKey(l.GNUAsm)
+	for _, x := range stops {
+		if x == m || x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// mnemonic returns l's GNU assembly mnemonic, without any
+// prefixes.
+func (l Line) mnemonic() string {
+	m, _ := SplitInsn(l.GNUAsm)
+	return m
+}
+
+// operands returns l's GNU assembly operands.
+func (l Line) operands() []string {
+	_, ops := SplitInsn(l.GNUAsm)
+	return SplitOperands(ops)
+}
+
+// SplitOperands splits a GNU assembly operand list at the commas
+// that are not inside parentheses, brackets, or braces.
+func SplitOperands(s string) []string {
+	var ops []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				ops = append(ops, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(s[start:]); rest != "" || len(ops) > 0 {
+		ops = append(ops, rest)
+	}
+	return ops
+}
+
+// IsBranch reports whether l is a conditional branch or an
+// unconditional jump.
+func (l Line) IsBranch() bool {
+	return l.kind == kindBranch || l.kind == kindJump
+}
+
+// IsJump reports whether l is an unconditional jump.
+func (l Line) IsJump() bool {
+	return l.kind == kindJump
+}
+
+// IsCall reports whether l is a subroutine call.
+func (l Line) IsCall() bool {
+	return l.kind == kindCall
+}
+
+// IsReturn reports whether l is a subroutine return.
+func (l Line) IsReturn() bool {
+	return l.kind == kindReturn
+}
+
+// insnPrefixes are x86 instruction prefixes that GNU syntax
+// prints as separate words before the mnemonic.
+var insnPrefixes = map[string]bool{
+	"bnd":     true,
+	"data16":  true,
+	"addr32":  true,
+	"lock":    true,
+	"notrack": true,
+	"rep":     true,
+	"repe":    true,
+	"repne":   true,
+	"repnz":   true,
+	"repz":    true,
+}
+
+// SplitInsn splits GNU assembly into its mnemonic and operands.
+func SplitInsn(s string) (mnemonic, operands string) {
+	s = strings.TrimSpace(s)
+	for {
+		i := strings.IndexAny(s, " \t")
+		if i < 0 {
+			return s, ""
+		}
+		m, rest := s[:i], strings.TrimSpace(s[i+1:])
+		if !insnPrefixes[m] || rest == "" {
+			return m, rest
+		}
+		s = rest
+	}
+}
+
+// parseTarget parses the target of the branch at offset off
+// from its GNU assembly operands.
+//
+// Targets are either absolute ("0x499df1", amd64) or relative
+// to the branch (".+0xc", arm64). Indirect branches through
+// a register or memory have no target. If the disassembler
+// printed the target symbol ("0x401000 <runtime.memmove>") it
+// is returned as sym.
+func parseTarget(off uint64, ops string) (target uint64, ok bool, sym string) {
+	// The target is always the last operand.
+	if i := strings.LastIndexByte(ops, ','); i >= 0 {
+		ops = ops[i+1:]
+	}
+	ops = strings.TrimSpace(ops)
+	if i := strings.IndexByte(ops, '<'); i >= 0 {
+		if j := strings.LastIndexByte(ops, '>'); j > i {
+			sym = ops[i+1 : j]
+		}
+		ops = strings.TrimSpace(ops[:i])
+	}
+	rel := false
+	switch {
+	case strings.HasPrefix(ops, ".+"):
+		ops, rel = ops[len(".+"):], true
+	case strings.HasPrefix(ops, ".-"):
+		ops, rel = "-"+ops[len(".-"):], true
+	}
+	neg := strings.HasPrefix(ops, "-")
+	ops = strings.TrimPrefix(ops, "-")
+	if !strings.HasPrefix(ops, "0x") {
+		return 0, false, sym
+	}
+	x, err := strconv.ParseUint(ops[len("0x"):], 16, 64)
+	if err != nil {
+		return 0, false, sym
+	}
+	if neg {
+		x = -x
+	}
+	if rel {
+		// Relative displacements are printed as 64-bit two's
+		// complement, so wrapping addition is correct.
+		x += off
+	}
+	return x, true, sym
+}
+
+func x86Kind(m string) insnKind {
+	switch m {
+	case "ret", "retq", "retl", "retw", "lret", "lretq", "lretl",
+		"iret", "iretq", "iretl":
+		return kindReturn
+	case "call", "callq", "calll", "callw", "lcall":
+		return kindCall
+	case "jmp", "jmpq", "jmpl", "jmpw", "ljmp":
+		return kindJump
+	case "loop", "loope", "loopne", "loopz", "loopnz":
+		return kindBranch
+	}
+	if strings.HasPrefix(m, "j") {
+		// jcc, jcxz, jecxz, jrcxz.
+		return kindBranch
+	}
+	return kindOther
+}
+
+func arm64Kind(m string) insnKind {
+	switch m {
+	case "ret", "retaa", "retab", "eret":
+		return kindReturn
+	case "bl", "blr", "blraa", "blraaz", "blrab", "blrabz":
+		return kindCall
+	case "b", "br", "braa", "braaz", "brab", "brabz":
+		return kindJump
+	case "cbz", "cbnz", "tbz", "tbnz":
+		return kindBranch
+	}
+	if strings.HasPrefix(m, "b.") {
+		return kindBranch
+	}
+	return kindOther
+}
+
+// x86LLVM rewrites AT&T syntax that LLVM does not accept.
+func x86LLVM(m, ops string) (string, string) {
+	switch m {
+	case "jmpq":
+		// LLVM only accepts the q suffix for indirect jumps:
+		// "jmpq 0x401000" is "jmp 0x401000".
+		if !strings.HasPrefix(ops, "*") {
+			m = "jmp"
+		}
+	case "movsxd":
+		// The AT&T spelling of MOVSXD is MOVSLQ.
+		m = "movslq"
+	case "repn":
+		m = "repne"
+	}
+	// GNU syntax omits a zero absolute address: "mov %eax," is
+	// "mov %eax,0x0" and "mov %gs:,%eax" is "mov %gs:0x0,%eax".
+	if strings.HasSuffix(ops, ",") {
+		ops += "0x0"
+	}
+	for _, seg := range []string{"%cs:", "%ds:", "%es:", "%fs:", "%gs:", "%ss:"} {
+		ops = strings.ReplaceAll(ops, seg+",", seg+"0x0,")
+		if strings.HasSuffix(ops, seg) {
+			ops += "0x0"
+		}
+	}
+	return m, ops
+}
+
+// arm64LLVM rewrites arm64 syntax that LLVM does not accept.
+func arm64LLVM(m, ops string) (string, string) {
+	switch m {
+	case "fcmp", "fcmpe":
+		// LLVM requires a floating-point zero: "fcmp d0, #0.0".
+		if strings.HasSuffix(ops, ", #0") {
+			ops += ".0"
+		}
+	}
+	return m, ops
+}
+
+// x86RIPRel matches the displacement of an x86 RIP-relative
+// memory operand, in hex as objdump prints it or in decimal as
+// llvm-objdump does.
+var x86RIPRel = regexp.MustCompile(`-?(?:0x[0-9a-f]+|[0-9]+)\((%[er]ip)\)`)
+
+// x86PCRel rewrites the displacement of each RIP-relative memory
+// operand to zero. The displacement is always 32 bits wide, so
+// the encoding's size does not change.
+func x86PCRel(m, ops string) (string, string) {
+	return m, x86RIPRel.ReplaceAllString(ops, "0x0($1)")
+}
+
+// arm64PCRel rewrites the address operand of adr, adrp and
+// literal loads to the instruction's own address, ".".
+func arm64PCRel(m, ops string) (string, string) {
+	switch m {
+	case "adr", "adrp", "ldr", "ldrsw", "prfm":
+	default:
+		return m, ops
+	}
+	i := strings.LastIndexByte(ops, ',')
+	if i < 0 || !strings.HasPrefix(strings.TrimSpace(ops[i+1:]), ".") {
+		return m, ops
+	}
+	return m, ops[:i+1] + " ."
+}
+
+// x86Move reports whether the AT&T instruction moves one
+// register to another.
+func x86Move(m string, ops []string) (src, dst string, ok bool) {
+	switch m {
+	case "mov", "movq", "movl", "movw", "movb",
+		"movaps", "movapd", "movups", "movupd", "movdqa", "movdqu",
+		"vmovaps", "vmovapd", "vmovups", "vmovupd", "vmovdqa", "vmovdqu":
+	default:
+		return "", "", false
+	}
+	if len(ops) != 2 || !strings.HasPrefix(ops[0], "%") || !strings.HasPrefix(ops[1], "%") {
+		return "", "", false
+	}
+	// AT&T syntax puts the destination last.
+	return ops[0], ops[1], true
+}
+
+// arm64Move reports whether the arm64 instruction moves one
+// register to another.
+func arm64Move(m string, ops []string) (src, dst string, ok bool) {
+	if m != "mov" && m != "fmov" {
+		return "", "", false
+	}
+	if len(ops) != 2 || !IsARM64Reg(ops[0]) || !IsARM64Reg(ops[1]) {
+		return "", "", false
+	}
+	return ops[1], ops[0], true
+}
+
+// IsARM64Reg reports whether s names an arm64 general-purpose or
+// SIMD register, other than the zero register.
+func IsARM64Reg(s string) bool {
+	if s == "sp" || s == "wsp" {
+		return true
+	}
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		// v0.16b
+		s = s[:i]
+	}
+	if len(s) < 2 || !strings.ContainsRune("xwvqdshb", rune(s[0])) {
+		return false
+	}
+	n, err := strconv.Atoi(s[1:])
+	return err == nil && n >= 0 && n <= 31
+}
+
+// x86Stack reports whether the AT&T instruction moves a register
+// to or from a slot addressed off the stack or frame pointer.
+//
+// Saving and restoring the frame pointer is part of the
+// prologue and epilogue, so it does not count.
+func x86Stack(m string, ops []string) stackAccess {
+	if len(ops) != 2 {
+		return stackNone
+	}
+	switch m {
+	case "mov", "movq", "movl", "movw", "movb",
+		"movss", "movsd", "movaps", "movapd", "movups", "movupd", "movdqa", "movdqu",
+		"vmovss", "vmovsd", "vmovaps", "vmovapd", "vmovups", "vmovupd", "vmovdqa", "vmovdqu":
+	default:
+		return stackNone
+	}
+	src, dst := ops[0], ops[1]
+	switch {
+	case x86IsReg(src) && x86IsStackSlot(dst) && !x86IsFramePointer(src):
+		return stackSpill
+	case x86IsStackSlot(src) && x86IsReg(dst) && !x86IsFramePointer(dst):
+		return stackReload
+	}
+	return stackNone
+}
+
+func x86IsReg(s string) bool {
+	return strings.HasPrefix(s, "%") && !strings.Contains(s, ":")
+}
+
+func x86IsFramePointer(s string) bool {
+	return s == "%rbp" || s == "%ebp"
+}
+
+// x86IsStackSlot reports whether s is a memory operand whose base
+// is the stack or frame pointer, like "0x8(%rsp)".
+func x86IsStackSlot(s string) bool {
+	i := strings.IndexByte(s, '(')
+	if i < 0 {
+		return false
+	}
+	base := strings.TrimSuffix(s[i+1:], ")")
+	if j := strings.IndexByte(base, ','); j >= 0 {
+		base = base[:j]
+	}
+	switch base {
+	case "%rsp", "%esp", "%rbp", "%ebp":
+		return true
+	}
+	return false
+}
+
+// arm64Stack reports whether the arm64 instruction stores or
+// loads a register to or from a slot addressed off sp or the
+// frame pointer.
+//
+// Saving and restoring the frame pointer and link register is
+// part of the prologue and epilogue, and storing the zero
+// register only clears memory, so neither counts.
+func arm64Stack(m string, ops []string) stackAccess {
+	var k stackAccess
+	switch m {
+	case "str", "stur", "stp", "strb", "strh":
+		k = stackSpill
+	case "ldr", "ldur", "ldp", "ldrb", "ldrh", "ldrsw":
+		k = stackReload
+	default:
+		return stackNone
+	}
+	nregs := 1
+	if strings.HasSuffix(m, "p") {
+		nregs = 2
+	}
+	if len(ops) <= nregs {
+		return stackNone
+	}
+	mem := ops[nregs]
+	if !strings.HasPrefix(mem, "[sp") && !strings.HasPrefix(mem, "[x29") {
+		return stackNone
+	}
+	for _, r := range ops[:nregs] {
+		switch r {
+		case "x29", "x30", "xzr", "wzr":
+		default:
+			return k
+		}
+	}
+	return stackNone
+}
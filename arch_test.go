@@ -0,0 +1,248 @@
+package mca
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// classifyTests are lines of "go tool objdump -gnu" output and how
+// they are classified.
+var classifyTests = []struct {
+	arch      *Arch
+	line      string
+	kind      insnKind
+	hasTarget bool
+	target    uint64
+	targetSym string
+}{
+	// amd64
+	{archAMD64, "  bounds.go:97\t\t0x401061\t\teb12\t\t\tJMP 0x401075                         // jmp 0x401075", kindJump, true, 0x401075, ""},
+	{archAMD64, "  runtime.go:63\t\t0x406514\t\te927fdffff\t\tJMP runtime.mapaccess2(SB)           // jmpq 0x406240", kindJump, true, 0x406240, "runtime.mapaccess2"},
+	{archAMD64, "  asm_amd64.s:730\t0x47cd51\t\tffe0\t\t\tJMP AX                               // jmp *%rax", kindJump, false, 0, ""},
+	{archAMD64, "  type.go:324\t\t0x401180\t\tff24d1\t\t\tJMP 0(CX)(DX*8)                      // jmpq *(%rcx,%rdx,8)", kindJump, false, 0, ""},
+	{archAMD64, "  type.go:324\t\t0x401176\t\t773e\t\t\tJA 0x4011b6                          // ja 0x4011b6", kindBranch, true, 0x4011b6, ""},
+	{archAMD64, "  type.go:689\t\t0x4012e8\t\t7202\t\t\tJB 0x4012ec                          // jb 0x4012ec", kindBranch, true, 0x4012ec, ""},
+	{archAMD64, "  bounds.go:109\t\t0x40109b\t\t7519\t\t\tJNE 0x4010b6                         // jne 0x4010b6", kindBranch, true, 0x4010b6, ""},
+	{archAMD64, "  bounds.go:110\t\t0x4010c4\t\te837790700\t\tCALL runtime.gopanic(SB)             // callq 0x478a00", kindCall, true, 0x478a00, "runtime.gopanic"},
+	{archAMD64, "  cpu.go:199\t\t0x401b1b\t\tffd0\t\t\tCALL AX                              // call *%rax", kindCall, false, 0, ""},
+	{archAMD64, "  bounds.go:112\t\t0x4010b5\t\tc3\t\t\tRET                                  // retq", kindReturn, false, 0, ""},
+	{archAMD64, "  main.go:5\t\t0x499e40\t\t4889442408\t\tMOVQ AX, 0x8(SP)                     // mov %rax,0x8(%rsp)", kindOther, false, 0, ""},
+
+	// arm64
+	{archARM64, "  bounds.go:97\t\t0x1105c\t\t\t14000004\t\tJMP 4(PC)                            // b .+0x10", kindJump, true, 0x1106c, ""},
+	{archARM64, "  runtime.go:63\t\t0x15df0\t\t\t17ffff54\t\tJMP runtime.mapaccess2(SB)           // b .+0xfffffffffffffd50", kindJump, true, 0x15b40, "runtime.mapaccess2"},
+	{archARM64, "  type.go:324\t\t0x111a8\t\t\td61f0360\t\tJMP (R27)                            // br x27", kindJump, false, 0, ""},
+	{archARM64, "  type.go:729\t\t0x1167c\t\t\t540000e0\t\tBEQ 7(PC)                            // b.eq .+0x1c", kindBranch, true, 0x11698, ""},
+	{archARM64, "  type.go:665\t\t0x112b4\t\t\t540000a1\t\tBNE 5(PC)                            // b.ne .+0x14", kindBranch, true, 0x112c8, ""},
+	{archARM64, "  type.go:148\t\t0x11120\t\t\tb4000144\t\tCBZ R4, 10(PC)                       // cbz x4, .+0x28", kindBranch, true, 0x11148, ""},
+	{archARM64, "  bounds.go:109\t\t0x11088\t\t\tb5000108\t\tCBNZ R8, 8(PC)                       // cbnz x8, .+0x20", kindBranch, true, 0x110a8, ""},
+	{archARM64, "  bounds.go:93\t\t0x11044\t\t\t360000e6\t\tTBZ $0, R6, 7(PC)                    // tbz w6, #0, .+0x1c", kindBranch, true, 0x11060, ""},
+	{archARM64, "  type.go:652\t\t0x11294\t\t\tb7f80223\t\tTBNZ $63, R3, 17(PC)                 // tbnz x3, #63, .+0x44", kindBranch, true, 0x112d8, ""},
+	{archARM64, "  bounds.go:110\t\t0x110b8\t\t\t9401cac2\t\tCALL runtime.gopanic(SB)             // bl .+0x72b08", kindCall, true, 0x83bc0, "runtime.gopanic"},
+	{archARM64, "  cpu.go:199\t\t0x11b20\t\t\td63f0000\t\tCALL (R0)                            // blr x0", kindCall, false, 0, ""},
+	{archARM64, "  bounds.go:112\t\t0x110a4\t\t\td65f03c0\t\tRET                                  // ret", kindReturn, false, 0, ""},
+	{archARM64, "  main.go:8\t\t0xa1fd4\t\t\taa1f03e2\t\tMOVD ZR, R2                          // mov x2, xzr", kindOther, false, 0, ""},
+}
+
+func TestClassify(t *testing.T) {
+	for _, tc := range classifyTests {
+		l, err := ParseLine(tc.line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q): %v", tc.line, err)
+		}
+		// ParseLine classifies with every architecture, which
+		// must agree with the line's own.
+		for i, a := range []*Arch{nil, tc.arch} {
+			if i > 0 {
+				a.Classify(&l)
+			}
+			if l.kind != tc.kind {
+				t.Errorf("%s: %q: kind = %v, want %v", archName(a), l.GNUAsm, l.kind, tc.kind)
+			}
+			if l.HasTarget != tc.hasTarget || l.Target != tc.target {
+				t.Errorf("%s: %q: target = %#x, %t, want %#x, %t",
+					archName(a), l.GNUAsm, l.Target, l.HasTarget, tc.target, tc.hasTarget)
+			}
+			if l.TargetSym != tc.targetSym {
+				t.Errorf("%s: %q: TargetSym = %q, want %q", archName(a), l.GNUAsm, l.TargetSym, tc.targetSym)
+			}
+		}
+		if got, want := l.IsBranch(), tc.kind == kindBranch || tc.kind == kindJump; got != want {
+			t.Errorf("%q: IsBranch = %t, want %t", l.GNUAsm, got, want)
+		}
+		if got, want := l.IsJump(), tc.kind == kindJump; got != want {
+			t.Errorf("%q: IsJump = %t, want %t", l.GNUAsm, got, want)
+		}
+		if got, want := l.IsCall(), tc.kind == kindCall; got != want {
+			t.Errorf("%q: IsCall = %t, want %t", l.GNUAsm, got, want)
+		}
+		if got, want := l.IsReturn(), tc.kind == kindReturn; got != want {
+			t.Errorf("%q: IsReturn = %t, want %t", l.GNUAsm, got, want)
+		}
+	}
+}
+
+// archName returns a's name for test messages.
+func archName(a *Arch) string {
+	if a == nil {
+		return "all"
+	}
+	return a.Name
+}
+
+func TestDetectArch(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		want *Arch
+	}{
+		{"testdata/dump_amd64.txt", archAMD64},
+		{"testdata/dump_arm64.txt", archARM64},
+	} {
+		syms, err := Parse(strings.NewReader(readFile(t, tc.file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []Line
+		for _, s := range syms {
+			lines = append(lines, s.Lines...)
+		}
+		if got := DetectArch(lines); got != tc.want {
+			t.Errorf("%s: DetectArch = %s, want %s", tc.file, archName(got), archName(tc.want))
+		}
+	}
+
+	// 32-bit registers alone could be either x86.
+	l, err := ParseLine("  main.go:3\t\t0x1000\t\t\t31c0\t\t\tXORL AX, AX                          // xor %eax,%eax")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := DetectArch([]Line{l}); got != nil {
+		t.Errorf("DetectArch(%q) = %s, want nil", l.GNUAsm, archName(got))
+	}
+}
+
+// retInsn matches a return written by Fix.
+var retInsn = regexp.MustCompile(`(?m)^  retq?\s`)
+
+func TestMidFunctionReturn(t *testing.T) {
+	// main.find returns from inside its loop and after it.
+	for _, tc := range []struct {
+		file  string
+		arch  *Arch
+		rets  []uint64
+		after string // the instruction between the returns
+	}{
+		{"testdata/dump_amd64.txt", archAMD64, []uint64{0x4830dd, 0x4830e5}, "mov $-0x1,%rax"},
+		{"testdata/dump_arm64.txt", archARM64, []uint64{0x8d628, 0x8d630}, "mov x0, #0xffffffffffffffff"},
+	} {
+		in := readFile(t, tc.file)
+		syms, err := Parse(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rets []uint64
+		for _, l := range syms[1].Lines {
+			if l.IsReturn() {
+				rets = append(rets, l.Offset)
+			}
+		}
+		if len(rets) != len(tc.rets) || rets[0] != tc.rets[0] || rets[1] != tc.rets[1] {
+			t.Errorf("%s: %s returns at %#x, want %#x", tc.file, syms[1].Name, rets, tc.rets)
+		}
+
+		// StopNone emits all of each symbol, and StopRet stops
+		// each at its first return but starts again at the next.
+		find := Config{Arch: tc.arch, Symbols: regexp.MustCompile(`^main\.find$`)}
+		out := fix(t, find, in)
+		if n := len(retInsn.FindAllString(out, -1)); n != 2 || !strings.Contains(out, tc.after) {
+			t.Errorf("%s: StopNone: got %d returns, want 2 and %q:\n%s", tc.file, n, tc.after, out)
+		}
+		find.Stop = StopRet
+		out = fix(t, find, in)
+		if retInsn.MatchString(out) || strings.Contains(out, tc.after) {
+			t.Errorf("%s: StopRet: emitted past the first return:\n%s", tc.file, out)
+		}
+		out = fix(t, Config{Arch: tc.arch, Stop: StopRet}, in)
+		if n := len(retInsn.FindAllString(out, -1)); n != 0 || !strings.Contains(out, "main_main") {
+			t.Errorf("%s: StopRet: got %d returns, want none and main.main:\n%s", tc.file, n, out)
+		}
+	}
+}
+
+func TestLLVMAsm(t *testing.T) {
+	for _, tc := range []struct {
+		arch *Arch
+		in   string
+		want string
+	}{
+		{archAMD64, "jmpq 0x401000", "jmp 0x401000"},
+		{archAMD64, "jmpq *%rax", "jmpq *%rax"},
+		{archAMD64, "jmpq *0x10(%rax,%rcx,8)", "jmpq *0x10(%rax,%rcx,8)"},
+		{archAMD64, "movsxd %esi,%rsi", "movslq %esi,%rsi"},
+		{archAMD64, "repn scas %es:(%rdi),%al", "repne scas %es:(%rdi),%al"},
+		{archAMD64, "mov %eax,", "mov %eax,0x0"},
+		{arch386, "mov %gs:,%ecx", "mov %gs:0x0,%ecx"},
+		{archAMD64, "mov %rax,%fs:", "mov %rax,%fs:0x0"},
+		{archAMD64, "mov %fs:0xfffffffffffffff8,%rcx", "mov %fs:0xfffffffffffffff8,%rcx"},
+		{archAMD64, "xor %eax,%eax", "xor %eax,%eax"},
+
+		{archARM64, "fcmp d0, #0", "fcmp d0, #0.0"},
+		{archARM64, "fcmpe s1, #0", "fcmpe s1, #0.0"},
+		{archARM64, "fcmp d0, #0.0", "fcmp d0, #0.0"},
+		{archARM64, "fcmp d0, d1", "fcmp d0, d1"},
+		{archARM64, "cmp x0, #0", "cmp x0, #0"},
+
+		// Without an architecture, every rewrite applies.
+		{nil, "movsxd %esi,%rsi", "movslq %esi,%rsi"},
+		{nil, "fcmp d0, #0", "fcmp d0, #0.0"},
+	} {
+		if got := tc.arch.llvmAsm(tc.in); got != tc.want {
+			t.Errorf("%s: llvmAsm(%q) = %q, want %q", archName(tc.arch), tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for _, tc := range []struct {
+		arch *Arch
+		in   string
+		want string
+	}{
+		// arm64, where '#' begins an immediate.
+		{archARM64, "str x0, [sp,#8] // x+0(FP)", "str x0, [sp,#8]"},
+		{archARM64, "str x0, [sp,#8]// x+0(FP)", "str x0, [sp,#8]"},
+		{archARM64, "str x0, [sp,#8]//x+0(FP)", "str x0, [sp,#8]"},
+		{archARM64, "mov x0, #0xffffffffffffffff ; -1", "mov x0, #0xffffffffffffffff"},
+		{archARM64, "add x2, x2, #0x1", "add x2, x2, #0x1"},
+		{archARM64, "adrp x0, .+0x1000 // runtime.firstmoduledata", "adrp x0, ."},
+
+		// amd64
+		{archAMD64, "mov %rax,0x8(%rsp) # x+0(FP)", "mov %rax,0x8(%rsp)"},
+		{archAMD64, "mov %rax,0x8(%rsp)# x+0(FP)", "mov %rax,0x8(%rsp)"},
+		{archAMD64, "mov %rax,0x8(%rsp)#x+0(FP)", "mov %rax,0x8(%rsp)"},
+		{archAMD64, "mov %rax,0x8(%rsp)// x+0(FP)", "mov %rax,0x8(%rsp)"},
+		{archAMD64, "movq $0x1,0x28(%rsp) ; frame", "movq $0x1,0x28(%rsp)"},
+		{archAMD64, "lea 0x15757b(%rip),%rax # runtime.types", "lea 0x0(%rip),%rax"},
+		{archAMD64, "xor %eax,%eax", "xor %eax,%eax"},
+
+		// Without an architecture, '#' might be an immediate.
+		{nil, "mov x0, #0x10 // x", "mov x0, #0x10"},
+		{nil, "mov %rax,0x8(%rsp) # x+0(FP)", "mov %rax,0x8(%rsp) # x+0(FP)"},
+	} {
+		if got := tc.arch.normalize(tc.in); got != tc.want {
+			t.Errorf("%s: normalize(%q) = %q, want %q", archName(tc.arch), tc.in, got, tc.want)
+		}
+	}
+
+	// The first "// " after whitespace begins the GNU assembly,
+	// so the comments after it are part of it until Normalize
+	// strips them.
+	const dump = "TEXT main.f(SB) /tmp/main.go\n" +
+		"  main.go:5\t\t0x8d5d0\t\t\tf90007e0\t\tMOVD R0, 8(RSP)                      // str x0, [sp,#8]// x+0(FP)\n" +
+		"  main.go:6\t\t0x8d5d4\t\t\td10043ff\t\tSUB $16, RSP, RSP                    // sub sp, sp, #0x10 // frame\n"
+	out := fix(t, Config{Arch: archARM64, Normalize: true}, dump)
+	if want := "  str x0, [sp,#8]\n  sub sp, sp, #0x10\n"; !strings.Contains(out, want) {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
@@ -0,0 +1,69 @@
+package mca
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// asmWriter writes the assembly for Config.AsmOut: each symbol as
+// a global label, its instructions' GNU assembly, and the labels
+// of branch targets, with a .text directive first.
+type asmWriter struct {
+	w *bufio.Writer
+	// labels are the offsets of the branch targets to label.
+	labels map[uint64]bool
+}
+
+func newAsmWriter(w io.Writer, labels map[uint64]bool) *asmWriter {
+	a := &asmWriter{w: bufio.NewWriter(w), labels: labels}
+	fmt.Fprint(a.w, "\t.text\n")
+	return a
+}
+
+// symbol begins the symbol with the label name, like "main_f:".
+func (a *asmWriter) symbol(name string) {
+	fmt.Fprintf(a.w, "\n\t.globl %s\n%s\n", strings.TrimSuffix(name, ":"), name)
+}
+
+// insn writes l with the GNU assembly s, preceded by its label
+// if it has one.
+func (a *asmWriter) insn(l Line, s string) {
+	a.drop(l)
+	l.GNUAsm = s
+	if l.IsBranch() && l.HasTarget && a.labels[l.Target] {
+		l.GNUAsm = labelAsm(l)
+	}
+	fmt.Fprintf(a.w, "\t%s\n", l.GNUAsm)
+}
+
+// drop writes the label of l, which is not emitted, so that the
+// branches to it still assemble.
+func (a *asmWriter) drop(l Line) {
+	if a.labels[l.Offset] {
+		fmt.Fprintf(a.w, "%s:\n", labelName(l.Offset))
+	}
+}
+
+// undecoded writes the bytes of l, which the disassembler could
+// not decode, as data.
+func (a *asmWriter) undecoded(l Line) {
+	if len(l.Instr) == 0 {
+		return
+	}
+	a.drop(l)
+	fmt.Fprint(a.w, "\t.byte ")
+	for i, b := range l.Instr {
+		if i > 0 {
+			fmt.Fprint(a.w, ",")
+		}
+		fmt.Fprintf(a.w, "%#02x", b)
+	}
+	fmt.Fprint(a.w, "\n")
+}
+
+// Flush writes any buffered assembly.
+func (a *asmWriter) Flush() error {
+	return a.w.Flush()
+}
@@ -0,0 +1,29 @@
+package mca
+
+import "testing"
+
+func TestCanonicalAsm(t *testing.T) {
+	for _, tc := range []struct {
+		goAsm, gnuAsm string
+		off           uint64
+		want          string
+	}{
+		{"MOVD $runtime.firstmoduledata(SB), R0", "adrp x0, .+0x1000", 0x1010, "adrp x0, runtime.firstmoduledata"},
+		{"MOVD $runtime.firstmoduledata(SB), R0", "adrp x0,.+0x1000", 0x1010, "adrp x0, runtime.firstmoduledata"},
+		// Odd but valid input must not panic.
+		{"MOVD $runtime.firstmoduledata(SB), R0", "adrp x0,", 0x1010, "adrp x0, runtime.firstmoduledata"},
+		{"MOVD $runtime.firstmoduledata(SB), R0", "adr x0", 0x1010, "adr x0"},
+		{"", "adr x1, .+0x20", 0x1010, "adr x1"},
+		{"JMP 4(PC)", "b .+0x10", 0x1010, "b main.f+0x20"},
+		{"BLE 6(PC)", "b.le .+0x18", 0x1010, "b.le main.f+0x28"},
+		{"JMP -2(PC)", "b .+0xfffffffffffffff8", 0x1010, "b main.f+0x8"},
+		{"JMP 0x1000", "jmp 0x1000", 0x1010, "jmp main.f+0x0"},
+		{"LEAQ runtime.types(SB), AX", "lea 0x15757b(%rip),%rax", 0x1010, "lea runtime.types(%rip),%rax"},
+	} {
+		l := Line{Offset: tc.off, GoAsm: tc.goAsm, GNUAsm: tc.gnuAsm}
+		(*Arch)(nil).Classify(&l)
+		if got := canonicalAsm(l, "main.f(SB) /tmp/main.go", 0x1000); got != tc.want {
+			t.Errorf("canonicalAsm(%q) = %q, want %q", tc.gnuAsm, got, tc.want)
+		}
+	}
+}
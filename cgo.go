@@ -0,0 +1,103 @@
+package mca
+
+import (
+	"bytes"
+	"strings"
+)
+
+// C functions linked into a cgo binary are compiled with the
+// system C compiler, which can emit instructions that the Go
+// disassembler does not decode. The disassembler prints each byte
+// of such an instruction as its own "?" line and resumes decoding
+// at the next byte, which can turn the tail of the instruction
+// into a bogus one.
+//
+// The common case is the endbr64 (or endbr32) landing pad that
+// compilers place at the start of every function when control
+// flow protection is enabled. On amd64, f3 0f 1e fa is printed as
+// three "?" lines followed by "cli". On 386, f3 0f 1e fb is
+// printed as two "?" lines followed by "push %ds" and "sti".
+
+// cPrologues are the instructions at the start of C functions
+// that the Go disassembler does not decode, keyed by encoding.
+var cPrologues = map[string]string{
+	"\xf3\x0f\x1e\xfa": "endbr64",
+	"\xf3\x0f\x1e\xfb": "endbr32",
+}
+
+// splitUndecoded parses a line that the disassembler could not
+// decode, like
+//
+//	:0	0x49bea0	f3	?
+func splitUndecoded(s string) (Line, bool) {
+	f := strings.Fields(s)
+	if len(f) != 4 || f[3] != "?" || !strings.HasPrefix(f[1], "0x") {
+		return Line{}, false
+	}
+	i := strings.LastIndexByte(f[0], ':')
+	if i < 0 {
+		return Line{}, false
+	}
+	num, rest, err := readInt(f[0][i+1:])
+	if err != nil || rest != "" {
+		return Line{}, false
+	}
+	off, rest, err := readHexUint(f[1][len("0x"):])
+	if err != nil || rest != "" {
+		return Line{}, false
+	}
+	instr, rest, err := readHex(f[2])
+	if err != nil || rest != "" {
+		return Line{}, false
+	}
+	return Line{
+		File:   f[0][:i],
+		Line:   num,
+		Offset: off,
+		Instr:  instr,
+		GoAsm:  "?",
+	}, true
+}
+
+// joinPrologue joins the undecoded bytes in p with the decoded
+// line l that follows them if together they are a C prologue
+// instruction.
+func joinPrologue(p []Line, l Line) (Line, bool) {
+	if !follows(p, l) {
+		return Line{}, false
+	}
+	var instr []byte
+	for _, b := range p {
+		instr = append(instr, b.Instr...)
+	}
+	instr = append(instr, l.Instr...)
+	m, ok := cPrologues[string(instr)]
+	if !ok {
+		return Line{}, false
+	}
+	j := p[0]
+	j.Instr = instr
+	j.GNUAsm = m
+	return j, true
+}
+
+// follows reports whether l immediately follows the last line
+// in p.
+func follows(p []Line, l Line) bool {
+	return len(p) > 0 && p[len(p)-1].Offset+uint64(len(p[len(p)-1].Instr)) == l.Offset
+}
+
+// isCPrologue reports whether the undecoded bytes in p could be
+// the start of a C prologue instruction.
+func isCPrologue(p []Line) bool {
+	var instr []byte
+	for _, b := range p {
+		instr = append(instr, b.Instr...)
+	}
+	for enc := range cPrologues {
+		if bytes.HasPrefix([]byte(enc), instr) && len(instr) < len(enc) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package mca
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// dropLines returns s without the lines that contain any of sub.
+func dropLines(s string, sub ...string) string {
+	var b strings.Builder
+	for _, l := range strings.SplitAfter(s, "\n") {
+		keep := true
+		for _, x := range sub {
+			if strings.Contains(l, x) {
+				keep = false
+			}
+		}
+		if keep {
+			b.WriteString(l)
+		}
+	}
+	return b.String()
+}
+
+func TestFixCPrologue(t *testing.T) {
+	for _, tc := range []struct {
+		arch *Arch
+		file string
+		want string
+		// tail are the bogus instructions that the
+		// disassembler decodes from the prologue.
+		tail []string
+	}{
+		{archAMD64, "testdata/cgo_amd64.txt", "endbr64", []string{"cli"}},
+		{arch386, "testdata/cgo_386.txt", "endbr32", []string{"push %ds", "sti"}},
+	} {
+		in := readFile(t, tc.file)
+		out := fix(t, Config{Arch: tc.arch}, in)
+		// Each C function begins with the prologue.
+		if got, want := strings.Count(out, ":\n  "+tc.want+"\n"), strings.Count(in, "TEXT "); got != want {
+			t.Errorf("%s: got %d %s prologues, want %d:\n%s", tc.file, got, tc.want, want, out)
+		}
+		for _, s := range append(tc.tail, "undecoded", "?") {
+			if strings.Contains(out, s) {
+				t.Errorf("%s: got %q in\n%s", tc.file, s, out)
+			}
+		}
+	}
+}
+
+func TestFixPartialCPrologue(t *testing.T) {
+	amd64 := readFile(t, "testdata/cgo_amd64.txt")
+	i386 := readFile(t, "testdata/cgo_386.txt")
+	for _, tc := range []struct {
+		name string
+		arch *Arch
+		in   string
+		// sym is the symbol with the broken prologue.
+		sym string
+		// want is the first undecoded line.
+		want string
+	}{
+		{
+			"non-contiguous",
+			archAMD64,
+			dropLines(amd64, "0x49c002\t"),
+			"sum",
+			"// undecoded 0x49c000: f3",
+		},
+		{
+			"mismatch",
+			archAMD64,
+			strings.NewReplacer("fa\t\t\tCLI ", "fc\t\t\tCLD ", "// cli", "// cld").Replace(amd64),
+			"sum",
+			"// undecoded 0x49c000: f3",
+		},
+		{
+			// The input ends in the middle of clamp's
+			// prologue.
+			"truncated",
+			archAMD64,
+			amd64[:strings.Index(amd64, "0x49c043\t")],
+			"clamp",
+			"// undecoded 0x49c040: f3",
+		},
+		{
+			"mismatch 386",
+			arch386,
+			strings.NewReplacer("fb\t\t\tSTI ", "fc\t\t\tCLD ", "// sti", "// cld").Replace(i386),
+			"clamp",
+			"// undecoded 0x8049002: 1e",
+		},
+	} {
+		syms := regexp.MustCompile("^" + tc.sym + "$")
+		out := fix(t, Config{Arch: tc.arch, Symbols: syms}, tc.in)
+		if !strings.Contains(out, tc.want) {
+			t.Errorf("%s: got\n%s\nwant %q", tc.name, out, tc.want)
+		}
+		if strings.Contains(out, "endbr") {
+			t.Errorf("%s: got a prologue in\n%s", tc.name, out)
+		}
+
+		out = fix(t, Config{Arch: tc.arch, Symbols: syms, OnUnknown: UnknownSkip}, tc.in)
+		if strings.Contains(out, "undecoded") {
+			t.Errorf("%s: %s: got\n%s", tc.name, UnknownSkip, out)
+		}
+
+		err := Config{Arch: tc.arch, Symbols: syms, OnUnknown: UnknownError}.Fix(&strings.Builder{}, strings.NewReader(tc.in))
+		if err == nil {
+			t.Errorf("%s: %s: got no error", tc.name, UnknownError)
+		}
+	}
+}
+
+func TestFixCStopRet(t *testing.T) {
+	in := readFile(t, "testdata/cgo_amd64.txt")
+	c := Config{Arch: archAMD64, Stop: StopRet, Symbols: regexp.MustCompile(`^sum$`)}
+	out := fix(t, c, in)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if got := strings.TrimSpace(lines[len(lines)-2]); got != "mov %edx,%eax" {
+		t.Errorf("got last instruction %q, want %q", got, "mov %edx,%eax")
+	}
+	// The padding after sum's first retq, and the code after
+	// it, are dropped.
+	if strings.Contains(out, "nopl (%rax,%rax)") {
+		t.Errorf("got instructions after the first retq:\n%s", out)
+	}
+}
+
+func TestFixCSymbols(t *testing.T) {
+	in := readFile(t, "testdata/cgo_amd64.txt")
+	c := Config{Arch: archAMD64, Labels: true, Symbols: regexp.MustCompile(`^clamp$`)}
+	out := fix(t, c, in)
+	if !strings.HasPrefix(out, "clamp_SB__:\n  endbr64\n") {
+		t.Errorf("got\n%s\nwant clamp", out)
+	}
+	if strings.Contains(out, "sum") || !strings.Contains(out, "cmovge %edx,%eax") {
+		t.Errorf("got\n%s\nwant only clamp", out)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+func analyzeCmd(args []string) error {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s analyze -s REGEXP [options] BINARY [-- LLVM-MCA ARGS]\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	var (
+		symRegs regexpsFlag
+		isa     string
+		mcpu    string
+		top     int
+		insns   bool
+		jsonOut bool
+	)
+	fs.Var(&symRegs, "s", "only analyze symbols matching this regexp (repeatable: symbols matching any of them)")
+	fs.Var(funcFlag{&symRegs}, "func", "only analyze the Go function with this name, like crypto/sha256.block or pkg.(*T).M (repeatable, like -s)")
+	fs.StringVar(&isa, "isa", mca.ISAAuto, "architecture of the disassembly: amd64, 386, arm64 or auto (detect from the binary's header)")
+	fs.StringVar(&mcpu, "mcpu", "", "pass -mcpu to llvm-mca, unless set after --")
+	fs.IntVar(&top, "top", 0, "only print this many of the most expensive source lines (0: all of them)")
+	fs.BoolVar(&insns, "insns", false, "print the latency, throughput and source of each instruction instead of the costs of source lines")
+	fs.BoolVar(&jsonOut, "json", false, "print llvm-mca's -json output instead of a table")
+	toolFlags()
+	cacheFlag()
+	fs.BoolVar(&verbose, "v", false, "print each command that is run to stderr")
+
+	ourArgs, mcaArgs := args, []string(nil)
+	for i, s := range args {
+		if s == "--" {
+			ourArgs, mcaArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+	fs.Parse(ourArgs)
+
+	if len(symRegs) == 0 {
+		return useErr("must set -s or -func flag")
+	}
+	if fs.NArg() == 0 {
+		return useErr("missing binary")
+	}
+	if hasMCAFlag(mcaArgs, "json") {
+		return useErr("analyze always passes -json to llvm-mca")
+	}
+	if top < 0 {
+		return useErr("-top must not be negative")
+	}
+	if insns && top > 0 {
+		return useErr("cannot use both -insns and -top")
+	}
+	if jsonOut && (insns || top > 0) {
+		return useErr("cannot use -json with -insns or -top")
+	}
+	forced, err := parseISA(isa)
+	if err != nil {
+		return err
+	}
+	if err := checkTools("go", "llvm-mca"); err != nil {
+		return err
+	}
+	bin := fs.Arg(0)
+	cfg := mca.Config{
+		Dialect: mca.DialectLLVM,
+		File:    true,
+		Offset:  true,
+		JSON:    true,
+		Warn:    warn,
+	}
+	if cfg.Arch = forced; cfg.Arch == nil {
+		cfg.Arch = detectArch(bin)
+	}
+	mcaArgs = targetArgs(bin, forced, mcpu, mcaArgs)
+
+	out, err := objdump(bin, symRegs.join())
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := cfg.Fix(&buf, bytes.NewReader(out)); err != nil {
+		return err
+	}
+	var syms []mca.JSONSymbol
+	if err := json.Unmarshal(buf.Bytes(), &syms); err != nil {
+		return err
+	}
+	raw, report, regions, err := analyzeRegions(syms, mcaArgs)
+	if err != nil {
+		return err
+	}
+	if jsonOut {
+		_, err := os.Stdout.Write(raw)
+		return err
+	}
+	text := sourceText(bin, symRegs.join())
+	if insns {
+		return writeInsnCosts(os.Stdout, report, regions, text)
+	}
+	costs := lineCosts(report, regions, text)
+	var total float64
+	for _, c := range costs {
+		total += c.cycles
+	}
+	if top > 0 && len(costs) > top {
+		costs = costs[:top]
+	}
+	return writeLineCosts(os.Stdout, costs, total)
+}
+
+// sourceText returns the source text of the instructions in the
+// symbols in bin that match symReg, keyed by offset, from "go tool
+// objdump -S -gnu". Instructions whose source objdump could not read
+// have none.
+//
+// With -S, objdump prints each instruction's source line instead
+// of its position, so its output cannot replace the -gnu output.
+func sourceText(bin, symReg string) map[uint64]string {
+	text := make(map[uint64]string)
+	out, err := goOutput(bin, []string{"tool", "objdump", "-S", "-gnu", "-s", symReg, bin}, nil)
+	if err != nil {
+		return text
+	}
+	var last string
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		t := s.Text()
+		if strings.HasPrefix(t, "TEXT ") {
+			last = ""
+			continue
+		}
+		l, err := mca.ParseLine(t)
+		if err != nil {
+			// A source line, which applies to the
+			// instructions after it.
+			if t := strings.TrimSpace(t); t != "" {
+				last = t
+			}
+			continue
+		}
+		text[l.Offset] = last
+	}
+	return text
+}
+
+// mcaJSON is the part of llvm-mca's -json output that analyze
+// reads.
+type mcaJSON struct {
+	CodeRegions []jsonRegion
+}
+
+// jsonRegion is one code region of llvm-mca's -json output.
+type jsonRegion struct {
+	Name                string
+	InstructionInfoView struct {
+		InstructionList []struct {
+			Instruction     int
+			Latency         int
+			NumMicroOpcodes int
+			RThroughput     float64
+		}
+	}
+	ResourcePressureView struct {
+		ResourcePressureInfo []struct {
+			InstructionIndex int
+			ResourceUsage    float64
+		}
+	}
+	// TimelineView is only reported with -timeline.
+	TimelineView struct {
+		// TimelineInfo has the cycles of each instruction in
+		// each of the first iterations, in order.
+		TimelineInfo []struct {
+			CycleDispatched int
+			CycleReady      int
+			CycleIssued     int
+			CycleExecuted   int
+			CycleRetired    int
+		}
+	}
+}
+
+// costs returns the resource cycles per iteration of each of the
+// region's n instructions.
+func (r *jsonRegion) costs(n int) []float64 {
+	// llvm-mca also reports the total, as one past the last
+	// instruction.
+	cycles := make([]float64, n)
+	for _, p := range r.ResourcePressureView.ResourcePressureInfo {
+		if p.InstructionIndex < n {
+			cycles[p.InstructionIndex] += p.ResourceUsage
+		}
+	}
+	return cycles
+}
+
+// waits returns the average number of cycles that each of the
+// region's n instructions waited in the scheduler between being
+// dispatched and issued, or nil if llvm-mca did not report a
+// timeline.
+func (r *jsonRegion) waits(n int) []float64 {
+	info := r.TimelineView.TimelineInfo
+	if n == 0 || len(info) < n {
+		return nil
+	}
+	iters := len(info) / n
+	waits := make([]float64, n)
+	for i := 0; i < iters*n; i++ {
+		t := info[i]
+		waits[i%n] += float64(t.CycleIssued - t.CycleDispatched)
+	}
+	for i := range waits {
+		waits[i] /= float64(iters)
+	}
+	return waits
+}
+
+// analyzeRegions analyzes each of syms as its own code region
+// with "llvm-mca -json" and returns llvm-mca's output, the parsed
+// report, and the instructions of each of its code regions.
+func analyzeRegions(syms []mca.JSONSymbol, args []string) ([]byte, *mcaJSON, [][]mca.JSONLine, error) {
+	var (
+		in    bytes.Buffer
+		lines [][]mca.JSONLine
+	)
+	for _, s := range syms {
+		if len(s.Instructions) == 0 {
+			continue
+		}
+		fmt.Fprintf(&in, "# LLVM-MCA-BEGIN %s\n%s:\n", s.Symbol, s.Symbol)
+		for _, l := range s.Instructions {
+			fmt.Fprintf(&in, "\t%s\n", l.GNUAsm)
+		}
+		fmt.Fprint(&in, "# LLVM-MCA-END\n")
+		lines = append(lines, s.Instructions)
+	}
+	if len(lines) == 0 {
+		return nil, nil, nil, fmt.Errorf("no instructions to analyze")
+	}
+	cmd := command(mcaTool, append(args[:len(args):len(args)], "-json")...)
+	cmd.Stdin = &in
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, nil, nil, mcaErr(err)
+	}
+	var report mcaJSON
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, nil, nil, fmt.Errorf("llvm-mca -json: %w", err)
+	}
+	if len(report.CodeRegions) != len(lines) {
+		return nil, nil, nil, fmt.Errorf("llvm-mca reported %d code regions, not %d", len(report.CodeRegions), len(lines))
+	}
+	return out, &report, lines, nil
+}
+
+// linePos returns the source position of l, or "?".
+func linePos(l mca.JSONLine) string {
+	if l.File == "" {
+		return "?"
+	}
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// lineCost is the estimated cost of the instructions from one
+// source line.
+type lineCost struct {
+	pos    string
+	cycles float64
+	instrs int
+	// latency is the sum of the instructions' latencies.
+	latency int
+	// text is the line's source text, if known.
+	text string
+}
+
+// lineCosts returns the resource cycles per iteration of each
+// source line in report, most expensive first. regions are the
+// instructions of each of its code regions and text is their
+// source text, keyed by offset.
+func lineCosts(report *mcaJSON, regions [][]mca.JSONLine, text map[uint64]string) []lineCost {
+	byPos := make(map[string]*lineCost)
+	var costs []*lineCost
+	for i := range report.CodeRegions {
+		r := &report.CodeRegions[i]
+		cycles := r.costs(len(regions[i]))
+		latency := make([]int, len(regions[i]))
+		for _, info := range r.InstructionInfoView.InstructionList {
+			if info.Instruction < len(latency) {
+				latency[info.Instruction] = info.Latency
+			}
+		}
+		for j, l := range regions[i] {
+			pos := linePos(l)
+			c, ok := byPos[pos]
+			if !ok {
+				c = &lineCost{pos: pos}
+				byPos[pos] = c
+				costs = append(costs, c)
+			}
+			c.cycles += cycles[j]
+			c.latency += latency[j]
+			c.instrs++
+			if c.text == "" && l.Offset != nil {
+				c.text = text[*l.Offset]
+			}
+		}
+	}
+	sort.SliceStable(costs, func(i, j int) bool {
+		return costs[i].cycles > costs[j].cycles
+	})
+	res := make([]lineCost, len(costs))
+	for i, c := range costs {
+		res[i] = *c
+	}
+	return res
+}
+
+// writeLineCosts writes costs as a table, with each line's share
+// of total cycles.
+func writeLineCosts(w io.Writer, costs []lineCost, total float64) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "source\tcycles\tshare\tinstrs\tlatency\ttext\n")
+	for _, c := range costs {
+		share := 0.0
+		if total > 0 {
+			share = 100 * c.cycles / total
+		}
+		fmt.Fprintf(tw, "%s\t%.2f\t%.1f%%\t%d\t%d\t%s\n", c.pos, c.cycles, share, c.instrs, c.latency, c.text)
+	}
+	return tw.Flush()
+}
+
+// writeInsnCosts writes a table of each instruction in report
+// for each of its code regions, with the region's name first.
+// The wait column, the average cycles that the instruction waited
+// to be issued, is only written if llvm-mca reported a timeline.
+func writeInsnCosts(w io.Writer, report *mcaJSON, regions [][]mca.JSONLine, text map[uint64]string) error {
+	bw := bufio.NewWriter(w)
+	for i := range report.CodeRegions {
+		r := &report.CodeRegions[i]
+		lines := regions[i]
+		cycles := r.costs(len(lines))
+		waits := r.waits(len(lines))
+		if i > 0 {
+			fmt.Fprint(bw, "\n")
+		}
+		fmt.Fprintf(bw, "%s:\n", r.Name)
+		tw := tabwriter.NewWriter(bw, 0, 8, 2, ' ', 0)
+		fmt.Fprint(tw, "source\tlatency\trthroughput\tuops\tcycles\t")
+		if waits != nil {
+			fmt.Fprint(tw, "wait\t")
+		}
+		fmt.Fprint(tw, "instruction\ttext\n")
+		for _, info := range r.InstructionInfoView.InstructionList {
+			j := info.Instruction
+			if j >= len(lines) {
+				continue
+			}
+			l := lines[j]
+			fmt.Fprintf(tw, "%s\t%d\t%.2f\t%d\t%.2f\t", linePos(l), info.Latency, info.RThroughput, info.NumMicroOpcodes, cycles[j])
+			if waits != nil {
+				fmt.Fprintf(tw, "%.1f\t", waits[j])
+			}
+			var t string
+			if l.Offset != nil {
+				t = text[*l.Offset]
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", l.GNUAsm, t)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+// analyzeReport returns the parsed testdata/analyze_amd64.json and
+// the instructions of each of its code regions, as analyze reads
+// them. The report is the output of analyzeRegions for
+// ../../testdata/dump_amd64.txt with
+//
+//	-mtriple=x86_64-unknown-linux-gnu -mcpu=skylake -timeline -timeline-max-iterations=2
+func analyzeReport(t *testing.T) (*mcaJSON, [][]mca.JSONLine) {
+	t.Helper()
+	dump, err := os.ReadFile("../../testdata/dump_amd64.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := mca.LookupArch("amd64")
+	cfg := mca.Config{Arch: a, Dialect: mca.DialectLLVM, File: true, Offset: true, JSON: true}
+	var buf bytes.Buffer
+	if err := cfg.Fix(&buf, bytes.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+	var syms []mca.JSONSymbol
+	if err := json.Unmarshal(buf.Bytes(), &syms); err != nil {
+		t.Fatal(err)
+	}
+	var regions [][]mca.JSONLine
+	for _, s := range syms {
+		regions = append(regions, s.Instructions)
+	}
+
+	raw, err := os.ReadFile("testdata/analyze_amd64.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report mcaJSON
+	if err := json.Unmarshal(raw, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.CodeRegions) != len(regions) {
+		t.Fatalf("got %d code regions, want %d", len(report.CodeRegions), len(regions))
+	}
+	return &report, regions
+}
+
+func TestLineCosts(t *testing.T) {
+	report, regions := analyzeReport(t)
+	// The source text of main.go:24, the most expensive line,
+	// is that of its first instruction.
+	text := make(map[uint64]string)
+	for _, l := range regions[2] {
+		if l.Line == 24 {
+			text[*l.Offset] = "x := []int{1, 2, 3}"
+			break
+		}
+	}
+	costs := lineCosts(report, regions, text)
+
+	// Ties are in order of each line's first instruction.
+	want := []lineCost{
+		{"main.go:24", 23, 14, 24, "x := []int{1, 2, 3}"},
+		{"main.go:22", 13, 7, 15, ""},
+		{"main.go:14", 6, 7, 10, ""},
+		{"main.go:23", 6, 3, 3, ""},
+		{"main.go:25", 6, 3, 14, ""},
+		{"main.go:7", 5, 7, 9, ""},
+		{"main.go:10", 4, 2, 8, ""},
+		{"main.go:16", 4, 2, 8, ""},
+		{"main.go:19", 4, 2, 8, ""},
+		{"main.go:5", 2, 1, 1, ""},
+		{"main.go:8", 2, 2, 4, ""},
+		{"main.go:13", 2, 1, 1, ""},
+		{"main.go:15", 1, 1, 1, ""},
+	}
+	if !reflect.DeepEqual(costs, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", costs, want)
+	}
+
+	// Every instruction is counted once, and the lines' cycles
+	// add up to the regions'.
+	var instrs, n int
+	var cycles, total float64
+	for _, c := range costs {
+		instrs += c.instrs
+		cycles += c.cycles
+	}
+	for i, r := range report.CodeRegions {
+		n += len(regions[i])
+		for _, c := range r.costs(len(regions[i])) {
+			total += c
+		}
+	}
+	if instrs != n || cycles != total {
+		t.Errorf("got %d instructions and %.2f cycles, want %d and %.2f", instrs, cycles, n, total)
+	}
+
+	var buf bytes.Buffer
+	if err := writeLineCosts(&buf, costs[:2], total); err != nil {
+		t.Fatal(err)
+	}
+	wantOut := "source      cycles  share  instrs  latency  text\n" +
+		"main.go:24  23.00   29.5%  14      24       x := []int{1, 2, 3}\n" +
+		"main.go:22  13.00   16.7%  7       15       \n"
+	if got := buf.String(); got != wantOut {
+		t.Errorf("got\n%s\nwant\n%s", got, wantOut)
+	}
+}
+
+func TestLineCostsUnknownPosition(t *testing.T) {
+	// Instructions without a position are totaled as "?".
+	var report mcaJSON
+	json.Unmarshal([]byte(`{"CodeRegions": [{
+		"ResourcePressureView": {"ResourcePressureInfo": [
+			{"InstructionIndex": 0, "ResourceUsage": 0.5},
+			{"InstructionIndex": 1, "ResourceUsage": 1},
+			{"InstructionIndex": 1, "ResourceUsage": 1},
+			{"InstructionIndex": 2, "ResourceUsage": 9}
+		]}
+	}]}`), &report)
+	regions := [][]mca.JSONLine{{
+		{GNUAsm: "xor %eax,%eax"},
+		{File: "main.go", Line: 3, GNUAsm: "add %eax,%ecx"},
+	}}
+	// The last pressure is the total, one past the last
+	// instruction.
+	want := []lineCost{{pos: "main.go:3", cycles: 2, instrs: 1}, {pos: "?", cycles: 0.5, instrs: 1}}
+	if got := lineCosts(&report, regions, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestWaits(t *testing.T) {
+	report, regions := analyzeReport(t)
+	r := &report.CodeRegions[0]
+	want := []float64{5, 0, 0, 1, 4.5, 9.5, 12, 1, 2, 2.5, 12.5, 1}
+	if got := r.waits(len(regions[0])); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Without -timeline, there are no waits.
+	r.TimelineView.TimelineInfo = nil
+	if got := r.waits(len(regions[0])); got != nil {
+		t.Errorf("without a timeline: got %v", got)
+	}
+
+	var buf bytes.Buffer
+	if err := writeInsnCosts(&buf, report, regions, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The headers of the main.sum and main.find tables.
+	lines := strings.Split(buf.String(), "\n")
+	if want := "main_sum_SB__fx_main_go:"; lines[0] != want || strings.Contains(lines[1], "wait") {
+		t.Errorf("got\n%s\nwant %s without a wait column", buf.String(), want)
+	}
+	if i := len(regions[0]) + 4; lines[i-1] != "main_find_SB__fx_main_go:" || !strings.Contains(lines[i], "  wait  ") {
+		t.Errorf("got\n%s\nwant main_find_SB__fx_main_go with a wait column", buf.String())
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+// job is one entry in a batch job file.
+type job struct {
+	// Binary is the path to the binary. Relative paths are
+	// relative to the job file.
+	Binary string `json:"binary"`
+	// Symbol is a regexp matching the symbols to analyze.
+	Symbol string `json:"symbol"`
+	// MaxCycles, if positive, is the most total cycles that
+	// llvm-mca may report for the job to pass.
+	MaxCycles int `json:"max_cycles"`
+	// Args are extra llvm-mca arguments, appended to the
+	// arguments given on the command line.
+	Args []string `json:"args"`
+}
+
+// jobResult is the outcome of running a job.
+type jobResult struct {
+	cycles int
+	err    error
+}
+
+func batchCmd(args []string) error {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s batch [options] JOBFILE [-- LLVM-MCA ARGS]\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	var (
+		jobs    int
+		dialect string
+	)
+	fs.IntVar(&jobs, "j", runtime.NumCPU(), "number of jobs to run at once")
+	fs.StringVar(&dialect, "dialect", mca.DialectLLVM, "assembly dialect fed to llvm-mca: gnu or llvm")
+	toolFlags()
+
+	ourArgs := args
+	var mcaArgs []string
+	for i, s := range args {
+		if s == "--" {
+			ourArgs, mcaArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+	fs.Parse(ourArgs)
+
+	if dialect != mca.DialectGNU && dialect != mca.DialectLLVM {
+		return useErrf("unknown -dialect %q", dialect)
+	}
+	if jobs < 1 {
+		return useErr("-j must be at least 1")
+	}
+	if fs.NArg() == 0 {
+		return useErr("missing job file")
+	}
+	if err := checkTools("go", "llvm-mca"); err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	list, err := readJobs(path)
+	if err != nil {
+		return err
+	}
+
+	cfg := mca.Config{Dialect: dialect, Warn: warn}
+	results := make([]jobResult, len(list))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, j := range list {
+		i, j := i, j
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = j.run(cfg, mcaArgs)
+		}()
+	}
+	wg.Wait()
+
+	bw := bufio.NewWriter(os.Stdout)
+	failed := 0
+	for i, j := range list {
+		r := results[i]
+		switch {
+		case r.err != nil:
+			failed++
+			fmt.Fprintf(bw, "FAIL\t%s\t%s\t%v\n", j.Binary, j.Symbol, r.err)
+		case j.MaxCycles > 0 && r.cycles > j.MaxCycles:
+			failed++
+			fmt.Fprintf(bw, "FAIL\t%s\t%s\t%d cycles (max %d)\n", j.Binary, j.Symbol, r.cycles, j.MaxCycles)
+		case j.MaxCycles > 0:
+			fmt.Fprintf(bw, "PASS\t%s\t%s\t%d cycles (max %d)\n", j.Binary, j.Symbol, r.cycles, j.MaxCycles)
+		default:
+			fmt.Fprintf(bw, "PASS\t%s\t%s\t%d cycles\n", j.Binary, j.Symbol, r.cycles)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed", failed, len(list))
+	}
+	return nil
+}
+
+// readJobs reads the JSON job file at path, which is an array of
+// jobs:
+//
+//	[
+//		{"binary": "bin/app", "symbol": "^main\\.f$", "max_cycles": 1000},
+//		{"binary": "bin/app", "symbol": "^main\\.g$", "args": ["-mcpu=skylake"]}
+//	]
+func readJobs(path string) ([]job, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []job
+	d := json.NewDecoder(bytes.NewReader(buf))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&list); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	for i := range list {
+		j := &list[i]
+		if j.Binary == "" || j.Symbol == "" {
+			return nil, fmt.Errorf("%s: job %d: binary and symbol are required", path, i)
+		}
+		if !filepath.IsAbs(j.Binary) {
+			j.Binary = filepath.Join(dir, j.Binary)
+		}
+	}
+	return list, nil
+}
+
+// run analyzes the job's symbols and returns llvm-mca's total
+// cycles.
+//
+// The job fails if llvm-mca reports problems with the fixed input,
+// since it skips what it cannot parse and the cycles would be
+// those of the rest.
+func (j job) run(cfg mca.Config, mcaArgs []string) jobResult {
+	out, err := objdump(j.Binary, j.Symbol)
+	if err != nil {
+		return jobResult{err: fmt.Errorf("objdump: %w", err)}
+	}
+	if len(out) == 0 {
+		return jobResult{err: fmt.Errorf("no symbols match %s", j.Symbol)}
+	}
+	// Each binary can be for a different target, none of
+	// which need be the host's.
+	cfg.Arch = detectArch(j.Binary)
+	args := append(mcaArgs[:len(mcaArgs):len(mcaArgs)], j.Args...)
+	args = targetArgs(j.Binary, nil, "", args)
+
+	var in, stderr bytes.Buffer
+	if err := cfg.Fix(&in, bytes.NewReader(out)); err != nil {
+		return jobResult{err: err}
+	}
+	cmd := command(mcaTool, args...)
+	cmd.Stdin = &in
+	cmd.Stderr = &stderr
+	report, err := cmd.Output()
+	if diags := diagnostics(stderr.Bytes()); len(diags) > 0 {
+		return jobResult{err: fmt.Errorf("llvm-mca reported %d problems with the input, the first: %v", len(diags), diags[0])}
+	}
+	if err != nil {
+		os.Stderr.Write(stderr.Bytes())
+		return jobResult{err: mcaErr(err)}
+	}
+	cycles, ok := totalCycles(report)
+	if !ok {
+		return jobResult{err: fmt.Errorf("llvm-mca did not report total cycles")}
+	}
+	return jobResult{cycles: cycles}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadJobs(t *testing.T) {
+	dir := t.TempDir()
+	abs := filepath.Join(dir, "abs", "app")
+	for _, tc := range []struct {
+		name string
+		in   string
+		want []job
+		// err is part of the wanted error, if any.
+		err string
+	}{
+		{
+			name: "jobs",
+			in: `[
+				{"binary": "bin/app", "symbol": "^main\\.f$", "max_cycles": 1000},
+				{"binary": "` + filepath.ToSlash(abs) + `", "symbol": "^main\\.g$", "args": ["-mcpu=skylake"]}
+			]`,
+			want: []job{
+				{Binary: filepath.Join(dir, "bin", "app"), Symbol: `^main\.f$`, MaxCycles: 1000},
+				{Binary: abs, Symbol: `^main\.g$`, Args: []string{"-mcpu=skylake"}},
+			},
+		},
+		{name: "empty", in: `[]`, want: []job{}},
+		{
+			name: "unknown field",
+			in:   `[{"binary": "app", "symbol": "f", "max_cycle": 10}]`,
+			err:  `unknown field "max_cycle"`,
+		},
+		{
+			name: "missing symbol",
+			in:   `[{"binary": "app"}, {"binary": "app", "symbol": "f"}]`,
+			err:  "job 0: binary and symbol are required",
+		},
+		{
+			name: "missing binary",
+			in:   `[{"binary": "app", "symbol": "f"}, {"symbol": "f"}]`,
+			err:  "job 1: binary and symbol are required",
+		},
+		{name: "object", in: `{"binary": "app", "symbol": "f"}`, err: "cannot unmarshal"},
+		{name: "truncated", in: `[{"binary": "app"`, err: "EOF"},
+	} {
+		path := filepath.Join(dir, "jobs.json")
+		if err := os.WriteFile(path, []byte(tc.in), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readJobs(path)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: got error %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
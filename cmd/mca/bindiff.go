@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+func diffCmd(args []string) error {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s diff -s REGEXP [options] OLD-BINARY NEW-BINARY\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	var (
+		symRegs regexpsFlag
+		context int
+	)
+	fs.Var(&symRegs, "s", "only compare symbols matching this regexp (repeatable: symbols matching any of them)")
+	fs.Var(funcFlag{&symRegs}, "func", "only compare the Go function with this name, like crypto/sha256.block or pkg.(*T).M (repeatable, like -s)")
+	fs.IntVar(&context, "U", 3, "number of lines of context in the unified diff")
+	toolFlags()
+	fs.Parse(args)
+
+	if len(symRegs) == 0 {
+		return useErr("must set -s or -func flag")
+	}
+	if fs.NArg() != 2 {
+		return useErr("need an old and a new binary")
+	}
+	if context < 0 {
+		return useErr("-U must not be negative")
+	}
+	if err := checkTools("go"); err != nil {
+		return err
+	}
+	oldBin, newBin := fs.Arg(0), fs.Arg(1)
+	before, err := canonicalDump(oldBin, symRegs.join())
+	if err != nil {
+		return err
+	}
+	after, err := canonicalDump(newBin, symRegs.join())
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(os.Stdout)
+	differ := writeUnified(bw, oldBin, newBin, before, after, context)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if differ {
+		return fmt.Errorf("%s and %s differ", oldBin, newBin)
+	}
+	return nil
+}
+
+// canonicalDump returns the lines of fix -canonical output for
+// the symbols in bin that match symReg.
+func canonicalDump(bin, symReg string) ([]string, error) {
+	out, err := objdump(bin, symReg)
+	if err != nil {
+		return nil, err
+	}
+	cfg := mca.Config{
+		Canonical: true,
+		Arch:      detectArch(bin),
+		Warn:      warn,
+	}
+	var buf bytes.Buffer
+	if err := cfg.Fix(&buf, bytes.NewReader(out)); err != nil {
+		return nil, fmt.Errorf("%s: %w", bin, err)
+	}
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), nil
+}
+
+// diffOp is one line of a diff: kept (' '), removed ('-') or
+// added ('+').
+type diffOp struct {
+	kind byte
+	text string
+	// a and b are the number of lines of each side before this
+	// one.
+	a, b int
+}
+
+// writeUnified writes a unified diff of a and b, with context
+// lines around each change, and reports whether they differ.
+func writeUnified(w io.Writer, aName, bName string, a, b []string, context int) bool {
+	inA, inB := mca.LCS(a, b)
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && !inA[i]:
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		case j < len(b) && !inB[j]:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		default:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		}
+	}
+
+	differ := false
+	for k := 0; k < len(ops); {
+		if ops[k].kind == ' ' {
+			k++
+			continue
+		}
+		if !differ {
+			fmt.Fprintf(w, "--- %s\n+++ %s\n", aName, bName)
+			differ = true
+		}
+		// Extend the hunk until context unchanged lines
+		// separate it from the next change.
+		start := k - context
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			n := 0
+			for end+n < len(ops) && ops[end+n].kind == ' ' {
+				n++
+			}
+			if end+n == len(ops) || n > 2*context {
+				if n > context {
+					n = context
+				}
+				end += n
+				break
+			}
+			end += n
+		}
+		var na, nb int
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				na++
+			}
+			if op.kind != '-' {
+				nb++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(ops[start].a, na), hunkRange(ops[start].b, nb))
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(w, "%c%s\n", op.kind, op.text)
+		}
+		k = end
+	}
+	return differ
+}
+
+// hunkRange returns the range of n lines after the first start
+// lines of a file in a unified diff hunk header. Like diff -u, a
+// range of one line is just its line number.
+func hunkRange(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
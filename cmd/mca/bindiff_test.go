@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+func TestWriteUnified(t *testing.T) {
+	for _, tc := range []struct {
+		// a and b have one line per letter.
+		a, b    string
+		context int
+		want    string
+	}{
+		{"abc", "abc", 3, ""},
+		{
+			"abcdefghij", "abcdeXfghij", 3,
+			"@@ -3,6 +3,7 @@\n c\n d\n e\n+X\n f\n g\n h\n",
+		},
+		{
+			// Changes separated by twice the context are one
+			// hunk...
+			"abcdefghijklmnop", "aBcdefghIjklmnop", 3,
+			"@@ -1,12 +1,12 @@\n a\n-b\n+B\n c\n d\n e\n f\n g\n h\n-i\n+I\n j\n k\n l\n",
+		},
+		{
+			// ...and separated by more are two.
+			"abcdefghijklmnop", "aBcdefghiJklmnop", 3,
+			"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+				"@@ -7,7 +7,7 @@\n g\n h\n i\n-j\n+J\n k\n l\n m\n",
+		},
+		{"", "ab", 3, "@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"ab", "", 3, "@@ -1,2 +0,0 @@\n-a\n-b\n"},
+		{"abcdef", "abXdef", 0, "@@ -3 +3 @@\n-c\n+X\n"},
+		{"abcdef", "Xabcdef", 1, "@@ -1 +1,2 @@\n+X\n a\n"},
+		{"abcdef", "abcdefX", 1, "@@ -6 +6,2 @@\n f\n+X\n"},
+	} {
+		var buf bytes.Buffer
+		differ := writeUnified(&buf, "old", "new", letters(tc.a), letters(tc.b), tc.context)
+		want := tc.want
+		if want != "" {
+			want = "--- old\n+++ new\n" + want
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("%q, %q, -U %d: got\n%s\nwant\n%s", tc.a, tc.b, tc.context, got, want)
+		}
+		if differ != (tc.want != "") {
+			t.Errorf("%q, %q: got differ = %t", tc.a, tc.b, differ)
+		}
+	}
+}
+
+// letters returns the letters of s.
+func letters(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "")
+}
+
+func TestWriteUnifiedCanonical(t *testing.T) {
+	// Code added to sum moves the functions after it, but
+	// their canonical forms are unchanged. In sum, only the
+	// changed instructions and the jump over them differ.
+	canonical := func(path string) []string {
+		in, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, _ := mca.LookupArch("amd64")
+		var buf bytes.Buffer
+		cfg := mca.Config{Arch: a, Canonical: true}
+		if err := cfg.Fix(&buf, bytes.NewReader(in)); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	}
+	var buf bytes.Buffer
+	writeUnified(&buf, "old", "new", canonical("../../testdata/old_amd64.txt"), canonical("../../testdata/dump_amd64.txt"), 0)
+	var changed []string
+	for _, l := range strings.Split(buf.String(), "\n") {
+		if (strings.HasPrefix(l, "-") || strings.HasPrefix(l, "+")) &&
+			!strings.HasPrefix(l, "---") && !strings.HasPrefix(l, "+++") {
+			changed = append(changed, l)
+		}
+	}
+	want := []string{
+		"-  jmp main.sum+0x12",
+		"-  add (%rax,%rcx,8),%rdx",
+		"+  jmp main.sum+0x19",
+		"+  mov (%rax,%rcx,8),%rsi",
+		"+  imul %rsi,%rsi",
+		"+  add %rsi,%rdx",
+	}
+	if strings.Join(changed, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant the changes\n%s", buf.String(), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func buildCmd(args []string) error {
+	var b buildFlags
+	fs.StringVar(&b.gcflags, "gcflags", "", "pass -gcflags to go build")
+	fs.StringVar(&b.ldflags, "ldflags", "", "pass -ldflags to go build")
+	fs.StringVar(&b.tags, "tags", "", "pass -tags to go build")
+	fs.StringVar(&b.flags, "build-flags", "", "space-separated extra flags for go build, like -trimpath")
+	return runCmd(args, &b)
+}
+
+// buildFlags are the go build flags of the build command.
+type buildFlags struct {
+	gcflags string
+	ldflags string
+	tags    string
+	flags   string
+}
+
+// args returns the arguments to go that build pkg to out.
+func (b *buildFlags) args(pkg, out string) []string {
+	args := []string{"build", "-o", out}
+	if b.gcflags != "" {
+		args = append(args, "-gcflags="+b.gcflags)
+	}
+	if b.ldflags != "" {
+		args = append(args, "-ldflags="+b.ldflags)
+	}
+	if b.tags != "" {
+		args = append(args, "-tags="+b.tags)
+	}
+	args = append(args, strings.Fields(b.flags)...)
+	return append(args, pkg)
+}
+
+// check checks that the extra go build flags do not conflict
+// with those that build passes.
+func (b *buildFlags) check() error {
+	for _, f := range strings.Fields(b.flags) {
+		if name := strings.TrimLeft(f, "-"); name == "o" || strings.HasPrefix(name, "o=") {
+			return useErr("-build-flags: the binary is always built to a temporary file")
+		}
+	}
+	return nil
+}
+
+// build builds pkg to a temporary binary and returns its path and
+// a function that removes it.
+func (b *buildFlags) build(pkg string) (string, func(), error) {
+	if err := b.check(); err != nil {
+		return "", nil, err
+	}
+	dir, err := ioutil.TempDir("", "mca-build")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	out := filepath.Join(dir, "bin")
+	cmd := command(goTool, b.args(pkg, out)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("go build %s: %w", pkg, err)
+	}
+	return out, cleanup, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// cacheDir, if set by -cache, is the directory that "go tool
+// objdump" output is cached in, or "auto" for one under the user
+// cache directory.
+var cacheDir string
+
+// cacheFlag adds the -cache flag to fs.
+func cacheFlag() {
+	fs.StringVar(&cacheDir, "cache", "", "cache go tool objdump's output in `DIR`, or under the user cache directory if auto, keyed by the binary's contents and the symbols")
+}
+
+// objdumpCacheDir returns the directory that objdump output is
+// cached in.
+func objdumpCacheDir() (string, error) {
+	if cacheDir != "auto" {
+		return cacheDir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("-cache auto: %w", err)
+	}
+	return filepath.Join(dir, "go-llvm-mca", "objdump"), nil
+}
+
+// goOutput runs go with args, which disassemble bin, and returns
+// its output. It writes go's standard error to stderr, if
+// non-nil.
+//
+// If -cache is set, the output is reused as long as bin's
+// contents, the go command and args are unchanged. Changing bin's
+// contents changes the key, so a rebuilt binary is disassembled
+// again, but its path is not part of the key, so a copy of it,
+// like a slice of a universal binary, is not.
+func goOutput(bin string, args []string, stderr io.Writer) ([]byte, error) {
+	run := func() ([]byte, error) {
+		cmd := command(goTool, args...)
+		if stderr != nil {
+			cmd.Stderr = stderr
+		}
+		return cmd.Output()
+	}
+	if cacheDir == "" {
+		return run()
+	}
+	dir, err := objdumpCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	sum, err := hashFile(bin)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", sum, goTool)
+	for _, s := range args {
+		if s == bin {
+			s = ""
+		}
+		fmt.Fprintf(h, "%s\x00", s)
+	}
+	path := filepath.Join(dir, hex.EncodeToString(h.Sum(nil)))
+
+	if out, err := ioutil.ReadFile(path); err == nil {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "%s: using cached %s\n", os.Args[0], shellJoin(append([]string{goTool}, args...)...))
+		}
+		return out, nil
+	}
+	out, err := run()
+	if err != nil {
+		return nil, err
+	}
+	// Failing to cache the output only makes the next run
+	// slower.
+	f, err := createOut(path)
+	if err != nil {
+		warnf("caching objdump output: %v", err)
+		return out, nil
+	}
+	if _, err := f.Write(out); err != nil {
+		f.abort()
+		warnf("caching objdump output: %v", err)
+		return out, nil
+	}
+	if err := f.Close(); err != nil {
+		warnf("caching objdump output: %v", err)
+	}
+	return out, nil
+}
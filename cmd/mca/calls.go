@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+// textBlock is one TEXT symbol in "go tool objdump" output.
+type textBlock struct {
+	// name is the symbol's name.
+	name string
+	// text is the TEXT line and the symbol's instructions.
+	text []byte
+}
+
+// splitText splits "go tool objdump" output into TEXT blocks.
+func splitText(out []byte) []textBlock {
+	var blocks []textBlock
+	for len(out) > 0 {
+		end := bytes.Index(out[1:], []byte("\nTEXT "))
+		if end < 0 {
+			end = len(out)
+		} else {
+			end += 2 // skip the first byte and the newline
+		}
+		// objdump separates symbols with blank lines.
+		b := bytes.TrimRight(out[:end], "\n")
+		b = append(b[:len(b):len(b)], '\n')
+		out = out[end:]
+		if !bytes.HasPrefix(b, []byte("TEXT ")) {
+			continue
+		}
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			i = len(b)
+		}
+		name := mca.TextName(string(b[len("TEXT "):i]))
+		blocks = append(blocks, textBlock{name: name, text: b})
+	}
+	return blocks
+}
+
+// objdumpFlags are extra flags for "go tool objdump", like -S.
+var objdumpFlags []string
+
+// objdumpArgs returns the arguments to go that disassemble the
+// symbols in bin that match symReg.
+func objdumpArgs(bin, symReg string) []string {
+	args := []string{"tool", "objdump", "-gnu"}
+	args = append(args, objdumpFlags...)
+	return append(args, "-s", symReg, bin)
+}
+
+// checkObjdumpFlags checks that flags, extra flags for "go tool
+// objdump", do not conflict with those that mca passes.
+func checkObjdumpFlags(flags []string) error {
+	for _, f := range flags {
+		name := strings.TrimLeft(f, "-")
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name = name[:i]
+		}
+		switch {
+		case !strings.HasPrefix(f, "-"):
+			return useErrf("-objdump-flags: %q is not a flag", f)
+		case name == "s":
+			return useErr("-objdump-flags: use mca's -s to select symbols")
+		case name == "gnu":
+			return useErr("-objdump-flags: -gnu is always set, since fix needs the GNU assembly")
+		}
+	}
+	return nil
+}
+
+// objdump returns the "go tool objdump" output for the symbols
+// in bin that match symReg.
+func objdump(bin, symReg string) ([]byte, error) {
+	return goOutput(bin, objdumpArgs(bin, symReg), os.Stderr)
+}
+
+// runMCA fixes the objdump output in text and returns llvm-mca's
+// report for it.
+func runMCA(text []byte, cfg mca.Config, args []string) ([]byte, error) {
+	var in bytes.Buffer
+	if err := cfg.Fix(&in, bytes.NewReader(text)); err != nil {
+		return nil, err
+	}
+	cmd := command(mcaTool, args...)
+	cmd.Stdin = &in
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	return out, mcaErr(err)
+}
+
+// runMCABatch fixes each of texts and returns llvm-mca's report
+// for each.
+//
+// Starting llvm-mca dominates the cost of analyzing small
+// functions, so the texts are analyzed by a single llvm-mca
+// process, each in its own code region. The region headers are
+// removed so that each report looks like one from runMCA.
+func runMCABatch(texts [][]byte, cfg mca.Config, args []string) ([][]byte, error) {
+	cfg.Regions, cfg.AutoRegions = false, false
+	var in bytes.Buffer
+	for i, t := range texts {
+		fmt.Fprintf(&in, "# LLVM-MCA-BEGIN batch%d\n", i)
+		if err := cfg.Fix(&in, bytes.NewReader(t)); err != nil {
+			return nil, err
+		}
+		fmt.Fprint(&in, "# LLVM-MCA-END\n")
+	}
+	cmd := command(mcaTool, args...)
+	cmd.Stdin = &in
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, mcaErr(err)
+	}
+	reports := make([][]byte, len(texts))
+	for _, r := range splitRegions(out) {
+		var i int
+		if _, err := fmt.Sscanf(r.name, "batch%d", &i); err != nil || i < 0 || i >= len(texts) {
+			continue
+		}
+		// Skip the header and the blank line after it, and
+		// the blank line that separates it from the next.
+		text := r.text
+		if j := bytes.IndexByte(text, '\n'); j >= 0 {
+			text = bytes.TrimLeft(text[j+1:], "\n")
+		}
+		reports[i] = append(bytes.TrimRight(text, "\n"), '\n')
+	}
+	for i, r := range reports {
+		if r == nil {
+			return nil, fmt.Errorf("llvm-mca did not report code region %d of %d", i+1, len(texts))
+		}
+	}
+	return reports, nil
+}
+
+// callees returns the symbols called by b, in order of first
+// call.
+func callees(b textBlock, tab *mca.Symtab, a *mca.Arch) []string {
+	var names []string
+	seen := make(map[string]bool)
+	s := bufio.NewScanner(bytes.NewReader(b.text))
+	for s.Scan() {
+		l, err := mca.ParseLine(s.Text())
+		if err != nil {
+			continue
+		}
+		a.Classify(&l)
+		if !l.IsCall() || !l.HasTarget {
+			continue
+		}
+		callee, ok := tab.Lookup(l.Target)
+		if !ok || seen[callee.Name] {
+			continue
+		}
+		seen[callee.Name] = true
+		names = append(names, callee.Name)
+	}
+	return names
+}
+
+// followCalls analyzes the symbols in bin that match symReg and,
+// up to depth calls deep, the functions they call.
+//
+// Each function is fixed with cfg and analyzed once. The report
+// is nested by call path in depth-first order. If threshold is
+// positive, each report's contended instructions are marked as
+// by markPressure. If keepalive is set, every function is
+// analyzed by a single llvm-mca process.
+func followCalls(w io.Writer, bin, symReg string, depth int, cfg mca.Config, threshold float64, keepalive bool, mcaArgs []string) error {
+	tab, err := mca.ReadSymtab(bin)
+	if err != nil {
+		return err
+	}
+	out, err := objdump(bin, symReg)
+	if err != nil {
+		return err
+	}
+
+	var (
+		roots   []string
+		blocks  = make(map[string]textBlock)
+		calls   = make(map[string][]string)
+		pending = make(map[string]bool)
+	)
+	for _, b := range splitText(out) {
+		if _, ok := blocks[b.name]; !ok {
+			roots = append(roots, b.name)
+			blocks[b.name] = b
+		}
+	}
+
+	// Disassemble breadth first so that each level takes one
+	// objdump invocation.
+	frontier := roots
+	for d := 0; d < depth && len(frontier) > 0; d++ {
+		var next []string
+		for _, name := range frontier {
+			calls[name] = callees(blocks[name], tab, cfg.Arch)
+			for _, c := range calls[name] {
+				if _, ok := blocks[c]; !ok && !pending[c] {
+					pending[c] = true
+					next = append(next, c)
+				}
+			}
+		}
+		if len(next) == 0 {
+			break
+		}
+		out, err := objdump(bin, symbolsRegexp(next))
+		if err != nil {
+			return err
+		}
+		for _, b := range splitText(out) {
+			blocks[b.name] = b
+		}
+		frontier = next
+	}
+
+	var reports map[string][]byte
+	if keepalive {
+		var (
+			names []string
+			texts [][]byte
+		)
+		for name, b := range blocks {
+			names = append(names, name)
+			texts = append(texts, b.text)
+		}
+		out, err := runMCABatch(texts, cfg, mcaArgs)
+		if err != nil {
+			return err
+		}
+		reports = make(map[string][]byte)
+		for i, name := range names {
+			reports[name] = out[i]
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	seen := make(map[string]bool)
+	var walk func(name string, path []string) error
+	walk = func(name string, path []string) error {
+		path = append(path, name)
+		title := strings.Join(path, " -> ")
+		b, ok := blocks[name]
+		switch {
+		case seen[name]:
+			fmt.Fprintf(bw, "==== %s (see above) ====\n\n", title)
+			return nil
+		case !ok:
+			fmt.Fprintf(bw, "==== %s (not disassembled) ====\n\n", title)
+			return nil
+		}
+		seen[name] = true
+		report, ok := reports[name]
+		if !ok {
+			var err error
+			report, err = runMCA(b.text, cfg, mcaArgs)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		if threshold > 0 {
+			report = markPressure(report, threshold)
+		}
+		fmt.Fprintf(bw, "==== %s ====\n%s\n", title, bytes.TrimRight(report, "\n"))
+		fmt.Fprintln(bw)
+		for _, c := range calls[name] {
+			if err := walk(c, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, name := range roots {
+		if err := walk(name, nil); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	exec "golang.org/x/sys/execabs"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+// mcaTexts returns the TEXT blocks of testdata/dump_amd64.txt, and
+// skips the test if llvm-mca cannot be run.
+func mcaTexts(tb testing.TB) [][]byte {
+	if _, err := exec.LookPath(mcaTool); err != nil {
+		tb.Skipf("%s not found", mcaTool)
+	}
+	out, err := os.ReadFile("../../testdata/dump_amd64.txt")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var texts [][]byte
+	for _, b := range splitText(out) {
+		texts = append(texts, b.text)
+	}
+	if len(texts) < 2 {
+		tb.Fatalf("got %d symbols, want several", len(texts))
+	}
+	return texts
+}
+
+var keepaliveArgs = []string{"-mtriple=x86_64-unknown-linux-gnu", "-mcpu=skylake"}
+
+func TestRunMCABatch(t *testing.T) {
+	texts := mcaTexts(t)
+	a, _ := mca.LookupArch("amd64")
+	cfg := mca.Config{Arch: a}
+	reports, err := runMCABatch(texts, cfg, keepaliveArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, text := range texts {
+		want, err := runMCA(text, cfg, keepaliveArgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(reports[i], want) {
+			t.Errorf("symbol %d: the report differs with -keepalive:\n%s\nwant:\n%s", i, reports[i], want)
+		}
+	}
+}
+
+// TestDialectLLVM checks that llvm-mca accepts every instruction
+// of the amd64, 386 and arm64 dumps in the LLVM dialect that run,
+// batch and lint default to.
+func TestDialectLLVM(t *testing.T) {
+	if _, err := exec.LookPath(mcaTool); err != nil {
+		t.Skipf("%s not found", mcaTool)
+	}
+	for _, arch := range []string{"amd64", "386", "arm64"} {
+		dump, err := os.ReadFile("../../testdata/dump_" + arch + ".txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, _ := mca.LookupArch(arch)
+		var in bytes.Buffer
+		cfg := mca.Config{Arch: a, Dialect: mca.DialectLLVM}
+		if err := cfg.Fix(&in, bytes.NewReader(dump)); err != nil {
+			t.Fatal(err)
+		}
+		tg := target{goos: "linux", goarch: arch}
+		if err := tg.resolve(nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		cmd := command(mcaTool, "-mtriple="+tg.triple, "-mcpu="+tg.cpu)
+		cmd.Stdin = &in
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			t.Errorf("%s: %v\n%s", arch, err, stderr.Bytes())
+			continue
+		}
+		// llvm-mca reports the instructions it cannot parse,
+		// but still exits zero.
+		if bytes.Contains(stderr.Bytes(), []byte("error:")) {
+			t.Errorf("%s: llvm-mca rejected the assembly:\n%s", arch, stderr.Bytes())
+		}
+	}
+}
+
+func BenchmarkRunMCA(b *testing.B) {
+	texts := mcaTexts(b)
+	a, _ := mca.LookupArch("amd64")
+	cfg := mca.Config{Arch: a}
+	b.Run("keepalive", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := runMCABatch(texts, cfg, keepaliveArgs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("each", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, text := range texts {
+				if _, err := runMCA(text, cfg, keepaliveArgs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
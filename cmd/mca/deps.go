@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+// insnInfo is one instruction in the "Instruction Info" view in
+// a region's report.
+type insnInfo struct {
+	insn    string
+	latency int
+}
+
+// parseInsnInfo parses the instruction info view in a region's
+// report.
+func parseInsnInfo(text []byte) []insnInfo {
+	var (
+		infos []insnInfo
+		col   = -1
+		in    bool
+	)
+	s := bufio.NewScanner(bytes.NewReader(text))
+	for s.Scan() {
+		l := s.Text()
+		switch {
+		case l == "Instruction Info:":
+			in, col = true, -1
+		case !in:
+		case col < 0 && strings.HasPrefix(l, "[1]"):
+			col = strings.Index(l, "Instructions:")
+		case col < 0:
+			// The legend.
+		case strings.TrimSpace(l) == "" || len(l) <= col:
+			return infos
+		default:
+			f := strings.Fields(l[:col])
+			info := insnInfo{insn: strings.TrimSpace(l[col:])}
+			if len(f) >= 2 {
+				info.latency, _ = strconv.Atoi(f[1])
+			}
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+var criticalLine = regexp.MustCompile(`^ [+|] *-*[<>] *(\d+)\.`)
+
+// parseCritical returns the indices of the instructions in the
+// critical sequence that llvm-mca's -bottleneck-analysis view
+// reports for a region, if any.
+func parseCritical(text []byte) []int {
+	var (
+		seq []int
+		in  bool
+	)
+	s := bufio.NewScanner(bytes.NewReader(text))
+	for s.Scan() {
+		l := s.Text()
+		if strings.HasPrefix(l, "Critical sequence based on the simulation:") {
+			in = true
+			continue
+		}
+		if !in {
+			continue
+		}
+		if strings.HasPrefix(l, "Instruction Info:") {
+			break
+		}
+		if m := criticalLine.FindStringSubmatch(l); m != nil && strings.Contains(l, "---->") {
+			i, _ := strconv.Atoi(m[1])
+			seq = append(seq, i)
+		}
+	}
+	return seq
+}
+
+// regUse is the registers that an instruction reads and writes.
+type regUse struct {
+	reads  []string
+	writes []string
+}
+
+// insnRegs returns the registers that the instruction s, as
+// printed by llvm-mca, reads and writes. Registers are named by
+// their widest alias so that writing %eax is seen by a read of
+// %rax.
+//
+// This is an approximation from the operands' syntax: flags are
+// ignored, as are registers used implicitly.
+func insnRegs(s string) regUse {
+	m, ops := mca.SplitInsn(s)
+	operands := mca.SplitOperands(ops)
+	if strings.Contains(ops, "%") {
+		return x86Regs(m, operands)
+	}
+	return arm64Regs(m, operands)
+}
+
+func x86Regs(m string, ops []string) regUse {
+	var u regUse
+	if len(ops) == 0 {
+		return u
+	}
+	for _, op := range ops[:len(ops)-1] {
+		u.reads = append(u.reads, x86OperandRegs(op)...)
+	}
+	dst := ops[len(ops)-1]
+	if strings.Contains(dst, "(") {
+		// A store reads the address registers.
+		u.reads = append(u.reads, x86OperandRegs(dst)...)
+		return u
+	}
+	regs := x86OperandRegs(dst)
+	switch {
+	case strings.HasPrefix(m, "cmp"), strings.HasPrefix(m, "test"),
+		strings.HasPrefix(m, "bt"), strings.HasPrefix(m, "ucomis"),
+		strings.HasPrefix(m, "comis"):
+		// Only writes flags.
+		u.reads = append(u.reads, regs...)
+	case strings.HasPrefix(m, "mov"), strings.HasPrefix(m, "lea"),
+		strings.HasPrefix(m, "vmov"), strings.HasPrefix(m, "set"),
+		strings.HasPrefix(m, "pop"):
+		u.writes = regs
+	case (strings.HasPrefix(m, "xor") || strings.HasPrefix(m, "sub") ||
+		strings.HasPrefix(m, "pxor")) && len(ops) == 2 && ops[0] == ops[1]:
+		// Zero idiom.
+		u.reads = nil
+		u.writes = regs
+	case len(ops) == 3 && strings.HasPrefix(m, "v"):
+		// Non-destructive AVX form.
+		u.writes = regs
+	default:
+		u.reads = append(u.reads, regs...)
+		u.writes = regs
+	}
+	return u
+}
+
+// x86OperandRegs returns the registers named in an AT&T operand.
+func x86OperandRegs(op string) []string {
+	var regs []string
+	for {
+		i := strings.IndexByte(op, '%')
+		if i < 0 {
+			return regs
+		}
+		op = op[i+1:]
+		j := strings.IndexAny(op, ",() :*")
+		if j < 0 {
+			j = len(op)
+		}
+		if r := x86RegFamily(op[:j]); r != "" {
+			regs = append(regs, r)
+		}
+		op = op[j:]
+	}
+}
+
+// x86RegFamily returns the widest alias of the x86 register r,
+// without its %, or "" for segment and instruction pointer
+// registers.
+func x86RegFamily(r string) string {
+	switch r {
+	case "al", "ah", "ax", "eax", "rax":
+		return "%rax"
+	case "bl", "bh", "bx", "ebx", "rbx":
+		return "%rbx"
+	case "cl", "ch", "cx", "ecx", "rcx":
+		return "%rcx"
+	case "dl", "dh", "dx", "edx", "rdx":
+		return "%rdx"
+	case "sil", "si", "esi", "rsi":
+		return "%rsi"
+	case "dil", "di", "edi", "rdi":
+		return "%rdi"
+	case "bpl", "bp", "ebp", "rbp":
+		return "%rbp"
+	case "spl", "sp", "esp", "rsp":
+		return "%rsp"
+	case "rip", "eip", "cs", "ds", "es", "fs", "gs", "ss":
+		return ""
+	}
+	if strings.HasPrefix(r, "r") {
+		// r8 through r15, with a b, w, or d suffix.
+		return "%" + strings.TrimRight(r, "bwd")
+	}
+	for _, p := range []string{"xmm", "ymm", "zmm"} {
+		if strings.HasPrefix(r, p) {
+			return "%zmm" + r[len(p):]
+		}
+	}
+	return "%" + r
+}
+
+func arm64Regs(m string, ops []string) regUse {
+	var u regUse
+	var regs [][]string
+	for _, op := range ops {
+		regs = append(regs, arm64OperandRegs(op))
+	}
+	switch {
+	case len(ops) == 0:
+		return u
+	case strings.HasPrefix(m, "st"),
+		m == "cmp", m == "cmn", m == "tst", m == "fcmp", m == "fcmpe",
+		m == "cbz", m == "cbnz", m == "tbz", m == "tbnz",
+		m == "br", m == "blr", m == "ret":
+		for _, r := range regs {
+			u.reads = append(u.reads, r...)
+		}
+	case m == "ldp" || m == "ldnp":
+		if len(regs) < 3 {
+			return u
+		}
+		u.writes = append(append(u.writes, regs[0]...), regs[1]...)
+		for _, r := range regs[2:] {
+			u.reads = append(u.reads, r...)
+		}
+	default:
+		u.writes = regs[0]
+		for _, r := range regs[1:] {
+			u.reads = append(u.reads, r...)
+		}
+	}
+	return u
+}
+
+// arm64OperandRegs returns the registers named in an arm64
+// operand, like "x0" or "[sp, #16]".
+func arm64OperandRegs(op string) []string {
+	var regs []string
+	for _, f := range strings.FieldsFunc(op, func(c rune) bool {
+		return strings.ContainsRune("[]{}!, ", c)
+	}) {
+		if mca.IsARM64Reg(f) && f != "sp" && f != "wsp" {
+			regs = append(regs, arm64RegFamily(f))
+		}
+	}
+	return regs
+}
+
+// arm64RegFamily returns the widest alias of the arm64 register
+// r: w0 is x0 and s0, d0, and v0.16b are q0.
+func arm64RegFamily(r string) string {
+	if i := strings.IndexByte(r, '.'); i >= 0 {
+		r = r[:i]
+	}
+	switch r[0] {
+	case 'w', 'x':
+		return "x" + r[1:]
+	default:
+		return "q" + r[1:]
+	}
+}
+
+// dep is a dependency of an instruction on the most recent
+// earlier instruction that wrote one of the registers it reads.
+type dep struct {
+	on  int
+	reg string
+}
+
+// findDeps returns the register dependencies of each of infos
+// within one iteration of the region.
+func findDeps(infos []insnInfo) [][]dep {
+	deps := make([][]dep, len(infos))
+	last := make(map[string]int)
+	for i, info := range infos {
+		u := insnRegs(info.insn)
+		seen := make(map[string]bool)
+		for _, r := range u.reads {
+			if j, ok := last[r]; ok && !seen[r] {
+				seen[r] = true
+				deps[i] = append(deps[i], dep{on: j, reg: r})
+			}
+		}
+		for _, r := range u.writes {
+			last[r] = i
+		}
+	}
+	return deps
+}
+
+// longestChain returns the dependency chain with the highest
+// total latency, in program order, and its latency.
+func longestChain(infos []insnInfo, deps [][]dep) ([]int, int) {
+	total := make([]int, len(infos))
+	prev := make([]int, len(infos))
+	best := -1
+	for i, info := range infos {
+		total[i], prev[i] = info.latency, -1
+		for _, d := range deps[i] {
+			if t := total[d.on] + info.latency; t > total[i] {
+				total[i], prev[i] = t, d.on
+			}
+		}
+		if best < 0 || total[i] > total[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil, 0
+	}
+	var chain []int
+	for i := best; i >= 0; i = prev[i] {
+		chain = append([]int{i}, chain...)
+	}
+	return chain, total[best]
+}
+
+// writeDeps writes each region's report followed by the register
+// dependencies of its instructions, its longest dependency chain,
+// and llvm-mca's critical sequence, if any.
+func writeDeps(w io.Writer, regions []mcaRegion) error {
+	bw := bufio.NewWriter(w)
+	for _, r := range regions {
+		fmt.Fprintf(bw, "%s\n", bytes.TrimRight(r.text, "\n"))
+		infos := parseInsnInfo(r.text)
+		if len(infos) == 0 {
+			continue
+		}
+		deps := findDeps(infos)
+		chain, lat := longestChain(infos, deps)
+		onChain := make(map[int]bool)
+		for _, i := range chain {
+			onChain[i] = true
+		}
+
+		fmt.Fprint(bw, "\nRegister dependencies:\n")
+		var tb bytes.Buffer
+		tw := tabwriter.NewWriter(&tb, 0, 8, 2, ' ', 0)
+		fmt.Fprint(tw, "\t[#]\tLatency\tInstruction\tDepends on\n")
+		for i, info := range infos {
+			mark := ""
+			if onChain[i] {
+				mark = "*"
+			}
+			var on []string
+			for _, d := range deps[i] {
+				on = append(on, fmt.Sprintf("[%d] %s", d.on, d.reg))
+			}
+			fmt.Fprintf(tw, "%s\t[%d]\t%d\t%s\t%s\n", mark, i, info.latency,
+				strings.Join(strings.Fields(info.insn), " "), strings.Join(on, ", "))
+		}
+		tw.Flush()
+		for _, l := range strings.SplitAfter(tb.String(), "\n") {
+			fmt.Fprint(bw, strings.TrimRight(strings.TrimSuffix(l, "\n"), " "))
+			if strings.HasSuffix(l, "\n") {
+				fmt.Fprintln(bw)
+			}
+		}
+		fmt.Fprintf(bw, "\nLongest dependency chain (*): %s, %d cycles of latency per iteration\n",
+			joinIndices(chain), lat)
+		if seq := parseCritical(r.text); len(seq) > 0 {
+			fmt.Fprintf(bw, "Critical sequence from llvm-mca: %s\n", joinIndices(seq))
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+func joinIndices(idx []int) string {
+	s := make([]string, len(idx))
+	for i, x := range idx {
+		s[i] = fmt.Sprintf("[%d]", x)
+	}
+	return strings.Join(s, " -> ")
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// depsReport returns testdata/deps_amd64.txt, llvm-mca's report
+// for main.sum in ../../testdata/dump_amd64.txt, generated with
+//
+//	mca fix -isa amd64 -dialect llvm -s '^main\.sum$' -regions=false |
+//		llvm-mca -mtriple=x86_64-unknown-linux-gnu -mcpu=skylake \
+//		-bottleneck-analysis -iterations=100
+func depsReport(t *testing.T) []byte {
+	t.Helper()
+	text, err := os.ReadFile("testdata/deps_amd64.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text
+}
+
+func TestParseInsnInfo(t *testing.T) {
+	infos := parseInsnInfo(depsReport(t))
+	want := []insnInfo{
+		{"movq\t%rax, 8(%rsp)", 1},
+		{"xorl\t%ecx, %ecx", 0},
+		{"xorl\t%edx, %edx", 0},
+		{"jmp\t4731033", 1},
+		{"movq\t(%rax,%rcx,8), %rsi", 5},
+		{"imulq\t%rsi, %rsi", 3},
+		{"addq\t%rsi, %rdx", 1},
+		{"incq\t%rcx", 1},
+		{"cmpq\t%rcx, %rbx", 1},
+		{"jg\t4731019", 1},
+		{"movq\t%rdx, %rax", 1},
+		{"retq", 7},
+	}
+	if !reflect.DeepEqual(infos, want) {
+		t.Errorf("got %q, want %q", infos, want)
+	}
+	if infos := parseInsnInfo([]byte("Iterations:        100\n")); len(infos) != 0 {
+		t.Errorf("without instruction info: got %q", infos)
+	}
+}
+
+func TestParseCritical(t *testing.T) {
+	// The sequence wraps around to the first instruction of
+	// the next iteration.
+	if got, want := parseCritical(depsReport(t)), []int{4, 5, 6, 10, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %d, want %d", got, want)
+	}
+}
+
+func TestInsnRegs(t *testing.T) {
+	for _, tc := range []struct {
+		insn          string
+		reads, writes string
+	}{
+		{"movq\t(%rax,%rcx,8), %rsi", "%rax %rcx", "%rsi"},
+		{"movq\t%rax, 8(%rsp)", "%rax %rsp", ""},
+		{"addl\t%esi, %edx", "%rsi %rdx", "%rdx"},
+		{"xorl\t%ecx, %ecx", "", "%rcx"},
+		{"cmpq\t%rcx, %rbx", "%rcx %rbx", ""},
+		{"vaddps\t%ymm1, %ymm2, %ymm3", "%zmm1 %zmm2", "%zmm3"},
+		{"incq\t%r8d", "%r8", "%r8"},
+		{"retq", "", ""},
+
+		{"add x0, x1, w2, uxtw", "x1 x2", "x0"},
+		{"ldr d0, [x1, #8]", "x1", "q0"},
+		{"ldp x0, x1, [sp, #16]", "", "x0 x1"},
+		{"str x0, [x1]", "x0 x1", ""},
+		{"cmp x0, #0x1", "x0", ""},
+	} {
+		u := insnRegs(tc.insn)
+		if got := strings.Join(u.reads, " "); got != tc.reads {
+			t.Errorf("%q: reads %q, want %q", tc.insn, got, tc.reads)
+		}
+		if got := strings.Join(u.writes, " "); got != tc.writes {
+			t.Errorf("%q: writes %q, want %q", tc.insn, got, tc.writes)
+		}
+	}
+}
+
+func TestLongestChain(t *testing.T) {
+	infos := parseInsnInfo(depsReport(t))
+	deps := findDeps(infos)
+	// The load of x[i], its square and the sum, which is
+	// returned.
+	chain, lat := longestChain(infos, deps)
+	if want := []int{4, 5, 6, 10}; !reflect.DeepEqual(chain, want) || lat != 10 {
+		t.Errorf("got %d with latency %d, want %d with latency 10", chain, lat, want)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		infos []insnInfo
+		chain []int
+		lat   int
+	}{
+		{"empty", nil, nil, 0},
+		{
+			// A single slow instruction beats a chain of
+			// fast ones.
+			"slow",
+			[]insnInfo{
+				{"addq %rax, %rbx", 1},
+				{"addq %rbx, %rcx", 1},
+				{"divq %rsi", 40},
+			},
+			[]int{2},
+			40,
+		},
+		{
+			// Each instruction depends on the last write of
+			// the registers it reads, not on the imulq.
+			"last write",
+			[]insnInfo{
+				{"imulq %rax, %rax", 2},
+				{"movq %rdx, %rax", 1},
+				{"addq %rax, %rcx", 1},
+				{"addq %rcx, %rbx", 1},
+			},
+			[]int{1, 2, 3},
+			3,
+		},
+	} {
+		chain, lat := longestChain(tc.infos, findDeps(tc.infos))
+		if !reflect.DeepEqual(chain, tc.chain) || lat != tc.lat {
+			t.Errorf("%s: got %d with latency %d, want %d with latency %d", tc.name, chain, lat, tc.chain, tc.lat)
+		}
+	}
+}
+
+func TestWriteDeps(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeDeps(&buf, []mcaRegion{{text: depsReport(t)}}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"*  [4]   5        movq (%rax,%rcx,8), %rsi  [1] %rcx\n",
+		"   [8]   1        cmpq %rcx, %rbx           [7] %rcx\n",
+		"Longest dependency chain (*): [4] -> [5] -> [6] -> [10], 10 cycles of latency per iteration\n",
+		"Critical sequence from llvm-mca: [4] -> [5] -> [6] -> [10] -> [0]\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got\n%s\nwant %q", out, want)
+		}
+	}
+}
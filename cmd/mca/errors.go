@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+// errorsFormat is how main reports errors, set by -errors: "text"
+// or "json".
+var errorsFormat = "text"
+
+// errorsFlag adds the -errors flag to fs.
+func errorsFlag() {
+	fs.StringVar(&errorsFormat, "errors", "text", "how to report errors on stderr: text, or json for one JSON object, for tools that run mca")
+}
+
+// checkErrorsFlag checks the value of -errors.
+func checkErrorsFlag() error {
+	if errorsFormat != "text" && errorsFormat != "json" {
+		s := errorsFormat
+		errorsFormat = "text"
+		return useErrf("unknown -errors %q", s)
+	}
+	return nil
+}
+
+// jsonError is an error as reported by -errors=json.
+type jsonError struct {
+	// Kind is "parse" for input that cannot be parsed, "usage"
+	// for bad flags or arguments, "llvm-mca" if llvm-mca failed,
+	// or "error".
+	Kind string `json:"kind"`
+	// Line and Input are the number and text of the input line
+	// that could not be parsed.
+	Line  int    `json:"line,omitempty"`
+	Input string `json:"input,omitempty"`
+	Msg   string `json:"msg"`
+	// Status is llvm-mca's exit status.
+	Status int `json:"status,omitempty"`
+}
+
+// writeJSONError writes err to w as a jsonError.
+func writeJSONError(w io.Writer, err error) error {
+	j := jsonError{Kind: "error", Msg: err.Error()}
+	var (
+		se *mca.SyntaxError
+		ue *usageError
+		xe *exitError
+	)
+	switch {
+	case errors.As(err, &se):
+		j.Kind, j.Line, j.Input, j.Msg = "parse", se.N, se.Input, se.Msg
+	case errors.As(err, &ue):
+		j.Kind = "usage"
+	case errors.As(err, &xe):
+		j.Kind, j.Status = "llvm-mca", xe.err.ExitCode()
+	}
+	return json.NewEncoder(w).Encode(j)
+}
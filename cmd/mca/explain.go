@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+// explainRun describes the pipeline that runCmd is about to
+// execute.
+func explainRun(w io.Writer, bin, symReg, disassembler, trace string, follow int, perSymbol, byBottleneck bool, cfg mca.Config, mcaArgs []string) {
+	var steps []string
+	if trace != "" {
+		steps = append(steps, fmt.Sprintf("Find the hottest functions in the execution trace %s, which matched %s.", trace, symReg))
+	}
+	if disassembler == disasmLLVM {
+		steps = append(steps, fmt.Sprintf("Disassemble %s and keep the functions that match %s, without the padding after them:\n%s",
+			bin, symReg, shellJoin(append([]string{disasmLLVM}, llvmObjdumpArgs(bin)...)...)))
+	} else {
+		cached := ""
+		if cacheDir != "" {
+			cached = ", unless the output for the unchanged binary is cached in " + cacheDir
+		}
+		steps = append(steps, fmt.Sprintf("Disassemble the symbols in %s that match %s%s:\n%s",
+			bin, symReg, cached, shellJoin(append([]string{goTool}, objdumpArgs(bin, symReg)...)...)))
+	}
+	if follow > 0 {
+		steps = append(steps, fmt.Sprintf("Disassemble the functions they call, up to %d calls deep, using the symbol table in %s.", follow, bin))
+	}
+	steps = append(steps, "Convert the disassembly into llvm-mca input "+explainFixSummary(cfg)+".")
+	if byBottleneck {
+		mcaArgs = append(mcaArgs[:len(mcaArgs):len(mcaArgs)], "-bottleneck-analysis")
+	}
+	cmdline := shellJoin(append([]string{mcaTool}, mcaArgs...)...)
+	switch {
+	case follow > 0:
+		steps = append(steps, fmt.Sprintf("Analyze each function separately and print the reports nested by call path:\n%s", cmdline))
+	case perSymbol:
+		steps = append(steps, fmt.Sprintf("Analyze each symbol with its own llvm-mca process and print the reports under the symbols' names:\n%s", cmdline))
+	case byBottleneck:
+		steps = append(steps, fmt.Sprintf("Analyze each symbol as its own code region and print the reports grouped by primary bottleneck:\n%s", cmdline))
+	default:
+		steps = append(steps, fmt.Sprintf("Analyze the result and print llvm-mca's report:\n%s", cmdline))
+	}
+	writeSteps(w, steps)
+}
+
+// writeDryRun writes the commands that runCmd would run for -n,
+// one per line: go build, if buildArgs is non-nil, the
+// disassembler and llvm-mca. Between the last two, mca converts
+// the disassembly as "mca fix" does.
+func writeDryRun(w io.Writer, buildArgs []string, bin, symReg, disassembler string, follow int, perSymbol bool, mcaArgs []string) error {
+	var cmds [][]string
+	// goCmd is go, after the variables that it is run with.
+	goCmd := append(goEnv[:len(goEnv):len(goEnv)], goTool)
+	if buildArgs != nil {
+		cmds = append(cmds, append(goCmd[:len(goCmd):len(goCmd)], buildArgs...))
+	}
+	if isWasm(bin) {
+		// llvm-mca does not support wasm.
+		cmds = append(cmds, []string{"wasm-objdump", "-d", bin})
+	} else {
+		if disassembler == disasmLLVM {
+			cmds = append(cmds, append([]string{disasmLLVM}, llvmObjdumpArgs(bin)...))
+		} else {
+			cmds = append(cmds, append(goCmd[:len(goCmd):len(goCmd)], objdumpArgs(bin, symReg)...))
+		}
+		cmds = append(cmds, append([]string{mcaTool}, mcaArgs...))
+	}
+	for _, c := range cmds {
+		fmt.Fprintln(w, shellJoin(c...))
+	}
+	if buildArgs == nil && !isWasm(bin) {
+		// Running it would not be a dry run.
+		fmt.Fprintf(w, "# with the target from the file header; mca would also read the build settings with: %s\n",
+			shellJoin(append(goCmd[:len(goCmd):len(goCmd)], "version", "-m", bin)...))
+	}
+	switch {
+	case follow > 0:
+		fmt.Fprintf(w, "# and the same for the functions that they call, up to %d calls deep\n", follow)
+	case perSymbol:
+		fmt.Fprintln(w, "# with llvm-mca run once per symbol")
+	}
+	return nil
+}
+
+// explainFix describes what fixCmd is about to do.
+func explainFix(w io.Writer, in, out, splitDir string, cfg mca.Config) {
+	if out == "" {
+		out = "standard output"
+	}
+	if in == "" || in == "-" {
+		in = "standard input"
+	}
+	steps := []string{
+		fmt.Sprintf("Read \"go tool objdump -gnu\" output from %s.", in),
+		"Rewrite it as assembly " + explainFixSummary(cfg) + ".",
+	}
+	if cfg.Dedupe {
+		steps = append(steps, "Replace each symbol's instructions with its distinct instructions and their counts.")
+	}
+	if cfg.Symtab != nil {
+		steps = append(steps, "Annotate each direct branch and call with its target's symbol, or with its offset for targets in the same function.")
+	}
+	if cfg.Encoding != nil {
+		steps = append(steps, fmt.Sprintf("Only keep instructions whose leading bytes ANDed with %x equal %x.", cfg.Encoding.Mask, cfg.Encoding.Value))
+	}
+	if cfg.Addr != nil {
+		steps = append(steps, fmt.Sprintf("Only keep instructions at addresses from %#x up to %#x.", cfg.Addr.Start, cfg.Addr.End))
+	}
+	var reports []string
+	if cfg.SumByFile {
+		reports = append(reports, "instruction counts per source file")
+	}
+	if cfg.Summary {
+		reports = append(reports, "instruction counts and sizes per symbol")
+	}
+	if cfg.Moves {
+		reports = append(reports, "redundant move candidates")
+	}
+	if len(reports) > 0 {
+		steps = append(steps, "Append reports of "+strings.Join(reports, " and ")+".")
+	}
+	if cfg.AlignComments {
+		steps = append(steps, "Align each symbol's trailing comments to one column.")
+	}
+	if cfg.WrapWidth > 0 {
+		steps = append(steps, fmt.Sprintf("Wrap comments in lines longer than %d columns.", cfg.WrapWidth))
+	}
+	if splitDir != "" {
+		steps = append(steps, fmt.Sprintf("Write each symbol to %s, where LABEL is its label, and anything else to %s.", filepath.Join(splitDir, "LABEL.mca"), out))
+	} else {
+		steps = append(steps, fmt.Sprintf("Write the result to %s.", out))
+	}
+	writeSteps(w, steps)
+}
+
+// explainFixSummary summarizes the transformations that cfg
+// makes.
+func explainFixSummary(cfg mca.Config) string {
+	var parts []string
+	if cfg.Dialect == mca.DialectLLVM {
+		parts = append(parts, "in the dialect that llvm-mca accepts")
+	} else {
+		parts = append(parts, "in the dialect that objdump printed")
+	}
+	if cfg.Arch != nil {
+		parts = append(parts, "assuming "+cfg.Arch.Name+" instructions")
+	}
+	if cfg.Normalize {
+		parts = append(parts, "with PC-relative operands at a fixed displacement")
+	}
+	if cfg.Canonical {
+		parts = append(parts, "in canonical form")
+	} else {
+		names := map[string]string{
+			mca.MetaFile:   "file:line",
+			mca.MetaOffset: "offset",
+			mca.MetaInstr:  "encoding",
+			mca.MetaGoAsm:  "Go assembly",
+		}
+		on := map[string]bool{
+			mca.MetaFile:   cfg.File,
+			mca.MetaOffset: cfg.Offset,
+			mca.MetaInstr:  cfg.Instr,
+			mca.MetaGoAsm:  cfg.GoAsm,
+		}
+		order := cfg.MetaOrder
+		if order == nil {
+			order = []string{mca.MetaFile, mca.MetaOffset, mca.MetaInstr, mca.MetaGoAsm}
+		}
+		var cols []string
+		for _, s := range order {
+			if on[s] {
+				cols = append(cols, names[s])
+			}
+		}
+		if len(cols) > 0 {
+			s := "commented with each instruction's " + strings.Join(cols, ", ")
+			if cfg.MetaLabels {
+				s += ", each labeled"
+			}
+			parts = append(parts, s)
+		}
+	}
+	if cfg.Regions {
+		parts = append(parts, "with one code region per symbol")
+	} else if cfg.AutoRegions {
+		parts = append(parts, "with one code region per symbol if there is more than one")
+	}
+	if cfg.Labels {
+		parts = append(parts, "labeling branch targets")
+	}
+	if cfg.Source {
+		parts = append(parts, "keeping objdump -S source lines as comments")
+	}
+	if cfg.Debug {
+		parts = append(parts, "adding each input line as a comment")
+	}
+	if cfg.StartMatch != nil {
+		parts = append(parts, fmt.Sprintf("starting each symbol at its first instruction matching %s", cfg.StartMatch))
+	}
+	switch {
+	case cfg.StopMatch != nil:
+		parts = append(parts, fmt.Sprintf("stopping each symbol at its first instruction matching %s", cfg.StopMatch))
+	case cfg.CollapseRet:
+		parts = append(parts, "keeping every instruction and numbering each return")
+	case cfg.Stop == mca.StopRet:
+		parts = append(parts, "stopping each symbol at its first return")
+	case cfg.Limit <= 0:
+		parts = append(parts, "keeping every instruction")
+	}
+	if cfg.KeepStop && (cfg.StopMatch != nil || cfg.Stop == mca.StopRet && !cfg.CollapseRet) {
+		parts = append(parts, "including the instruction that it stops at")
+	}
+	if cfg.Limit > 0 {
+		parts = append(parts, fmt.Sprintf("keeping at most %d instructions of each symbol", cfg.Limit))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// writeSteps writes a numbered list of steps. Any lines after
+// the first line of a step, like a command, are indented under
+// it.
+func writeSteps(w io.Writer, steps []string) {
+	fmt.Fprintf(w, "%s will:\n", os.Args[0])
+	for i, s := range steps {
+		lines := strings.Split(s, "\n")
+		fmt.Fprintf(w, "  %d. %s\n", i+1, lines[0])
+		for _, l := range lines[1:] {
+			fmt.Fprintf(w, "       %s\n", l)
+		}
+	}
+}
+
+// shellJoin joins args into a command line, quoting arguments
+// that the shell would otherwise interpret.
+func shellJoin(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			strings.ContainsRune("-_./=:,+@%", c)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
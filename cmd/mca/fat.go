@@ -0,0 +1,97 @@
+package main
+
+import (
+	"debug/macho"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// fatCPUs are the Mach-O CPU types of the GOARCHes that mca
+// supports.
+var fatCPUs = map[string]macho.Cpu{
+	"amd64": macho.CpuAmd64,
+	"386":   macho.Cpu386,
+	"arm64": macho.CpuArm64,
+}
+
+// selectSlice returns the path of the binary at path to analyze
+// for goarch, and a function that removes it if it is temporary.
+//
+// "go tool objdump" cannot read universal (fat) Mach-O binaries,
+// so the slice for goarch is copied to a temporary file. If goarch
+// is empty, it is the host's GOARCH. Other binaries are returned
+// as is, after checking that they are for goarch, if set.
+func selectSlice(path, goarch string) (string, func(), error) {
+	nop := func() {}
+	ff, err := macho.OpenFat(path)
+	if err != nil {
+		if goarch == "" {
+			return path, nop, nil
+		}
+		var t target
+		if err := t.readHeader(path); err == nil && t.goarch != goarch {
+			return "", nil, fmt.Errorf("%s is for %s, not %s, and is not a universal binary", path, t.goarch, goarch)
+		}
+		return path, nop, nil
+	}
+	defer ff.Close()
+
+	var have []string
+	for name, cpu := range fatCPUs {
+		for _, a := range ff.Arches {
+			if a.Cpu == cpu {
+				have = append(have, name)
+				break
+			}
+		}
+	}
+	sort.Strings(have)
+	want := goarch
+	if want == "" {
+		want = runtime.GOARCH
+	}
+	var slice *macho.FatArch
+	for i, a := range ff.Arches {
+		if cpu, ok := fatCPUs[want]; ok && a.Cpu == cpu {
+			slice = &ff.Arches[i]
+			break
+		}
+	}
+	if slice == nil {
+		if goarch == "" {
+			return "", nil, fmt.Errorf("%s is a universal binary without a %s slice; use -cpu to pick one of: %s",
+				path, want, strings.Join(have, ", "))
+		}
+		return "", nil, fmt.Errorf("%s has no %s slice (it has: %s)", path, want, strings.Join(have, ", "))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	dir, err := ioutil.TempDir("", "mca-slice")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	out := filepath.Join(dir, filepath.Base(path)+"."+want)
+	w, err := os.Create(out)
+	if err == nil {
+		_, err = io.Copy(w, io.NewSectionReader(f, int64(slice.Offset), int64(slice.Size)))
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return out, cleanup, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+// mcaDiag matches a diagnostic that llvm-mca reports for a line of
+// its input, like "<stdin>:17:1: error: invalid operand".
+var mcaDiag = regexp.MustCompile(`^<stdin>:(\d+):(\d+): (error|warning): (.*)$`)
+
+// diagnostic is a problem with its input that llvm-mca reports.
+type diagnostic struct {
+	// line and col are the position in the input, or zero for
+	// errors about the input as a whole.
+	line, col int
+	kind      string
+	msg       string
+}
+
+func (d diagnostic) String() string {
+	if d.line == 0 {
+		return fmt.Sprintf("%s: %s", d.kind, d.msg)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", d.line, d.col, d.kind, d.msg)
+}
+
+// diagnostics returns the problems with its input that llvm-mca
+// reports in stderr.
+//
+// llvm-mca skips the lines that it cannot parse and analyzes the
+// rest, exiting successfully, so its report alone does not show
+// whether the input was what fix meant it to be.
+func diagnostics(stderr []byte) []diagnostic {
+	var diags []diagnostic
+	s := bufio.NewScanner(bytes.NewReader(stderr))
+	for s.Scan() {
+		t := s.Text()
+		if m := mcaDiag.FindStringSubmatch(t); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			col, _ := strconv.Atoi(m[2])
+			diags = append(diags, diagnostic{line: line, col: col, kind: m[3], msg: m[4]})
+			continue
+		}
+		// Notes about the input as a whole, like returns being
+		// ignored, are not problems with it, but errors are.
+		if strings.HasPrefix(t, "error: ") {
+			diags = append(diags, diagnostic{kind: "error", msg: strings.TrimPrefix(t, "error: ")})
+		}
+	}
+	return diags
+}
+
+// lintCmd checks that fix's output for the objdump output in path
+// parses as llvm-mca input, without analyzing it.
+func lintCmd(path string, args []string) error {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s lint [FILE | -] [options...] [-- LLVM-MCA ARGS]\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	var (
+		symRegs regexpsFlag
+		isa     string
+		stop    string
+		cfg     = mca.Config{File: true, Offset: true, GoAsm: true, AutoRegions: true, Warn: warn}
+	)
+	fs.Var(&symRegs, "s", "only check symbols matching this regexp (repeatable: symbols matching any of them)")
+	fs.StringVar(&cfg.Dialect, "dialect", mca.DialectLLVM, "assembly dialect to check: gnu or llvm")
+	fs.StringVar(&isa, "isa", mca.ISAAuto, "architecture of the input, which picks llvm-mca's -mtriple: amd64, 386, arm64 or auto (inferred from the input)")
+	fs.BoolVar(&cfg.Normalize, "normalize", false, "rewrite PC-relative operands to a fixed displacement, as fix -normalize does")
+	fs.BoolVar(&cfg.Labels, "labels", false, "label branch targets, as fix -labels does")
+	fs.StringVar(&stop, "stop", mca.StopNone, "when to stop each symbol, as for fix: none, ret or a `REGEXP`")
+	fs.BoolVar(&verbose, "v", false, "print the llvm-mca command to stderr")
+	toolFlags()
+	errorsFlag()
+
+	ourArgs := args
+	var mcaArgs []string
+	for i, s := range args {
+		if s == "--" {
+			ourArgs, mcaArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+	fs.Parse(ourArgs)
+	if err := checkErrorsFlag(); err != nil {
+		return err
+	}
+	path, err := fileArg(path, " (llvm-mca's arguments go after --)")
+	if err != nil {
+		return err
+	}
+	if cfg.Dialect != mca.DialectGNU && cfg.Dialect != mca.DialectLLVM {
+		return useErrf("unknown -dialect %q", cfg.Dialect)
+	}
+	if cfg.Stop, cfg.StopMatch, err = parseStop(stop); err != nil {
+		return err
+	}
+	if cfg.Arch, err = parseISA(isa); err != nil {
+		return err
+	}
+	if len(symRegs) > 0 {
+		cfg.Symbols = regexp.MustCompile(symRegs.join())
+	}
+	if err := checkTools("llvm-mca"); err != nil {
+		return err
+	}
+
+	r := io.Reader(os.Stdin)
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	r, err = gunzip(r)
+	if err != nil {
+		return err
+	}
+	in, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if cfg.Arch == nil {
+		// The input need not be for the host, which llvm-mca
+		// would assume.
+		if cfg.Arch, err = inputArch(in); err != nil {
+			return err
+		}
+	}
+	var fixed bytes.Buffer
+	if err := cfg.Fix(&fixed, bytes.NewReader(in)); err != nil {
+		return err
+	}
+	lines := strings.Split(fixed.String(), "\n")
+
+	// Only parse errors matter, so one iteration is enough and
+	// the report is discarded.
+	mcaArgs = envTargetArgs(cfg.Arch, "", mcaArgs)
+	if !hasMCAFlag(mcaArgs, "iterations") {
+		mcaArgs = append(mcaArgs[:len(mcaArgs):len(mcaArgs)], "-iterations=1")
+	}
+	mcaArgs = append(mcaArgs[:len(mcaArgs):len(mcaArgs)], "-o", os.DevNull)
+	var stderr bytes.Buffer
+	cmd := command(mcaTool, mcaArgs...)
+	cmd.Stdin = bytes.NewReader(fixed.Bytes())
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	diags := diagnostics(stderr.Bytes())
+
+	bw := bufio.NewWriter(os.Stdout)
+	for _, d := range diags {
+		fmt.Fprintln(bw, d)
+		// The line is one of fix's, which is commented with
+		// the instruction's source position and offset.
+		if d.line >= 1 && d.line <= len(lines) {
+			fmt.Fprintf(bw, "\t%s\n", strings.TrimSpace(lines[d.line-1]))
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if len(diags) > 0 {
+		return fmt.Errorf("llvm-mca reported %d problems with the input", len(diags))
+	}
+	if runErr != nil {
+		os.Stderr.Write(stderr.Bytes())
+	}
+	return mcaErr(runErr)
+}
+
+// inputArch returns the architecture of the disassembly in, for
+// -isa auto.
+func inputArch(in []byte) (*mca.Arch, error) {
+	syms, err := mca.Parse(bytes.NewReader(in))
+	if err != nil {
+		return nil, fmt.Errorf("cannot infer -isa, pass -isa: %w", err)
+	}
+	var lines []mca.Line
+	for _, s := range syms {
+		lines = append(lines, s.Lines...)
+	}
+	a := mca.DetectArch(lines)
+	if a == nil {
+		return nil, useErr("cannot infer -isa from the input, pass -isa")
+	}
+	return a, nil
+}
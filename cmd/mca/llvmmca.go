@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// mcaCmd runs llvm-mca on the output of fix that was saved to
+// path, without disassembling or fixing it again.
+func mcaCmd(path string, args []string) error {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s mca [FILE | -] [options...] [-- LLVM-MCA ARGS]\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	fs.BoolVar(&verbose, "v", false, "print the llvm-mca command to stderr")
+	toolFlags()
+
+	ourArgs := args
+	var mcaArgs []string
+	for i, s := range args {
+		if s == "--" {
+			ourArgs, mcaArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+	fs.Parse(ourArgs)
+	path, err := fileArg(path, " (llvm-mca's arguments go after --)")
+	if err != nil {
+		return err
+	}
+	if err := checkTools("llvm-mca"); err != nil {
+		return err
+	}
+
+	r := io.Reader(os.Stdin)
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	r, err = gunzip(r)
+	if err != nil {
+		return err
+	}
+
+	cmd := command(mcaTool, mcaArgs...)
+	cmd.Stdin = r
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return mcaErr(cmd.Run())
+}
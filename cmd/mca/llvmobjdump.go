@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"regexp"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+// The disassemblers that run can use.
+const (
+	disasmGo   = "go"
+	disasmLLVM = "llvm-objdump"
+)
+
+// llvmObjdumpArgs returns the arguments to llvm-objdump that
+// disassemble bin.
+func llvmObjdumpArgs(bin string) []string {
+	return []string{"-d", bin}
+}
+
+// llvmObjdump returns the "llvm-objdump -d" output for the
+// functions in bin that match symReg.
+//
+// llvm-objdump disassembles up to the next symbol, so the
+// padding after each function is dropped using the sizes in
+// bin's symbol table.
+func llvmObjdump(bin, symReg string) ([]byte, error) {
+	re, err := regexp.Compile(symReg)
+	if err != nil {
+		return nil, err
+	}
+	tab, err := mca.ReadSymtab(bin)
+	if err != nil {
+		return nil, err
+	}
+	cmd := command(disasmLLVM, llvmObjdumpArgs(bin)...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		b    bytes.Buffer
+		keep bool
+		name string
+	)
+	format := mca.LLVMObjdumpFormat
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		t := s.Text()
+		if n, ok := format.Symbol(t); ok {
+			name, keep = n, re.MatchString(n)
+		} else if format.Skip(t) {
+			continue
+		} else if keep {
+			l, err := format.Split(t)
+			if err != nil {
+				continue
+			}
+			if sym, ok := tab.Lookup(l.Offset); !ok || sym.Name != name {
+				continue
+			}
+		}
+		if keep {
+			b.WriteString(t)
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes(), s.Err()
+}
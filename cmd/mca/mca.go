@@ -2,18 +2,25 @@ package main
 
 import (
 	"bufio"
-	"encoding/hex"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"math/bits"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
-	"text/tabwriter"
+	"syscall"
+	"time"
 
+	mca "github.com/ericlagergren/go-llvm-mca"
 	"golang.org/x/sync/errgroup"
 	exec "golang.org/x/sys/execabs"
 )
@@ -22,9 +29,22 @@ func main() {
 	if err := main1(); err != nil {
 		log.SetFlags(0)
 		var ue *usageError
+		var xe *exitError
+		if errorsFormat == "json" {
+			writeJSONError(os.Stderr, err)
+			if errors.As(err, &xe) {
+				os.Exit(xe.err.ExitCode())
+			}
+			os.Exit(1)
+		}
 		if errors.As(err, &ue) {
 			log.Printf("%s: %v", os.Args[0], err)
 			fs.Usage()
+		} else if errors.As(err, &xe) {
+			// Exit as llvm-mca did, so that scripts can tell
+			// its failures apart.
+			log.Printf("%s: %v", os.Args[0], err)
+			os.Exit(xe.err.ExitCode())
 		} else {
 			log.Fatalf("%s: %v", os.Args[0], err)
 		}
@@ -53,14 +73,201 @@ func main1() error {
 	case "-h", "-help", "--help":
 		return help()
 	case "fix":
+		// $exe fix [FILE] [options...]
+		//
+		// FILE can also follow the options, which fileArg
+		// handles.
+		if len(args) == 0 || args[0] != "-" && strings.HasPrefix(args[0], "-") {
+			return fixCmd("", args)
+		}
 		return fixCmd(args[0], args[1:])
+	case "mca":
+		// $exe mca [FILE] [options...] [-- LLVM-MCA ARGS]
+		//
+		// FILE can also follow the options, which fileArg
+		// handles.
+		if len(args) == 0 || args[0] != "-" && strings.HasPrefix(args[0], "-") {
+			return mcaCmd("", args)
+		}
+		return mcaCmd(args[0], args[1:])
+	case "lint":
+		// $exe lint [FILE] [options...] [-- LLVM-MCA ARGS]
+		//
+		// FILE can also follow the options, which fileArg
+		// handles.
+		if len(args) == 0 || args[0] != "-" && strings.HasPrefix(args[0], "-") {
+			return lintCmd("", args)
+		}
+		return lintCmd(args[0], args[1:])
 	case "run":
-		return runCmd(args)
+		return runCmd(args, nil)
+	case "build":
+		return buildCmd(args)
+	case "batch":
+		return batchCmd(args)
+	case "replay":
+		return replayCmd(args)
+	case "diff":
+		return diffCmd(args)
+	case "analyze":
+		return analyzeCmd(args)
+	case "version", "-version", "--version":
+		return versionCmd(args)
 	default:
 		return useErrf("%s: unknown command (see '%s help')", os.Args[0], cmd)
 	}
 }
 
+// toolHints are how to install the tools that mca runs.
+var toolHints = map[string]string{
+	"go":           "install Go from https://go.dev/dl",
+	"llvm-mca":     "install the LLVM tools, like the llvm package of apt or Homebrew",
+	"wasm-objdump": "install WABT, the WebAssembly Binary Toolkit",
+	"llvm-objdump": "install the LLVM tools, like the llvm package of apt or Homebrew",
+}
+
+// The go and llvm-mca commands that mca runs.
+//
+// They default to $MCA_GO and $MCA_LLVM_MCA, or to "go" and
+// "llvm-mca" in $PATH, and can be overridden with the -go and
+// -llvm-mca flags.
+var (
+	goTool  = getenv("MCA_GO", "go")
+	mcaTool = getenv("MCA_LLVM_MCA", "llvm-mca")
+)
+
+// getenv returns the environment variable key or, if it is unset
+// or empty, def.
+func getenv(key, def string) string {
+	if s := os.Getenv(key); s != "" {
+		return s
+	}
+	return def
+}
+
+// toolFlags adds the -go and -llvm-mca flags to fs.
+func toolFlags() {
+	fs.StringVar(&goTool, "go", goTool, "go command to run (or set $MCA_GO)")
+	fs.StringVar(&mcaTool, "llvm-mca", mcaTool, "llvm-mca command to run (or set $MCA_LLVM_MCA)")
+}
+
+// toolPath returns the command that runs the named tool.
+func toolPath(name string) string {
+	switch name {
+	case "go":
+		return goTool
+	case "llvm-mca":
+		return mcaTool
+	}
+	return name
+}
+
+// verbose logs each command that mca runs to stderr.
+var verbose bool
+
+// cmdCtx is the context of the commands that mca runs, which are
+// killed when it is done.
+var cmdCtx = context.Background()
+
+// command returns the exec.Cmd that runs name with args in cmdCtx,
+// logging it first if verbose is set.
+func command(name string, args ...string) *exec.Cmd {
+	var env []string
+	if name == goTool {
+		env = goEnv
+	}
+	if verbose {
+		msg := shellJoin(append(append(env[:len(env):len(env)], name), args...)...)
+		if path, err := exec.LookPath(name); err == nil && path != name {
+			msg += fmt.Sprintf(" (%s is %s)", name, path)
+		}
+		fmt.Fprintf(os.Stderr, "%s: running %s\n", os.Args[0], msg)
+	}
+	cmd := exec.CommandContext(cmdCtx, name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
+
+// exitError is the error of llvm-mca exiting with a non-zero
+// status, which mca exits with too.
+type exitError struct {
+	err *exec.ExitError
+}
+
+func (e *exitError) Error() string {
+	return "llvm-mca: " + e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// mcaErr returns err, the error from running llvm-mca, as an
+// *exitError if llvm-mca exited with a non-zero status rather
+// than being killed.
+func mcaErr(err error) error {
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() > 0 {
+		return &exitError{err: ee}
+	}
+	return err
+}
+
+// checkTools checks that each of the named tools can be found.
+func checkTools(names ...string) error {
+	for _, name := range names {
+		path := toolPath(name)
+		if _, err := exec.LookPath(path); err != nil {
+			if path != name {
+				return useErrf("%s command %q not found", name, path)
+			}
+			return useErrf("%s not found in $PATH; %s", name, toolHints[name])
+		}
+	}
+	return nil
+}
+
+// fileArg returns the FILE argument of fix, mca and lint after fs
+// has parsed their options. path is the argument if it preceded
+// the options, or "" if it did not, in which case it is the first
+// argument after them, like "a.txt" in "fix -labels a.txt", and
+// the rest are parsed as options too.
+//
+// hint is added to the error for an unexpected argument.
+func fileArg(path, hint string) (string, error) {
+	if path == "" && fs.NArg() > 0 {
+		path = fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
+	}
+	if fs.NArg() > 0 {
+		return "", useErrf("unexpected argument %q%s", fs.Arg(0), hint)
+	}
+	return path, nil
+}
+
+// countTrue returns the number of bs that are true.
+func countTrue(bs ...bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// warnf prints a warning to stderr.
+func warnf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "%s: warning: "+format+"\n", append([]interface{}{os.Args[0]}, args...)...)
+}
+
+// warn prints a warning from Fix to stderr, for Config.Warn.
+func warn(msg string) {
+	warnf("%s", msg)
+}
+
 type usageError struct {
 	error
 }
@@ -73,20 +280,134 @@ func useErrf(format string, args ...interface{}) error {
 	return &usageError{error: fmt.Errorf(format, args...)}
 }
 
+// parseISA is mca.ParseISA, but reports unknown names as usage
+// errors.
+func parseISA(s string) (*mca.Arch, error) {
+	a, err := mca.ParseISA(s)
+	if err != nil {
+		return nil, &usageError{error: err}
+	}
+	return a, nil
+}
+
 var fs = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
 func help() error {
-	return fmt.Errorf("Usage: %s [fix | run] [options...]", os.Args[0])
+	return fmt.Errorf("Usage: %s [fix | mca | lint | run | build | batch | replay | diff | analyze | version] [options...]", os.Args[0])
 }
 
-func runCmd(args []string) error {
+// runCmd runs the run command or, if b is non-nil, the build
+// command, which analyzes a package that it builds with b.
+func runCmd(args []string, b *buildFlags) (err error) {
 	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s run -s REGEXP BINARY\n", os.Args[0])
+		if b != nil {
+			fmt.Fprintf(os.Stderr, "Usage: %s build [-s REGEXP | -trace FILE] [options] PACKAGE [-- LLVM-MCA ARGS]\n", os.Args[0])
+		} else {
+			fmt.Fprintf(os.Stderr, "Usage: %s run [-s REGEXP | -trace FILE] BINARY\n", os.Args[0])
+		}
 		fs.PrintDefaults()
 		os.Exit(1)
 	}
-	var symReg string
-	fs.StringVar(&symReg, "s", "", "only dump symbols matching this regexp")
+	var (
+		symRegs      regexpsFlag
+		symReg       string
+		byBottleneck bool
+		tracePath    string
+		traceGoid    int64
+		traceTop     int
+		followDepth  int
+		dialect      string
+		explain      bool
+		threshold    float64
+		keepalive    bool
+		svgPath      string
+		maxSpills    int
+		stop         string
+		printTriple  bool
+		printCPU     bool
+		maxLineLen   int
+		limit        int
+		labels       bool
+		deps         bool
+		sessionPath  string
+		minCycles    int
+		isa          string
+		collapseRet  bool
+		onUnknown    string
+		strict       bool
+		source       bool
+		regions      = regionsFlag("auto")
+		stopAt       []string
+		mcpu         string
+		iterations   int
+		dumpFlags    string
+		disassembler string
+		perSymbol    bool
+		jobs         int
+		brief        bool
+		force        bool
+		start        string
+		normalize    bool
+		timeout      time.Duration
+		addrFlag     string
+		dryRun       bool
+		keepRet      bool
+		cpu          string
+		goos         string
+		goarch       string
+	)
+	fs.Var(&symRegs, "s", "only dump symbols matching this regexp (repeatable: symbols matching any of them)")
+	fs.Var(funcFlag{&symRegs}, "func", "only dump the Go function with this name, like crypto/sha256.block or pkg.(*T).M (repeatable, like -s)")
+	fs.StringVar(&addrFlag, "addr", "", "only analyze the instructions at addresses in `START:END`, in hex with END exclusive, of the symbols that match -s or -func or, by default, overlap it")
+	fs.BoolVar(&byBottleneck, "by-bottleneck", false, "group llvm-mca's report for each symbol by its primary bottleneck")
+	fs.StringVar(&tracePath, "trace", "", "dump the hottest functions in this execution trace instead of -s")
+	fs.Int64Var(&traceGoid, "goroutine", 0, "with -trace, only count samples from this goroutine ID")
+	fs.IntVar(&traceTop, "top", 5, "with -trace, the number of functions to dump")
+	fs.IntVar(&followDepth, "follow-calls", 0, "also analyze the functions called by each symbol, up to this many calls deep")
+	fs.StringVar(&dialect, "dialect", mca.DialectLLVM, "assembly dialect fed to llvm-mca: gnu or llvm")
+	fs.BoolVar(&normalize, "normalize", false, "rewrite PC-relative operands, like %rip displacements and adrp addresses, to a fixed displacement (lossy)")
+	fs.StringVar(&stop, "stop", mca.StopNone, "when to stop emitting each symbol's instructions: none (emit every instruction), ret (at its first return) or a `REGEXP` for the GNU assembly of the instruction to stop at")
+	fs.StringVar(&start, "start", "", "skip each symbol's instructions before the first one whose GNU assembly matches this `REGEXP`")
+	fs.BoolVar(&keepRet, "keep-ret", false, "with -stop, also analyze the return or other instruction that it stops at")
+	fs.Var((*listFlag)(&stopAt), "stop-at", "comma-separated returns that -stop ret stops at, as mnemonics or instructions (default: the architecture's returns)")
+	fs.StringVar(&onUnknown, "on-unknown", mca.UnknownComment, "what to do with instructions that could not be decoded: comment, skip or error")
+	fs.BoolVar(&strict, "strict", false, "fail on lines that cannot be parsed, and on encodings of implausible length for the architecture, instead of warning")
+	fs.BoolVar(&source, "source", false, "keep the Go source lines of -objdump-flags=-S output as comments")
+	fs.Var(&regions, "regions", "analyze each symbol as its own code region: true, false or auto (if there is more than one symbol)")
+	fs.BoolVar(&collapseRet, "collapse-ret", false, "mark each return with its number (implies -stop none)")
+	fs.StringVar(&dumpFlags, "objdump-flags", "", "space-separated extra flags for go tool objdump, like -S")
+	fs.StringVar(&disassembler, "disassembler", disasmGo, "disassembler to use: go (go tool objdump) or llvm-objdump")
+	fs.BoolVar(&verbose, "v", false, "print each command that is run, like go tool objdump and llvm-mca, to stderr")
+	cacheFlag()
+	errorsFlag()
+	progressFlag()
+	fs.DurationVar(&timeout, "timeout", 0, "kill the commands that are run and fail if they take longer than this, like 5m (0: no timeout)")
+	fs.StringVar(&mcpu, "mcpu", "", "pass -mcpu to llvm-mca, unless set after --")
+	fs.IntVar(&iterations, "iterations", 0, "pass -iterations to llvm-mca, unless set after -- (0: llvm-mca's default of 100)")
+	toolFlags()
+	fs.StringVar(&isa, "isa", mca.ISAAuto, "architecture of the disassembly: amd64, 386, arm64 or auto (detect from the binary's header)")
+	fs.BoolVar(&force, "force", false, "analyze binaries for unsupported architectures instead of failing")
+	fs.StringVar(&goos, "goos", "", "the GOOS to run go with, and to pick llvm-mca's -mtriple for (default: $GOOS for build, the binary's for run)")
+	fs.StringVar(&goarch, "goarch", "", "the GOARCH to run go with, and to pick the returns and llvm-mca's -mtriple for: amd64, 386 or arm64 (default: $GOARCH for build, the binary's for run)")
+	fs.StringVar(&cpu, "cpu", "", "the GOARCH of the slice of a universal Mach-O binary to analyze: amd64, 386 or arm64 (default: the host's)")
+	fs.IntVar(&maxLineLen, "max-line-length", 0, "warn about instructions longer than this many characters (0: no limit)")
+	fs.BoolVar(&labels, "labels", false, "label branch targets and rewrite branches to use the labels, so that llvm-mca can see loops")
+	fs.IntVar(&limit, "limit", 0, "only analyze the first this many instructions of each symbol (0: no limit)")
+	fs.IntVar(&minCycles, "min-cycles", 0, "analyze each symbol separately and omit those that llvm-mca estimates take fewer than this many total cycles")
+	fs.BoolVar(&deps, "deps", false, "also report each instruction's register dependencies and the longest dependency chain")
+	fs.BoolVar(&explain, "explain", false, "describe the pipeline before running it")
+	fs.BoolVar(&dryRun, "n", false, "print the go tool objdump and llvm-mca commands that would be run, with the flags that mca adds, and exit without running them")
+	fs.BoolVar(&dryRun, "dry-run", false, "same as -n")
+	fs.BoolVar(&brief, "brief", false, "print one line per symbol with its cycles, IPC, block throughput and busiest resource instead of llvm-mca's report")
+	fs.StringVar(&sessionPath, "save-session", "", "save the binary's hash, the flags and every stage's output to this archive for replay")
+	fs.BoolVar(&printTriple, "print-triple", false, "print the binary's detected llvm-mca -mtriple and exit")
+	fs.BoolVar(&printCPU, "print-cpu", false, "print the suggested llvm-mca -mcpu and -mattr for the binary and exit")
+	fs.BoolVar(&keepalive, "keepalive", false, "with -follow-calls, analyze every function with a single llvm-mca process")
+	fs.BoolVar(&perSymbol, "per-symbol", false, "analyze each symbol with its own llvm-mca process and print the reports under the symbols' names")
+	fs.IntVar(&jobs, "j", runtime.GOMAXPROCS(0), "with -per-symbol, the number of llvm-mca processes to run at once")
+	fs.IntVar(&maxSpills, "fail-on-spill", -1, "fail if any symbol has more than this many register spills and reloads (negative: no limit)")
+	fs.StringVar(&svgPath, "svg", "", "also render the resource pressure by instruction view as an SVG heatmap to this file")
+	fs.Float64Var(&threshold, "threshold-port", 0, "mark instructions whose pressure on any resource exceeds this many cycles per iteration")
 
 	ourArgs := args
 	var mcaArgs []string
@@ -97,28 +418,336 @@ func runCmd(args []string) error {
 		}
 	}
 	fs.Parse(ourArgs)
+	if err := checkErrorsFlag(); err != nil {
+		return err
+	}
+	if timeout < 0 {
+		return useErr("-timeout must not be negative")
+	}
+	// Kill the commands on SIGINT and SIGTERM, so that they are
+	// not left running, and on -timeout.
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	go func() {
+		// A second signal kills mca as usual.
+		<-sigCtx.Done()
+		stopSignals()
+	}()
+	ctx, cancel := sigCtx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(sigCtx, timeout)
+	}
+	defer cancel()
+	cmdCtx = ctx
+	defer func() {
+		switch {
+		case err == nil:
+		case sigCtx.Err() != nil:
+			err = fmt.Errorf("interrupted: %w", err)
+		case ctx.Err() == context.DeadlineExceeded:
+			err = fmt.Errorf("timed out after %v: %w", timeout, err)
+		}
+	}()
+	noBuildSettings = dryRun
+	if goarch != "" {
+		a, ok := mca.LookupArch(goarch)
+		if !ok {
+			return useErrf("unsupported -goarch %q (supported: %s)", goarch, strings.Join(mca.ArchNames(), ", "))
+		}
+		if isa != mca.ISAAuto && isa != a.Name {
+			return useErrf("-isa %s and -goarch %s disagree", isa, goarch)
+		}
+		isa = a.Name
+		if cpu == "" {
+			cpu = goarch
+		}
+		goEnv = append(goEnv, "GOARCH="+goarch)
+	}
+	if goos != "" {
+		goEnv = append(goEnv, "GOOS="+goos)
+	}
+	bin, binName := fs.Arg(0), "binary"
+	if b != nil {
+		binName = "package"
+	}
+	// buildArgs are the arguments to go that build the package,
+	// for -n.
+	var buildArgs []string
+	if b != nil && bin != "" && dryRun {
+		if err := b.check(); err != nil {
+			return err
+		}
+		path := filepath.Join(os.TempDir(), "mca-build", "bin")
+		buildArgs = b.args(bin, path)
+		bin = path
+	} else if b != nil && bin != "" {
+		if err := checkTools("go"); err != nil {
+			return err
+		}
+		path, cleanup, err := b.build(bin)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		bin = path
+	}
+	// binArg is the binary as given, before its slice is
+	// selected.
+	binArg := bin
+	if cpu != "" {
+		if _, ok := fatCPUs[cpu]; !ok {
+			return useErrf("unknown -cpu %q", cpu)
+		}
+	}
+	if bin != "" && buildArgs == nil {
+		path, cleanup, err := selectSlice(bin, cpu)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		bin = path
+	}
 
+	if dialect != mca.DialectGNU && dialect != mca.DialectLLVM {
+		return useErrf("unknown -dialect %q", dialect)
+	}
+	stop, stopMatch, err := parseStop(stop)
+	if err != nil {
+		return err
+	}
+	startMatch, err := parseStart(start)
+	if err != nil {
+		return err
+	}
+	addr, err := parseAddr(addrFlag)
+	if err != nil {
+		return err
+	}
+	if err := checkOnUnknown(onUnknown); err != nil {
+		return err
+	}
+	forced, err := parseISA(isa)
+	if err != nil {
+		return err
+	}
+	if printTriple || printCPU {
+		if fs.NArg() == 0 {
+			return useErr("missing " + binName)
+		}
+		t, err := detectTarget(bin, forced)
+		if err != nil {
+			return err
+		}
+		flags := t.mcaFlags()
+		if !printCPU {
+			flags = flags[:1]
+		} else if !printTriple {
+			flags = flags[1:]
+		}
+		fmt.Println(strings.Join(flags, " "))
+		return nil
+	}
+	symReg = symRegs.join()
+	if tracePath != "" {
+		if symReg != "" {
+			return useErr("-s and -func are mutually exclusive with -trace")
+		}
+		syms, err := traceSymbols(tracePath, traceGoid, traceTop)
+		if err != nil {
+			return err
+		}
+		symReg = symbolsRegexp(syms)
+	}
+	if addr != nil && symReg == "" && fs.NArg() > 0 {
+		tab, err := mca.ReadSymtab(bin)
+		if err != nil {
+			return err
+		}
+		var names []string
+		for _, s := range tab.Overlapping(addr.Start, addr.End) {
+			names = append(names, s.Name)
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("no symbols in %s overlap -addr %s", bin, addr)
+		}
+		symReg = symbolsRegexp(names)
+	}
 	if symReg == "" {
-		return useErr("must set -s flag")
+		return useErr("must set -s, -func or -addr flag")
 	}
 	if fs.NArg() == 0 {
-		return useErr("missing binary")
+		return useErr("missing " + binName)
+	}
+	if err := checkArch(bin); err != nil {
+		if !force {
+			return fmt.Errorf("%w; use -force to analyze it anyway", err)
+		}
+		warnf("%v", err)
+	}
+	cfg := mca.Config{
+		Dialect:     dialect,
+		Normalize:   normalize,
+		Stop:        stop,
+		Regions:     byBottleneck || minCycles > 0,
+		MaxLineLen:  maxLineLen,
+		Limit:       limit,
+		Labels:      labels,
+		CollapseRet: collapseRet,
+		OnUnknown:   onUnknown,
+		Strict:      strict,
+		Verbose:     verbose,
+		Warn:        warn,
+		Source:      source,
+		StopAt:      stopAt,
+		StopMatch:   stopMatch,
+		KeepStop:    keepRet,
+		StartMatch:  startMatch,
+		Addr:        addr,
+	}
+	regions.apply(&cfg)
+	if maxSpills >= 0 {
+		cfg.CheckSpills, cfg.MaxSpills = true, maxSpills
+	}
+	if cfg.Arch = forced; cfg.Arch == nil {
+		cfg.Arch = detectArch(bin)
+	}
+	if buildArgs != nil {
+		// The binary is not built, so assume that go build
+		// would target $GOOS and $GOARCH.
+		mcaArgs = envTargetArgs(forced, mcpu, mcaArgs)
+	} else {
+		mcaArgs = targetArgs(bin, forced, mcpu, mcaArgs)
+	}
+	objdumpFlags = strings.Fields(dumpFlags)
+	if err := checkObjdumpFlags(objdumpFlags); err != nil {
+		return err
+	}
+	switch disassembler {
+	case disasmGo:
+	case disasmLLVM:
+		if len(objdumpFlags) > 0 || followDepth > 0 || perSymbol {
+			return useErr("-disassembler llvm-objdump is mutually exclusive with -objdump-flags, -follow-calls and -per-symbol")
+		}
+	default:
+		return useErrf("unknown -disassembler %q", disassembler)
+	}
+	if iterations < 0 {
+		return useErr("-iterations must not be negative")
+	}
+	if iterations > 0 && !hasMCAFlag(mcaArgs, "iterations") {
+		mcaArgs = append(mcaArgs[:len(mcaArgs):len(mcaArgs)], "-iterations="+strconv.Itoa(iterations))
+	}
+	if jobs < 1 {
+		return useErr("-j must be at least 1")
+	}
+	if perSymbol && (followDepth > 0 || byBottleneck || svgPath != "" || deps || minCycles > 0 || sessionPath != "" || brief) {
+		return useErr("-per-symbol is mutually exclusive with -follow-calls, -by-bottleneck, -svg, -deps, -min-cycles, -save-session and -brief")
+	}
+	if followDepth > 0 && byBottleneck {
+		return useErr("-follow-calls and -by-bottleneck are mutually exclusive")
+	}
+	if followDepth > 0 && (svgPath != "" || deps || minCycles > 0 || brief) {
+		return useErr("-follow-calls is mutually exclusive with -svg, -deps, -min-cycles and -brief")
+	}
+	if byBottleneck && deps {
+		return useErr("-by-bottleneck and -deps are mutually exclusive")
+	}
+	if brief && (byBottleneck || deps || threshold > 0) {
+		return useErr("-brief is mutually exclusive with -by-bottleneck, -deps and -threshold-port")
+	}
+	if explain {
+		explainRun(os.Stderr, bin, symReg, disassembler, tracePath, followDepth, perSymbol, byBottleneck, cfg, mcaArgs)
+	}
+	if minCycles < 0 {
+		return useErr("-min-cycles must not be negative")
+	}
+	if sessionPath != "" && (followDepth > 0 || svgPath != "") {
+		return useErr("-save-session is mutually exclusive with -follow-calls and -svg")
+	}
+	if dryRun {
+		if byBottleneck || deps {
+			mcaArgs = append(mcaArgs[:len(mcaArgs):len(mcaArgs)], "-bottleneck-analysis")
+		}
+		return writeDryRun(os.Stdout, buildArgs, bin, symReg, disassembler, followDepth, perSymbol, mcaArgs)
+	}
+	if isWasm(bin) {
+		if sessionPath != "" {
+			return useErr("-save-session does not support wasm binaries")
+		}
+		if err := checkTools("wasm-objdump"); err != nil {
+			return err
+		}
+		return runWasm(bin, symReg, cfg, byBottleneck || followDepth > 0 || svgPath != "" || threshold > 0 || deps || perSymbol || brief)
+	}
+	tools := []string{"go", "llvm-mca"}
+	if disassembler == disasmLLVM {
+		tools[0] = disasmLLVM
+	}
+	if err := checkTools(tools...); err != nil {
+		return err
+	}
+	if followDepth > 0 {
+		return followCalls(os.Stdout, bin, symReg, followDepth, cfg, threshold, keepalive, mcaArgs)
+	}
+	if perSymbol {
+		return runPerSymbol(os.Stdout, bin, symReg, jobs, cfg, threshold, mcaArgs)
 	}
 
-	cmd := exec.Command("go",
-		"tool", "objdump",
-		"-gnu",
-		"-s", symReg,
-		fs.Arg(0),
+	// cmd, if non-nil, is go tool objdump, whose output is
+	// streamed to fix. llvm-objdump's output is filtered first,
+	// and cached output is read whole.
+	var (
+		cmd *exec.Cmd
+		rc  io.Reader
 	)
-	cmd.Stderr = os.Stderr
-	rc, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
+	switch {
+	case disassembler == disasmLLVM:
+		dump, err := llvmObjdump(bin, symReg)
+		if err != nil {
+			return err
+		}
+		rc = bytes.NewReader(dump)
+	case cacheDir != "":
+		dump, err := objdump(bin, symReg)
+		if err != nil {
+			return err
+		}
+		rc = bytes.NewReader(dump)
+	default:
+		cmd = command(goTool, objdumpArgs(bin, symReg)...)
+		cmd.Stderr = os.Stderr
+		r, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		rc = r
+	}
+
+	if byBottleneck || deps {
+		mcaArgs = append(mcaArgs, "-bottleneck-analysis")
+	}
+	opts := reportOptions{
+		ByBottleneck: byBottleneck,
+		Deps:         deps,
+		Threshold:    threshold,
+		MinCycles:    minCycles,
+		Brief:        brief,
 	}
+	// capture is set if llvm-mca's output is post-processed or
+	// saved.
+	capture := byBottleneck || threshold > 0 || svgPath != "" || deps || minCycles > 0 || sessionPath != "" || brief
 
-	cmd2 := exec.Command("llvm-mca", mcaArgs...)
+	var (
+		out     bytes.Buffer
+		dump    bytes.Buffer
+		input   bytes.Buffer
+		created = time.Now()
+	)
+	cmd2 := command(mcaTool, mcaArgs...)
 	cmd2.Stdout = os.Stdout
+	if capture {
+		cmd2.Stdout = &out
+	}
 	cmd2.Stderr = os.Stderr
 	wc, err := cmd2.StdinPipe()
 	if err != nil {
@@ -126,253 +755,586 @@ func runCmd(args []string) error {
 	}
 
 	var grp errgroup.Group
+	if cmd != nil {
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+	}
+	var endProgress func()
+	cfg.Progress, endProgress = progress()
 	grp.Go(func() error {
 		defer wc.Close()
-		var cfg fixConfig
-		return cfg.fix(wc, rc)
+		var err error
+		if sessionPath != "" {
+			err = cfg.Fix(io.MultiWriter(wc, &input), io.TeeReader(rc, &dump))
+		} else {
+			err = cfg.Fix(wc, rc)
+		}
+		endProgress()
+		if cmd == nil {
+			return err
+		}
+		if err != nil {
+			// Nothing reads the rest of objdump's output, so
+			// it would block forever.
+			cmd.Process.Kill()
+		}
+		// Wait closes the pipe, so it must not be called
+		// until fix has read all of objdump's output.
+		if werr := cmd.Wait(); err == nil {
+			err = werr
+		}
+		return err
 	})
-	if err := cmd.Start(); err != nil {
+	if err := cmd2.Start(); err != nil {
 		return err
 	}
-	if err := cmd2.Start(); err != nil {
+	var waitErr error
+	grp.Go(func() error {
+		waitErr = mcaErr(cmd2.Wait())
+		return waitErr
+	})
+	if err := grp.Wait(); err != nil {
+		// If llvm-mca exits early, writing its input fails
+		// too. Report llvm-mca's error, whose status mca
+		// exits with.
+		if waitErr != nil {
+			return waitErr
+		}
 		return err
 	}
-	grp.Go(cmd.Wait)
-	grp.Go(cmd2.Wait)
-	return grp.Wait()
+	if sessionPath != "" {
+		sum, err := hashFile(binArg)
+		if err != nil {
+			return err
+		}
+		err = saveSession(sessionPath, session{
+			manifest: sessionManifest{
+				Created: created,
+				Binary:  binArg,
+				SHA256:  sum,
+				Args:    args,
+				Report:  opts,
+			},
+			objdump: dump.Bytes(),
+			input:   input.Bytes(),
+			mca:     out.Bytes(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if svgPath != "" {
+		if err := createSVG(svgPath, splitRegions(out.Bytes())); err != nil {
+			return err
+		}
+	}
+	if capture {
+		return opts.write(os.Stdout, out.Bytes())
+	}
+	return nil
 }
 
 func fixCmd(path string, args []string) error {
 	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s fix -s REGEXP BINARY\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s fix [FILE | -] [options...]\n", os.Args[0])
 		fs.PrintDefaults()
 		os.Exit(1)
 	}
 	var (
-		outPath string
-		cfg     fixConfig
+		outPath   string
+		cfg       mca.Config
+		explain   bool
+		enc       string
+		filter    string
+		exclude   string
+		isa       string
+		symRegs   regexpsFlag
+		regions   = regionsFlag("auto")
+		color     = colorFlag("false")
+		symBin    string
+		maxSpills int
+		since     string
+		start     string
+		asmPath   string
+		addrFlag  string
+		metaOrder listFlag
+		splitDir  string
 	)
-	fs.StringVar(&outPath, "out", "", "output file path (default: stdout)")
-	fs.BoolVar(&cfg.file, "file", true, "include file name in output")
-	fs.BoolVar(&cfg.instr, "instr", false, "include encoded instructions in output")
-	fs.BoolVar(&cfg.offset, "offset", false, "include offset in output")
-	fs.BoolVar(&cfg.goAsm, "goasm", true, "include Go assembly in output")
+	fs.StringVar(&outPath, "out", "", "output file path, replaced only if fix succeeds (default: stdout)")
+	fs.StringVar(&splitDir, "split-dir", "", "write each symbol to its own `DIR`/LABEL.mca file, named for its label, instead of to the output")
+	fs.StringVar(&asmPath, "asm-out", "", "also write the instructions as assembly for the system assembler to this `FILE`, to check that they reassemble")
+	fs.Var(&symRegs, "s", "only emit symbols matching this regexp (repeatable: symbols matching any of them)")
+	fs.Var(funcFlag{&symRegs}, "func", "only emit the Go function with this name, like crypto/sha256.block or pkg.(*T).M (repeatable, like -s)")
+	fs.Var(&regions, "regions", "wrap each symbol in llvm-mca code region markers: true, false or auto (if there is more than one symbol)")
+	fs.Var(&color, "color", "color the mnemonics, file:line and encodings: true, false or auto (if the output is a terminal and $NO_COLOR is unset)")
+	fs.BoolVar(&cfg.File, "file", true, "include file name in output")
+	fs.BoolVar(&cfg.Instr, "instr", false, "include encoded instructions in output")
+	fs.StringVar(&cfg.InstrSep, "instr-sep", "", "with -instr, separate the bytes of each encoding with this string, like ' ' for \"f9 40 07 e0\"")
+	fs.BoolVar(&cfg.Offset, "offset", false, "include offset in output")
+	fs.BoolVar(&cfg.GoAsm, "goasm", true, "include Go assembly in output")
+	fs.Var(&metaOrder, "meta-order", "comma-separated `COLUMNS` of the comments to include, in order, from file, offset, instr and goasm (overrides -file, -offset, -instr and -goasm)")
+	fs.StringVar(&cfg.MetaSep, "meta-sep", "", "separate the comment columns with this string instead of aligning them, like ' | '")
+	fs.BoolVar(&cfg.MetaLabels, "meta-labels", false, "label the comment columns, like src=main.go:5 and off=0x1000")
+	fs.StringVar(&cfg.Headerless, "headerless", "headerless",
+		"symbol name for input that does not begin with a TEXT line (empty: error)")
+	fs.BoolVar(&cfg.EscapeOff, "escape-off", false, "keep 0xff bytes in the output instead of dropping them")
+	fs.IntVar(&cfg.WrapWidth, "wrap-width", 0, "wrap comments in lines longer than this many columns (0: no wrapping)")
+	fs.BoolVar(&cfg.AlignComments, "align-comments", false, "align each symbol's trailing comments to one column using spaces")
+	fs.StringVar(&cfg.Dialect, "dialect", mca.DialectGNU, "assembly dialect: gnu (as printed by objdump) or llvm (as accepted by llvm-mca)")
+	fs.BoolVar(&cfg.Normalize, "normalize", false, "rewrite PC-relative operands, like %rip displacements and adrp addresses, to a fixed displacement (lossy)")
+	fs.BoolVar(&cfg.SumByFile, "sum-by-file", false, "print the number of instructions and bytes from each source file")
+	fs.BoolVar(&cfg.GroupByFile, "group-by-file", false, "write a header before each run of instructions from the same source file")
+	fs.BoolVar(&cfg.Summary, "summary", false, "print the number of instructions and bytes in each symbol and their total")
+	fs.BoolVar(&cfg.Moves, "moves", false, "report register-to-register moves that look redundant")
+	fs.StringVar(&cfg.ContextSym, "context-symbol", "", "prefix each instruction with its symbol: name or mangled")
+	fs.BoolVar(&cfg.Raw, "raw", false, "separate columns with single tabs instead of aligning them, for diffing")
+	fs.IntVar(&cfg.MinWidth, "minwidth", 18, "minimum width of an aligned column, including padding")
+	fs.IntVar(&cfg.TabWidth, "tabwidth", 8, "width of a tab when aligning columns")
+	fs.IntVar(&cfg.Padding, "padding", 1, "padding added to the width of each aligned column")
+	fs.BoolVar(&cfg.Canonical, "canonical", false, "emit a diff-friendly form with only the GNU assembly and symbol-relative branch targets")
+	fs.IntVar(&maxSpills, "fail-on-spill", -1, "fail if any symbol has more than this many register spills and reloads (negative: no limit)")
+	fs.StringVar(&cfg.Stop, "stop", mca.StopNone, "when to stop emitting each symbol's instructions: none (emit every instruction), ret (at its first return) or a `REGEXP` for the GNU assembly of the instruction to stop at")
+	fs.StringVar(&start, "start", "", "skip each symbol's instructions before the first one whose GNU assembly matches this `REGEXP`")
+	fs.BoolVar(&cfg.KeepStop, "keep-ret", false, "with -stop, also emit the return or other instruction that it stops at")
+	fs.StringVar(&cfg.StopComment, "stop-comment", "stopping at", "text of the comment that replaces the instruction that -stop stops at, before the instruction")
+	fs.BoolVar(&cfg.Quiet, "quiet", false, "omit the comments that mark where -stop and -limit cut a symbol short")
+	fs.Var((*listFlag)(&cfg.StopAt), "stop-at", "comma-separated returns that -stop ret stops at, as mnemonics or instructions (default: the architecture's returns)")
+	fs.IntVar(&cfg.MaxLineLen, "max-line-length", 0, "warn about instructions longer than this many characters (0: no limit)")
+	fs.IntVar(&cfg.Limit, "limit", 0, "only emit the first this many instructions of each symbol (0: no limit)")
+	fs.StringVar(&cfg.OnUnknown, "on-unknown", mca.UnknownComment, "what to do with instructions that could not be decoded: comment, skip or error")
+	fs.BoolVar(&cfg.Strict, "strict", false, "fail on lines that cannot be parsed, and on encodings of implausible length for the architecture, instead of warning")
+	fs.BoolVar(&cfg.Source, "source", false, "keep the Go source lines of go tool objdump -S input as comments")
+	fs.BoolVar(&cfg.Debug, "debug", false, "add each instruction's input line as a comment, and comment the lines that could not be parsed")
+	fs.BoolVar(&cfg.CollapseRet, "collapse-ret", false, "mark each return with its number (implies -stop none)")
+	fs.StringVar(&isa, "isa", mca.ISAAuto, "architecture of the input: amd64, 386, arm64 or auto (accept any of them)")
+	fs.BoolVar(&cfg.Labels, "labels", false, "label branch targets and rewrite branches to use the labels")
+	fs.BoolVar(&cfg.Dedupe, "dedupe", false, "print each symbol's distinct instructions with their counts, most frequent first")
+	fs.StringVar(&since, "since", "", "mark instructions that are not in this baseline `FILE`, an objdump or fix -canonical dump, with a +")
+	fs.StringVar(&symBin, "symbolize-targets", "", "annotate branch and call targets with symbols from this `BINARY`")
+	fs.StringVar(&enc, "encoding", "", "only emit instructions whose leading bytes match `MASK/VALUE`, in hex")
+	fs.StringVar(&addrFlag, "addr", "", "only emit instructions at addresses in `START:END`, in hex with END exclusive")
+	fs.StringVar(&filter, "filter", "", "only emit instructions whose GNU assembly matches this `REGEXP`, like '^v' for AVX")
+	fs.StringVar(&exclude, "exclude", "", "drop instructions whose GNU assembly matches this `REGEXP`")
+	fs.BoolVar(&cfg.DiffJSON, "diff-json", false, "with -since, write the added, removed and changed instructions of each symbol as JSON instead of assembly")
+	fs.BoolVar(&cfg.JSON, "json", false, "write a JSON array of the symbols and their instructions instead of assembly")
+	fs.BoolVar(&cfg.NDJSON, "ndjson", false, "write each instruction as a JSON object on its own line instead of assembly")
+	fs.BoolVar(&cfg.CSV, "csv", false, "write each instruction as a CSV row of symbol, file, line, offset, instr, goasm and gnuasm instead of assembly")
+	fs.BoolVar(&cfg.BytesOnly, "bytes-only", false, "write the encodings of each symbol's instructions in hex, one line per symbol, instead of assembly")
+	fs.StringVar(&cfg.BytesSep, "bytes-sep", "", "with -bytes-only, separate the instructions with this string, like ' ' or a newline")
+	fs.BoolVar(&cfg.Energy, "energy", false, "add each instruction's approximate relative energy cost and each symbol's total")
+	fs.BoolVar(&cfg.Legend, "legend", false, "begin the output with a comment that explains each column")
+	fs.BoolVar(&explain, "explain", false, "describe what fix will do before doing it")
+	fs.BoolVar(&cfg.Verbose, "v", false, "warn about each line that could not be parsed, with the reason")
+	errorsFlag()
+	progressFlag()
 	fs.Parse(args)
+	if err := checkErrorsFlag(); err != nil {
+		return err
+	}
+	path, err := fileArg(path, "")
+	if err != nil {
+		return err
+	}
+	cfg.Warn = warn
 
-	w := io.WriteCloser(nopCloser{Writer: os.Stdout})
-	if outPath != "" {
-		var err error
-		w, err = os.Create(outPath)
-		if err != nil {
-			return err
+	if cfg.Dialect != mca.DialectGNU && cfg.Dialect != mca.DialectLLVM {
+		return useErrf("unknown -dialect %q", cfg.Dialect)
+	}
+	stop, stopMatch, err := parseStop(cfg.Stop)
+	if err != nil {
+		return err
+	}
+	cfg.Stop, cfg.StopMatch = stop, stopMatch
+	if cfg.StartMatch, err = parseStart(start); err != nil {
+		return err
+	}
+	if err := checkOnUnknown(cfg.OnUnknown); err != nil {
+		return err
+	}
+	if countTrue(cfg.JSON, cfg.NDJSON, cfg.CSV, cfg.DiffJSON) > 1 {
+		return useErr("-json, -ndjson, -csv and -diff-json are mutually exclusive")
+	}
+	if (cfg.JSON || cfg.NDJSON || cfg.CSV) && (cfg.Energy || cfg.Dedupe || cfg.SumByFile || cfg.Summary || cfg.Moves || cfg.WrapWidth > 0 || cfg.AlignComments || cfg.ContextSym != "" || cfg.GroupByFile) {
+		return useErr("-json, -ndjson and -csv are mutually exclusive with -energy, -dedupe, -sum-by-file, -summary, -moves, -wrap-width, -align-comments, -context-symbol and -group-by-file")
+	}
+	if cfg.Debug && (cfg.Canonical || cfg.Dedupe || cfg.JSON || cfg.NDJSON || cfg.CSV || cfg.DiffJSON) {
+		return useErr("-debug is mutually exclusive with -canonical, -dedupe, -json, -ndjson, -csv and -diff-json")
+	}
+	if cfg.GroupByFile && cfg.Dedupe {
+		return useErr("-group-by-file and -dedupe are mutually exclusive")
+	}
+	if cfg.DiffJSON && (since == "" || cfg.Legend || cfg.Dedupe) {
+		return useErr("-diff-json requires -since and is mutually exclusive with -legend and -dedupe")
+	}
+	if (cfg.JSON || cfg.NDJSON || cfg.CSV) && cfg.Legend {
+		return useErr("-json, -ndjson and -csv are mutually exclusive with -legend")
+	}
+	if splitDir != "" && (outPath != "" || cfg.JSON || cfg.NDJSON || cfg.CSV || cfg.DiffJSON) {
+		return useErr("-split-dir is mutually exclusive with -out, -json, -ndjson, -csv and -diff-json")
+	}
+	if cfg.BytesOnly && (cfg.JSON || cfg.NDJSON || cfg.CSV || cfg.DiffJSON || cfg.Dedupe || cfg.Legend || splitDir != "" ||
+		cfg.Energy || cfg.SumByFile || cfg.Summary || cfg.Moves) {
+		return useErr("-bytes-only is mutually exclusive with -json, -ndjson, -csv, -diff-json, -dedupe, -legend, -split-dir, -energy, -sum-by-file, -summary and -moves")
+	}
+	if asmPath != "" && asmPath == outPath {
+		return useErr("-asm-out and -out must be different files")
+	}
+	if cfg.MinWidth < 0 || cfg.Padding < 0 {
+		return useErr("-minwidth and -padding must not be negative")
+	}
+	if cfg.TabWidth <= 0 {
+		return useErr("-tabwidth must be positive")
+	}
+	if cfg.Raw && cfg.AlignComments {
+		return useErr("-raw and -align-comments are mutually exclusive")
+	}
+	if cfg.Labels && (cfg.Canonical || cfg.JSON || cfg.NDJSON || cfg.CSV) {
+		return useErr("-labels is mutually exclusive with -canonical, -json, -ndjson and -csv")
+	}
+	if metaOrder != nil {
+		if err := mca.CheckMetaOrder(metaOrder); err != nil {
+			return useErrf("invalid -meta-order: %v", err)
 		}
-		defer w.Close()
+		cfg.File, cfg.Offset, cfg.Instr, cfg.GoAsm = false, false, false, false
+		for _, s := range metaOrder {
+			switch s {
+			case mca.MetaFile:
+				cfg.File = true
+			case mca.MetaOffset:
+				cfg.Offset = true
+			case mca.MetaInstr:
+				cfg.Instr = true
+			case mca.MetaGoAsm:
+				cfg.GoAsm = true
+			}
+		}
+		cfg.MetaOrder = metaOrder
 	}
-
-	r, err := os.Open(path)
+	a, err := parseISA(isa)
 	if err != nil {
 		return err
 	}
-	defer r.Close()
-
-	if err := cfg.fix(w, r); err != nil {
+	cfg.Arch = a
+	regions.apply(&cfg)
+	if splitDir != "" {
+		// Each file has one symbol.
+		cfg.Regions, cfg.AutoRegions = false, false
+	}
+	cfg.Color = color.enabled(outPath == "" && isTerminal(os.Stdout))
+	if len(symRegs) > 0 {
+		cfg.Symbols = regexp.MustCompile(symRegs.join())
+	}
+	switch cfg.ContextSym {
+	case "", "name", "mangled":
+	default:
+		return useErrf("unknown -context-symbol %q", cfg.ContextSym)
+	}
+	if cfg.Addr, err = parseAddr(addrFlag); err != nil {
 		return err
 	}
-	return w.Close()
-}
-
-type fixConfig struct {
-	file   bool
-	offset bool
-	instr  bool
-	goAsm  bool
-}
-
-func (c fixConfig) fix(w io.Writer, r io.Reader) error {
-	tw := tabwriter.NewWriter(w, 18, 8, 1, '\t', tabwriter.StripEscape)
-
-	s := bufio.NewScanner(r)
-	for s.Scan() {
-		t := s.Text()
-		if strings.HasPrefix(t, "TEXT ") {
-			t = strings.TrimPrefix(t, "TEXT ")
-			fmt.Fprintf(tw, "%s\n", mangle(t))
-			continue
+	if enc != "" {
+		f, err := mca.ParseEncodingFilter(enc)
+		if err != nil {
+			return useErrf("invalid -encoding %q: %v", enc, err)
 		}
-		l, err := split(t)
+		cfg.Encoding = f
+	}
+	if filter != "" {
+		re, err := regexp.Compile(filter)
+		if err != nil {
+			return useErrf("invalid -filter %q: %v", filter, err)
+		}
+		cfg.Filter = re
+	}
+	if exclude != "" {
+		re, err := regexp.Compile(exclude)
+		if err != nil {
+			return useErrf("invalid -exclude %q: %v", exclude, err)
+		}
+		cfg.Exclude = re
+	}
+	if maxSpills >= 0 {
+		cfg.CheckSpills, cfg.MaxSpills = true, maxSpills
+	}
+	if since != "" {
+		b, err := mca.ReadBaseline(since, cfg.Arch)
 		if err != nil {
 			return err
 		}
-		if l.gnuAsm == "ret" {
-			fmt.Fprintf(tw, "\t// stopping at %s\n", l.gnuAsm)
-			break
+		cfg.Since = b
+	}
+	if symBin != "" {
+		tab, err := mca.ReadSymtab(symBin)
+		if err != nil {
+			return err
 		}
-		fmt.Fprintf(tw, "  %s", l.gnuAsm)
-		if c.file || c.offset || c.instr || c.goAsm {
-			slash := false
-			printf := func(format string, args ...interface{}) {
-				if !slash {
-					format = "// " + format
-					slash = true
-				}
-				fmt.Fprintf(tw, "\t"+format, args...)
-			}
-			if c.file {
-				printf("%s:%d", l.file, l.line)
-			}
-			if c.offset {
-				printf("%#x", l.offset)
-			}
-			if c.instr {
-				printf("%x", l.instr)
+		cfg.Symtab = tab
+	}
+	if explain {
+		explainFix(os.Stderr, path, outPath, splitDir, cfg)
+	}
+
+	w := io.WriteCloser(nopCloser{Writer: os.Stdout})
+	if outPath != "" {
+		f, err := createOut(outPath)
+		if err != nil {
+			return err
+		}
+		defer f.abort()
+		w = f
+	}
+	var asmOut *outFile
+	if asmPath != "" {
+		f, err := createOut(asmPath)
+		if err != nil {
+			return err
+		}
+		defer f.abort()
+		asmOut, cfg.AsmOut = f, f
+	}
+	if splitDir != "" {
+		// cur is the file of the current symbol, which is
+		// removed if fix fails before closing it.
+		var cur *outFile
+		defer func() {
+			if cur != nil {
+				cur.abort()
 			}
-			if c.goAsm {
-				printf("%s", l.goAsm)
+		}()
+		cfg.Split = func(name string) (io.WriteCloser, error) {
+			f, err := createOut(filepath.Join(splitDir, name+".mca"))
+			if err != nil {
+				return nil, err
 			}
+			cur = f
+			return f, nil
 		}
-		fmt.Fprint(tw, "\n")
 	}
-	if err := s.Err(); err != nil {
+
+	r := io.Reader(os.Stdin)
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	r, err = gunzip(r)
+	if err != nil {
 		return err
 	}
-	return tw.Flush()
-}
 
-// line is one line of output from "go tool objdump".
-//
-// It matches
-//
-//    blake2b_arm64.s:334	0xfbf40			f94007e0		MOVD 8(RSP), R0                      // ldr x0, [sp,#8]
-//    blake2b_arm64.s:335	0xfbf44			f94013e1		MOVD 32(RSP), R1                     // ldr x1, [sp,#32]
-//
-type line struct {
-	file   string
-	line   int
-	offset int
-	instr  []byte
-	goAsm  string
-	gnuAsm string
+	var endProgress func()
+	cfg.Progress, endProgress = progress()
+	err = cfg.Fix(w, r)
+	endProgress()
+	if err != nil {
+		return err
+	}
+	if asmOut != nil {
+		if err := asmOut.Close(); err != nil {
+			return err
+		}
+	}
+	return w.Close()
 }
 
-func split(s string) (line, error) {
-	orig := s
-	s = strings.TrimSpace(s)
-
-	i := strings.IndexByte(s, ':')
-	if i < 0 {
-		return line{}, syntaxErr("missing colon in file name", orig)
+// gunzip returns r, decompressed if it begins with the gzip
+// magic number.
+func gunzip(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err != nil || string(magic) != "\x1f\x8b" {
+		return br, nil
 	}
-	file, s := s[:i], s[i+1:]
+	return gzip.NewReader(br)
+}
 
-	num, s, err := readInt(s)
+// parseStop parses a -stop flag: StopNone, StopRet, or a regexp
+// for Config.StopMatch, for which it returns StopNone.
+func parseStop(s string) (string, *regexp.Regexp, error) {
+	switch s {
+	case mca.StopNone, mca.StopRet:
+		return s, nil, nil
+	}
+	re, err := regexp.Compile(s)
 	if err != nil {
-		return line{}, err
+		return "", nil, useErrf("invalid -stop %q: %v", s, err)
 	}
+	return mca.StopNone, re, nil
+}
 
-	s = strings.TrimSpace(s)
-	if !strings.HasPrefix(s, "0x") {
-		return line{}, syntaxErr("missing 0x prefix for offset", orig)
+// parseStart parses a -start flag, which may be empty.
+func parseStart(s string) (*regexp.Regexp, error) {
+	if s == "" {
+		return nil, nil
 	}
-	s = strings.TrimPrefix(s, "0x")
-	off, s, err := readHexInt(s)
+	re, err := regexp.Compile(s)
 	if err != nil {
-		return line{}, err
+		return nil, useErrf("invalid -start %q: %v", s, err)
 	}
+	return re, nil
+}
 
-	s = strings.TrimSpace(s)
-	instr, s, err := readHex(s)
+// parseAddr parses an -addr flag, which may be empty.
+func parseAddr(s string) (*mca.AddrRange, error) {
+	if s == "" {
+		return nil, nil
+	}
+	r, err := mca.ParseAddrRange(s)
 	if err != nil {
-		return line{}, err
+		return nil, useErrf("invalid -addr %q: %v", s, err)
 	}
+	return r, nil
+}
 
-	s = strings.TrimSpace(s)
-	i = strings.Index(s, "// ")
-	if i < 0 {
-		return line{}, syntaxErr("missing GNU assembly comments", orig)
+// checkOnUnknown checks the value of an -on-unknown flag.
+func checkOnUnknown(s string) error {
+	switch s {
+	case mca.UnknownComment, mca.UnknownSkip, mca.UnknownError:
+		return nil
 	}
-	goAsm := strings.TrimSpace(s[:i])
-	gnuAsm := strings.TrimSpace(s[i+len("// "):])
+	return useErrf("unknown -on-unknown %q", s)
+}
 
-	return line{
-		file:   file,
-		line:   num,
-		offset: off,
-		instr:  instr,
-		goAsm:  goAsm,
-		gnuAsm: gnuAsm,
-	}, nil
+// regexpsFlag is a repeatable flag of regexps.
+type regexpsFlag []string
+
+func (f *regexpsFlag) String() string {
+	return strings.Join(*f, " ")
 }
 
-func readInt(s string) (int, string, error) {
-	i := 0
-	for i < len(s) {
-		c := s[i]
-		if c < '0' || c > '9' {
-			break
-		}
-		i++
-	}
-	x, err := strconv.Atoi(s[:i])
-	if err != nil {
-		return 0, "", err
+func (f *regexpsFlag) Set(s string) error {
+	if _, err := regexp.Compile(s); err != nil {
+		return err
 	}
-	return x, s[i:], nil
+	*f = append(*f, s)
+	return nil
+}
+
+// funcFlag is a repeatable -func flag of Go function names,
+// which adds the regexps for their symbols to a regexpsFlag.
+type funcFlag struct {
+	regs *regexpsFlag
+}
+
+func (f funcFlag) String() string {
+	return ""
 }
 
-func readHexInt(s string) (int, string, error) {
-	i := 0
-	for i < len(s) && isHex(s[i]) {
-		i++
+func (f funcFlag) Set(s string) error {
+	return f.regs.Set(mca.FuncRegexp(s))
+}
+
+// join returns a regexp that matches anything that any of the
+// regexps match, or "" if there are none.
+//
+// A symbol that matches more than one of them is still only
+// matched once, so symbols are dumped once each in the order
+// that they appear in the binary.
+func (f regexpsFlag) join() string {
+	if len(f) == 1 {
+		return f[0]
 	}
-	x, err := strconv.ParseInt(s[:i], 16, bits.UintSize)
-	if err != nil {
-		return 0, "", err
+	var groups []string
+	for _, s := range f {
+		groups = append(groups, "(?:"+s+")")
+	}
+	return strings.Join(groups, "|")
+}
+
+// listFlag is a comma-separated list flag.
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(s string) error {
+	*f = nil
+	for _, x := range strings.Split(s, ",") {
+		if x = mca.AsmKey(x); x != "" {
+			*f = append(*f, x)
+		}
 	}
-	return int(x), s[i:], nil
+	return nil
 }
 
-func readHex(s string) ([]byte, string, error) {
-	i := 0
-	for i < len(s) && isHex(s[i]) {
-		i++
+// regionsFlag is a -regions flag: "true", "false" or "auto".
+type regionsFlag string
+
+func (f *regionsFlag) String() string {
+	return string(*f)
+}
+
+func (f *regionsFlag) Set(s string) error {
+	if s == "auto" {
+		*f = regionsFlag(s)
+		return nil
 	}
-	buf, err := hex.DecodeString(s[:i])
+	b, err := strconv.ParseBool(s)
 	if err != nil {
-		return nil, "", err
+		return errors.New("must be true, false or auto")
 	}
-	return buf, s[i:], nil
+	*f = regionsFlag(strconv.FormatBool(b))
+	return nil
 }
 
-func isHex(c byte) bool {
-	switch {
-	case '0' <= c && c <= '9':
-		return true
-	case 'a' <= c && c <= 'f':
-		return true
-	case 'A' <= c && c <= 'F':
-		return true
-	default:
-		return false
+func (f *regionsFlag) IsBoolFlag() bool {
+	return true
+}
+
+// apply sets cfg's regions or autoRegions.
+func (f regionsFlag) apply(cfg *mca.Config) {
+	switch f {
+	case "true":
+		cfg.Regions = true
+	case "auto":
+		cfg.AutoRegions = true
 	}
 }
 
-func syntaxErr(s, line string) error {
-	return fmt.Errorf("syntax error: %s (%s)", s, line)
+// colorFlag is a -color flag: "true", "false" or "auto".
+type colorFlag string
+
+func (f *colorFlag) String() string {
+	return string(*f)
 }
 
-var repl = strings.NewReplacer(
-	"(", "_",
-	")", "_",
-	"*", "_",
-	"[", "_",
-	"]", "_",
-	"/", "_",
-	" ", "_",
-	".", "_",
-)
+func (f *colorFlag) Set(s string) error {
+	if s == "auto" {
+		*f = colorFlag(s)
+		return nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*f = colorFlag(strconv.FormatBool(b))
+	return nil
+}
+
+func (f *colorFlag) IsBoolFlag() bool {
+	return true
+}
+
+// enabled reports whether to color output that is written to a
+// terminal if tty is set.
+func (f colorFlag) enabled(tty bool) bool {
+	switch f {
+	case "true":
+		return true
+	case "auto":
+		return tty && os.Getenv("NO_COLOR") == ""
+	}
+	return false
+}
 
-func mangle(s string) string {
-	return repl.Replace(s) + ":"
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
 }
 
 type nopCloser struct {
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mcaRegion is the report for one code region in llvm-mca's
+// output.
+type mcaRegion struct {
+	// name is the region's name, or empty if the input did not
+	// have any named regions.
+	name string
+	// text is the region's report, including its header.
+	text []byte
+}
+
+var regionHeader = regexp.MustCompile(`^\[\d+\] Code Region(?: - (.*))?$`)
+
+// splitRegions splits llvm-mca's output into one report per code
+// region.
+//
+// Output without region headers is a single unnamed region.
+func splitRegions(out []byte) []mcaRegion {
+	var regions []mcaRegion
+	start := 0
+	name := ""
+	for off := 0; off < len(out); {
+		end := bytes.IndexByte(out[off:], '\n')
+		if end < 0 {
+			end = len(out)
+		} else {
+			end += off + 1
+		}
+		if m := regionHeader.FindSubmatch(bytes.TrimRight(out[off:end], "\r\n")); m != nil {
+			if len(bytes.TrimSpace(out[start:off])) > 0 {
+				regions = append(regions, mcaRegion{name: name, text: out[start:off]})
+			}
+			start, name = off, string(m[1])
+		}
+		off = end
+	}
+	if len(bytes.TrimSpace(out[start:])) > 0 {
+		regions = append(regions, mcaRegion{name: name, text: out[start:]})
+	}
+	return regions
+}
+
+// bottleneck is the primary bottleneck that llvm-mca's
+// -bottleneck-analysis view reports for a code region.
+type bottleneck struct {
+	// kind is "resource pressure", "register dependencies",
+	// "memory dependencies", "none" or "unknown".
+	kind string
+	// resource is the most contended resource when kind is
+	// "resource pressure".
+	resource string
+	// pct is the percentage of cycles with backend pressure
+	// increases caused by the bottleneck.
+	pct float64
+}
+
+func (b bottleneck) String() string {
+	if b.resource != "" {
+		return b.kind + ": " + b.resource
+	}
+	return b.kind
+}
+
+var bottleneckLine = regexp.MustCompile(`^\s*(-\s+)?(.*?):?\s*\[\s*([0-9.]+)%\s*\]$`)
+
+// parseBottleneck parses the bottleneck analysis view in
+// a region's report.
+//
+// The view looks like
+//
+//	Throughput Bottlenecks:
+//	  Resource Pressure       [ 84.20% ]
+//	  - SKLPort1  [ 84.20% ]
+//	  - SKLPort5  [ 13.33% ]
+//	  Data Dependencies:      [ 0.00% ]
+//	  - Register Dependencies [ 0.00% ]
+//	  - Memory Dependencies   [ 0.00% ]
+func parseBottleneck(text []byte) bottleneck {
+	var (
+		b       = bottleneck{kind: "unknown"}
+		found   bool
+		section string
+		port    string
+		portPct float64
+		kinds   = make(map[string]float64)
+	)
+	s := bufio.NewScanner(bytes.NewReader(text))
+	for s.Scan() {
+		t := s.Text()
+		if strings.HasPrefix(t, "No resource or data dependency bottlenecks") {
+			return bottleneck{kind: "none"}
+		}
+		if strings.HasPrefix(t, "Throughput Bottlenecks:") {
+			found = true
+			continue
+		}
+		if !found {
+			continue
+		}
+		m := bottleneckLine.FindStringSubmatch(t)
+		if m == nil {
+			break
+		}
+		pct, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			break
+		}
+		name, sub := m[2], m[1] != ""
+		switch {
+		case !sub:
+			section = name
+			if name == "Resource Pressure" {
+				kinds["resource pressure"] = pct
+			}
+		case section == "Resource Pressure":
+			if pct > portPct {
+				port, portPct = name, pct
+			}
+		case name == "Register Dependencies":
+			kinds["register dependencies"] = pct
+		case name == "Memory Dependencies":
+			kinds["memory dependencies"] = pct
+		}
+	}
+	if !found {
+		return b
+	}
+	b.kind = "none"
+	for _, k := range []string{"resource pressure", "register dependencies", "memory dependencies"} {
+		if pct := kinds[k]; pct > b.pct {
+			b.kind, b.pct = k, pct
+		}
+	}
+	if b.kind == "resource pressure" {
+		b.resource = port
+	}
+	return b
+}
+
+// writeByBottleneck writes each region's report grouped by its
+// primary bottleneck.
+func writeByBottleneck(w io.Writer, regions []mcaRegion) error {
+	groups := make(map[string][]mcaRegion)
+	var keys []string
+	for _, r := range regions {
+		k := parseBottleneck(r.text).String()
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], r)
+	}
+	sort.Strings(keys)
+
+	bw := bufio.NewWriter(w)
+	for i, k := range keys {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintf(bw, "==== %s (%d) ====\n", k, len(groups[k]))
+		for _, r := range groups[k] {
+			fmt.Fprintf(bw, "%s\n", bytes.TrimRight(r.text, "\n"))
+		}
+	}
+	return bw.Flush()
+}
+
+// markPressure marks the rows in each "Resource pressure by
+// instruction" table in llvm-mca's output whose pressure on any
+// resource exceeds threshold cycles per iteration, listing the
+// contended resources after the instruction:
+//
+//	1.00    -      -     1.00   movq	%rax, 8(%rsp)  <== [0]=1.00, [3]=1.00
+func markPressure(out []byte, threshold float64) []byte {
+	var b bytes.Buffer
+	// col is the column at which the instructions start in the
+	// current table, or -1 before its column header.
+	col := -1
+	inTable := false
+	for len(out) > 0 {
+		i := bytes.IndexByte(out, '\n')
+		if i < 0 {
+			i = len(out) - 1
+		}
+		l := out[:i+1]
+		out = out[i+1:]
+		t := string(bytes.TrimRight(l, "\r\n"))
+		switch {
+		case t == "Resource pressure by instruction:":
+			inTable, col = true, -1
+		case inTable && col < 0:
+			col = strings.Index(t, "Instructions:")
+			if col < 0 {
+				inTable = false
+			}
+		case inTable && strings.TrimSpace(t) == "":
+			inTable = false
+		case inTable && len(t) > col:
+			var hot []string
+			for j, f := range strings.Fields(t[:col]) {
+				x, err := strconv.ParseFloat(f, 64)
+				if err == nil && x > threshold {
+					hot = append(hot, fmt.Sprintf("[%d]=%s", j, f))
+				}
+			}
+			if len(hot) > 0 {
+				fmt.Fprintf(&b, "%s  <== %s\n", t, strings.Join(hot, ", "))
+				continue
+			}
+		}
+		b.Write(l)
+	}
+	return b.Bytes()
+}
+
+// totalCycles returns the sum of the "Total Cycles" that
+// llvm-mca reports for each code region.
+func totalCycles(out []byte) (int, bool) {
+	n, found := 0, false
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		t := s.Text()
+		if !strings.HasPrefix(t, "Total Cycles:") {
+			continue
+		}
+		x, err := strconv.Atoi(strings.TrimSpace(t[len("Total Cycles:"):]))
+		if err != nil {
+			return 0, false
+		}
+		n += x
+		found = true
+	}
+	return n, found
+}
+
+// minCycles returns the regions for which llvm-mca reports at
+// least min total cycles.
+//
+// The number of omitted regions is printed to stderr so that an
+// empty report is not mistaken for a failure.
+func minCycles(regions []mcaRegion, min int) []mcaRegion {
+	var kept []mcaRegion
+	for _, r := range regions {
+		if n, ok := totalCycles(r.text); ok && n < min {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	if n := len(regions) - len(kept); n > 0 {
+		fmt.Fprintf(os.Stderr, "%s: omitted %d of %d symbols below %d total cycles\n",
+			os.Args[0], n, len(regions), min)
+	}
+	return kept
+}
+
+// pressureTable is the "Resource pressure by instruction" view
+// in a region's report.
+type pressureTable struct {
+	// resources are the names of the table's columns, like
+	// "SKLPort0", or their indices, like "[2]", if the report
+	// did not name them.
+	resources []string
+	rows      []pressureRow
+}
+
+// pressureRow is one instruction in a pressureTable.
+type pressureRow struct {
+	insn string
+	// pressure is the instruction's pressure on each resource,
+	// in cycles per iteration.
+	pressure []float64
+}
+
+var resourceLine = regexp.MustCompile(`^(\[[0-9.]+\])\s+-\s+(\S+)`)
+
+// parsePressure parses the resource pressure by instruction view
+// in a region's report.
+func parsePressure(text []byte) (pressureTable, bool) {
+	var (
+		t       pressureTable
+		names   = make(map[string]string)
+		found   bool
+		col     = -1
+		section string
+	)
+	s := bufio.NewScanner(bytes.NewReader(text))
+	for s.Scan() {
+		l := strings.TrimRight(s.Text(), "\r")
+		if strings.HasSuffix(l, ":") && !strings.HasPrefix(l, " ") && !strings.HasPrefix(l, "[") {
+			section = l
+			continue
+		}
+		switch section {
+		case "Resources:":
+			if m := resourceLine.FindStringSubmatch(l); m != nil {
+				names[m[1]] = m[2]
+			}
+		case "Resource pressure by instruction:":
+			if col < 0 {
+				col = strings.Index(l, "Instructions:")
+				if col < 0 {
+					return t, false
+				}
+				for _, h := range strings.Fields(l[:col]) {
+					if n, ok := names[h]; ok {
+						h = n
+					}
+					t.resources = append(t.resources, h)
+				}
+				found = true
+				continue
+			}
+			if strings.TrimSpace(l) == "" {
+				section = ""
+				continue
+			}
+			if len(l) <= col {
+				continue
+			}
+			r := pressureRow{insn: strings.Join(strings.Fields(l[col:]), " ")}
+			for _, f := range strings.Fields(l[:col]) {
+				x, _ := strconv.ParseFloat(f, 64) // "-" is zero
+				r.pressure = append(r.pressure, x)
+			}
+			t.rows = append(t.rows, r)
+		}
+	}
+	return t, found
+}
+
+// mcaSummary is the summary at the start of a region's report.
+type mcaSummary struct {
+	iterations  int
+	cycles      int
+	ipc         float64
+	rthroughput float64
+}
+
+// parseSummary parses the summary in a region's report, which
+// looks like
+//
+//	Iterations:        100
+//	Instructions:      1500
+//	Total Cycles:      363
+//	Total uOps:        1900
+//
+//	Dispatch Width:    6
+//	uOps Per Cycle:    5.23
+//	IPC:               4.13
+//	Block RThroughput: 3.2
+func parseSummary(text []byte) (mcaSummary, bool) {
+	var (
+		sum   mcaSummary
+		found int
+	)
+	s := bufio.NewScanner(bytes.NewReader(text))
+	for s.Scan() && found < 4 {
+		i := strings.IndexByte(s.Text(), ':')
+		if i < 0 {
+			continue
+		}
+		key, val := s.Text()[:i], strings.TrimSpace(s.Text()[i+1:])
+		var err error
+		switch key {
+		case "Iterations":
+			sum.iterations, err = strconv.Atoi(val)
+		case "Total Cycles":
+			sum.cycles, err = strconv.Atoi(val)
+		case "IPC":
+			sum.ipc, err = strconv.ParseFloat(val, 64)
+		case "Block RThroughput":
+			sum.rthroughput, err = strconv.ParseFloat(val, 64)
+		default:
+			continue
+		}
+		if err != nil {
+			return sum, false
+		}
+		found++
+	}
+	return sum, found == 4
+}
+
+// busiest returns the resource with the most pressure in t and
+// its pressure in cycles per iteration.
+func (t pressureTable) busiest() (string, float64, bool) {
+	totals := make([]float64, len(t.resources))
+	for _, r := range t.rows {
+		for i, x := range r.pressure {
+			if i < len(totals) {
+				totals[i] += x
+			}
+		}
+	}
+	best := -1
+	for i, x := range totals {
+		if x > 0 && (best < 0 || x > totals[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", 0, false
+	}
+	return t.resources[best], totals[best], true
+}
+
+// writeBrief writes one line per region with its summary and
+// its busiest resource:
+//
+//	main_f: 100 iterations, 363 cycles, IPC 4.13, block RThroughput 3.2, busiest SKXPort6 (3.49 cycles/iteration)
+func writeBrief(w io.Writer, regions []mcaRegion) error {
+	bw := bufio.NewWriter(w)
+	for _, r := range regions {
+		if r.name != "" {
+			fmt.Fprintf(bw, "%s: ", r.name)
+		}
+		sum, ok := parseSummary(r.text)
+		if !ok {
+			fmt.Fprintln(bw, "no summary")
+			continue
+		}
+		fmt.Fprintf(bw, "%d iterations, %d cycles, IPC %.2f, block RThroughput %.1f",
+			sum.iterations, sum.cycles, sum.ipc, sum.rthroughput)
+		if t, ok := parsePressure(r.text); ok {
+			if res, x, ok := t.busiest(); ok {
+				fmt.Fprintf(bw, ", busiest %s (%.2f cycles/iteration)", res, x)
+			}
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// outFile is an output file that is written to a temporary file
+// and renamed over its path when closed, so that output that
+// failed partway through never replaces a good file.
+type outFile struct {
+	*os.File
+	path string
+	done bool
+}
+
+// createOut creates the parent directories of path and a
+// temporary file next to it.
+func createOut(path string) (*outFile, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	// TempFile creates the file readable only by its owner.
+	// Keep the mode of the file being replaced, if any.
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &outFile{File: f, path: path}, nil
+}
+
+// Close closes the temporary file and renames it to the output
+// path.
+func (f *outFile) Close() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// abort removes the temporary file, unless f was closed.
+func (f *outFile) abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.File.Close()
+	os.Remove(f.Name())
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+	"golang.org/x/sync/errgroup"
+)
+
+// runPerSymbol analyzes each symbol in bin that matches symReg
+// with its own llvm-mca process, running up to jobs at once, and
+// writes the reports to w under the symbols' names, in the order
+// that they were disassembled.
+//
+// If threshold is positive, each report's contended instructions
+// are marked as by markPressure.
+func runPerSymbol(w io.Writer, bin, symReg string, jobs int, cfg mca.Config, threshold float64, mcaArgs []string) error {
+	out, err := objdump(bin, symReg)
+	if err != nil {
+		return err
+	}
+	blocks := splitText(out)
+	if len(blocks) == 0 {
+		return fmt.Errorf("no symbols in %s match %s", bin, symReg)
+	}
+
+	reports := make([][]byte, len(blocks))
+	sem := make(chan struct{}, jobs)
+	var grp errgroup.Group
+	for i, b := range blocks {
+		i, b := i, b
+		sem <- struct{}{}
+		grp.Go(func() error {
+			defer func() { <-sem }()
+			report, err := runMCA(b.text, cfg, mcaArgs)
+			if err != nil {
+				return fmt.Errorf("%s: %w", b.name, err)
+			}
+			reports[i] = report
+			return nil
+		})
+	}
+	if err := grp.Wait(); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for i, b := range blocks {
+		report := reports[i]
+		if threshold > 0 {
+			report = markPressure(report, threshold)
+		}
+		fmt.Fprintf(bw, "==== %s ====\n%s\n\n", b.name, bytes.TrimRight(report, "\n"))
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// showProgress is set by -progress.
+var showProgress bool
+
+// progressFlag adds the -progress flag to fs.
+func progressFlag() {
+	fs.BoolVar(&showProgress, "progress", false, "show a running count of the symbols and instructions read on stderr, if it is a terminal")
+}
+
+// progressInterval is how often -progress updates its line.
+const progressInterval = 100 * time.Millisecond
+
+// progress returns the Config.Progress function for -progress,
+// which is nil unless it is set and stderr is a terminal, and a
+// function that clears its line once the input has been read.
+//
+// The count is a single line that is rewritten in place, so it
+// does not mix with the output on stdout.
+func progress() (func(symbols, insns int), func()) {
+	if !showProgress || !isTerminal(os.Stderr) {
+		return nil, func() {}
+	}
+	var last time.Time
+	report := func(symbols, insns int) {
+		now := time.Now()
+		if now.Sub(last) < progressInterval {
+			return
+		}
+		last = now
+		fmt.Fprintf(os.Stderr, "\r%s: %d symbols, %d instructions", os.Args[0], symbols, insns)
+	}
+	done := func() {
+		if !last.IsZero() {
+			fmt.Fprint(os.Stderr, "\r\x1b[K")
+		}
+	}
+	return report, done
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// sessionVersion is the version of the session archive format.
+//
+// A session archive is a zip file containing
+//
+//	manifest.json	the sessionManifest
+//	objdump.txt	the "go tool objdump -gnu" output
+//	input.s		the fixed assembly fed to llvm-mca
+//	mca.txt		llvm-mca's unprocessed output
+//
+// Readers reject archives with a different version.
+const sessionVersion = 1
+
+// Names of the files in a session archive.
+const (
+	sessionManifestFile = "manifest.json"
+	sessionObjdumpFile  = "objdump.txt"
+	sessionInputFile    = "input.s"
+	sessionMCAFile      = "mca.txt"
+)
+
+// sessionManifest describes a saved run session.
+type sessionManifest struct {
+	Version int `json:"version"`
+	// Created is when the session was saved.
+	Created time.Time `json:"created"`
+	// Binary is the path to the analyzed binary.
+	Binary string `json:"binary"`
+	// SHA256 is the hex-encoded SHA-256 hash of the binary.
+	SHA256 string `json:"sha256"`
+	// Args are the arguments to the run command, including the
+	// llvm-mca arguments.
+	Args []string `json:"args"`
+	// Report describes how llvm-mca's output was post-processed.
+	Report reportOptions `json:"report"`
+}
+
+// reportOptions describe how runCmd post-processes llvm-mca's
+// output.
+type reportOptions struct {
+	ByBottleneck bool    `json:"by_bottleneck,omitempty"`
+	Deps         bool    `json:"deps,omitempty"`
+	Threshold    float64 `json:"threshold_port,omitempty"`
+	MinCycles    int     `json:"min_cycles,omitempty"`
+	Brief        bool    `json:"brief,omitempty"`
+}
+
+// write writes the report for llvm-mca's output to w.
+func (o reportOptions) write(w io.Writer, out []byte) error {
+	if o.Threshold > 0 {
+		out = markPressure(out, o.Threshold)
+	}
+	regions := splitRegions(out)
+	if o.MinCycles > 0 {
+		regions = minCycles(regions, o.MinCycles)
+	}
+	if o.ByBottleneck {
+		return writeByBottleneck(w, regions)
+	}
+	if o.Deps {
+		return writeDeps(w, regions)
+	}
+	if o.Brief {
+		return writeBrief(w, regions)
+	}
+	if o.MinCycles > 0 {
+		bw := bufio.NewWriter(w)
+		for _, r := range regions {
+			bw.Write(r.text)
+		}
+		return bw.Flush()
+	}
+	_, err := w.Write(out)
+	return err
+}
+
+// session is a saved run session.
+type session struct {
+	manifest sessionManifest
+	objdump  []byte
+	input    []byte
+	mca      []byte
+}
+
+// hashFile returns the hex-encoded SHA-256 hash of the file at
+// path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// saveSession writes s as a session archive to path.
+func saveSession(path string, s session) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	s.manifest.Version = sessionVersion
+	m, err := json.MarshalIndent(s.manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+	for _, e := range []struct {
+		name string
+		data []byte
+	}{
+		{sessionManifestFile, append(m, '\n')},
+		{sessionObjdumpFile, s.objdump},
+		{sessionInputFile, s.input},
+		{sessionMCAFile, s.mca},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     e.name,
+			Method:   zip.Deflate,
+			Modified: s.manifest.Created,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(e.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// readSession reads the session archive at path.
+func readSession(path string) (*session, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, f.Name, err)
+		}
+		files[f.Name] = data
+	}
+	for _, name := range []string{sessionManifestFile, sessionObjdumpFile, sessionInputFile, sessionMCAFile} {
+		if _, ok := files[name]; !ok {
+			return nil, fmt.Errorf("%s: missing %s", path, name)
+		}
+	}
+	s := &session{
+		objdump: files[sessionObjdumpFile],
+		input:   files[sessionInputFile],
+		mca:     files[sessionMCAFile],
+	}
+	if err := json.Unmarshal(files[sessionManifestFile], &s.manifest); err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", path, sessionManifestFile, err)
+	}
+	if v := s.manifest.Version; v != sessionVersion {
+		return nil, fmt.Errorf("%s: unsupported session version %d", path, v)
+	}
+	return s, nil
+}
+
+func replayCmd(args []string) error {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s replay [options] FILE\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	var (
+		show   string
+		verify bool
+	)
+	fs.StringVar(&show, "show", "report", "what to print: report, manifest, objdump, input or mca")
+	fs.BoolVar(&verify, "verify", false, "fail if the binary no longer matches the saved hash")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return useErr("missing session file")
+	}
+	s, err := readSession(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if verify {
+		sum, err := hashFile(s.manifest.Binary)
+		if err != nil {
+			return err
+		}
+		if sum != s.manifest.SHA256 {
+			return fmt.Errorf("%s: SHA-256 is %s, but the session was saved with %s",
+				s.manifest.Binary, sum, s.manifest.SHA256)
+		}
+	}
+	var data []byte
+	switch show {
+	case "report":
+		return s.manifest.Report.write(os.Stdout, s.mca)
+	case "manifest":
+		data, err = json.MarshalIndent(s.manifest, "", "\t")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	case "objdump":
+		data = s.objdump
+	case "input":
+		data = s.input
+	case "mca":
+		data = s.mca
+	default:
+		return useErrf("unknown -show %q", show)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
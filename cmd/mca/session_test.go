@@ -0,0 +1,167 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.zip")
+	want := session{
+		manifest: sessionManifest{
+			Created: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+			Binary:  "bin/app",
+			SHA256:  "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+			Args:    []string{"-s", `^main\.f$`, "--", "-mcpu=skylake"},
+			Report:  reportOptions{Deps: true, MinCycles: 10},
+		},
+		objdump: []byte("TEXT main.f(SB) main.go\n"),
+		input:   []byte("main_f:\n  retq\n"),
+		mca:     []byte("Iterations:        100\n"),
+	}
+	if err := saveSession(path, want); err != nil {
+		t.Fatal(err)
+	}
+
+	// The files are in the documented order.
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	zr.Close()
+	if want := []string{"manifest.json", "objdump.txt", "input.s", "mca.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got files %q, want %q", names, want)
+	}
+
+	got, err := readSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.manifest.Version = sessionVersion
+	if !got.manifest.Created.Equal(want.manifest.Created) {
+		t.Errorf("created %v, want %v", got.manifest.Created, want.manifest.Created)
+	}
+	got.manifest.Created = want.manifest.Created
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("got %+v, want %+v", *got, want)
+	}
+}
+
+// writeZip writes an archive of files, pairs of name and
+// contents, to a new file and returns its path.
+func writeZip(t *testing.T, files ...[2]string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f[1]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "session.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadSessionErrors(t *testing.T) {
+	var (
+		objdump = [2]string{"objdump.txt", ""}
+		input   = [2]string{"input.s", ""}
+		mcaOut  = [2]string{"mca.txt", ""}
+	)
+	notZip := filepath.Join(t.TempDir(), "session.txt")
+	if err := os.WriteFile(notZip, []byte("TEXT main.f(SB)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		path string
+		err  string
+	}{
+		{"not a zip", notZip, "zip"},
+		{
+			"missing mca.txt",
+			writeZip(t, [2]string{"manifest.json", `{"version": 1}`}, objdump, input),
+			"missing mca.txt",
+		},
+		{
+			"missing manifest",
+			writeZip(t, objdump, input, mcaOut),
+			"missing manifest.json",
+		},
+		{
+			"version",
+			writeZip(t, [2]string{"manifest.json", `{"version": 2}`}, objdump, input, mcaOut),
+			"unsupported session version 2",
+		},
+		{
+			"manifest",
+			writeZip(t, [2]string{"manifest.json", `{"version": "1"}`}, objdump, input, mcaOut),
+			"manifest.json: json: cannot unmarshal",
+		},
+	} {
+		_, err := readSession(tc.path)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got error %v, want %q", tc.name, err, tc.err)
+		}
+	}
+
+	// Files that a later version might add are ignored.
+	path := writeZip(t, [2]string{"manifest.json", `{"version": 1}`}, objdump, input, mcaOut, [2]string{"extra.txt", ""})
+	if _, err := readSession(path); err != nil {
+		t.Errorf("extra file: %v", err)
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app")
+	if err := os.WriteFile(path, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := hashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestReplayReport(t *testing.T) {
+	report := depsReport(t)
+	for _, tc := range []struct {
+		name string
+		o    reportOptions
+		// want is part of the replayed report.
+		want string
+	}{
+		{"raw", reportOptions{}, string(report)},
+		{"deps", reportOptions{Deps: true}, "Longest dependency chain (*): [4] -> [5] -> [6] -> [10]"},
+		{"min cycles", reportOptions{MinCycles: 2000}, ""},
+	} {
+		var buf bytes.Buffer
+		if err := tc.o.write(&buf, report); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := buf.String(); !strings.Contains(got, tc.want) || (tc.want == "" && got != "") {
+			t.Errorf("%s: got\n%s\nwant %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// SVG layout, in pixels.
+const (
+	svgCellWidth  = 80
+	svgCellHeight = 18
+	svgCharWidth  = 7
+	svgMargin     = 8
+)
+
+// writeSVG renders the resource pressure by instruction view of
+// each region as a heatmap, one grid per region, with a row per
+// instruction and a column per resource.
+func writeSVG(w io.Writer, regions []mcaRegion) error {
+	type grid struct {
+		name  string
+		table pressureTable
+	}
+	var (
+		grids  []grid
+		labelW int
+		cols   int
+		height = svgMargin
+		peak   float64
+	)
+	for _, r := range regions {
+		t, ok := parsePressure(r.text)
+		if !ok {
+			continue
+		}
+		grids = append(grids, grid{name: r.name, table: t})
+		if len(t.resources) > cols {
+			cols = len(t.resources)
+		}
+		for _, row := range t.rows {
+			if n := len(row.insn) * svgCharWidth; n > labelW {
+				labelW = n
+			}
+			for _, x := range row.pressure {
+				if x > peak {
+					peak = x
+				}
+			}
+		}
+		height += (len(t.rows)+2)*svgCellHeight + svgMargin
+	}
+	if len(grids) == 0 {
+		return fmt.Errorf("llvm-mca did not report resource pressure by instruction")
+	}
+	labelW += svgMargin
+	width := svgMargin + labelW + cols*svgCellWidth + svgMargin
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="11">`+"\n", width, height)
+	fmt.Fprintf(bw, `<rect width="%d" height="%d" fill="white"/>`+"\n", width, height)
+	y := svgMargin
+	for _, g := range grids {
+		x0 := svgMargin + labelW
+		name := g.name
+		if name == "" {
+			name = "resource pressure by instruction"
+		}
+		fmt.Fprintf(bw, `<text x="%d" y="%d" font-weight="bold">%s</text>`+"\n", svgMargin, y+svgCellHeight-5, svgEscape(name))
+		y += svgCellHeight
+		for i, res := range g.table.resources {
+			fmt.Fprintf(bw, `<text x="%d" y="%d" text-anchor="middle">%s</text>`+"\n",
+				x0+i*svgCellWidth+svgCellWidth/2, y+svgCellHeight-5, svgEscape(res))
+		}
+		y += svgCellHeight
+		for _, row := range g.table.rows {
+			fmt.Fprintf(bw, `<text x="%d" y="%d">%s</text>`+"\n", svgMargin, y+svgCellHeight-5, svgEscape(row.insn))
+			for i, p := range row.pressure {
+				x := x0 + i*svgCellWidth
+				fmt.Fprintf(bw, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s" stroke="#ddd"><title>%s on %s: %.2f</title></rect>`+"\n",
+					x, y, svgCellWidth, svgCellHeight, heat(p, peak),
+					svgEscape(row.insn), svgEscape(resourceName(g.table, i)), p)
+				if p > 0 {
+					fmt.Fprintf(bw, `<text x="%d" y="%d" text-anchor="middle">%.2f</text>`+"\n",
+						x+svgCellWidth/2, y+svgCellHeight-5, p)
+				}
+			}
+			y += svgCellHeight
+		}
+		y += svgMargin
+	}
+	fmt.Fprintln(bw, "</svg>")
+	return bw.Flush()
+}
+
+// resourceName returns the name of t's ith resource.
+func resourceName(t pressureTable, i int) string {
+	if i < len(t.resources) {
+		return t.resources[i]
+	}
+	return fmt.Sprintf("[%d]", i)
+}
+
+// heat returns the fill color for pressure x, from white for
+// none to red for peak.
+func heat(x, peak float64) string {
+	if peak <= 0 || x <= 0 {
+		return "#ffffff"
+	}
+	f := x / peak
+	if f > 1 {
+		f = 1
+	}
+	c := 255 - int(f*200)
+	return fmt.Sprintf("#ff%02x%02x", c, c)
+}
+
+func svgEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// createSVG writes the heatmap for regions to the file at path.
+func createSVG(path string, regions []mcaRegion) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeSVG(f, regions); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePressure(t *testing.T) {
+	tb, ok := parsePressure(depsReport(t))
+	if !ok {
+		t.Fatal("found no resource pressure by instruction")
+	}
+	resources := []string{
+		"SKLDivider", "SKLFPDivider", "SKLPort0", "SKLPort1", "SKLPort2",
+		"SKLPort3", "SKLPort4", "SKLPort5", "SKLPort6", "SKLPort7",
+	}
+	if !reflect.DeepEqual(tb.resources, resources) {
+		t.Errorf("got resources %q, want %q", tb.resources, resources)
+	}
+	if len(tb.rows) != 12 {
+		t.Fatalf("got %d rows, want 12", len(tb.rows))
+	}
+	// "-" is no pressure.
+	want := pressureRow{
+		insn:     "movq %rax, 8(%rsp)",
+		pressure: []float64{0, 0, 0, 0, 0, 0.03, 1, 0, 0, 0.97},
+	}
+	if !reflect.DeepEqual(tb.rows[0], want) {
+		t.Errorf("got %+v, want %+v", tb.rows[0], want)
+	}
+	if got := tb.rows[11].insn; got != "retq" {
+		t.Errorf("got last instruction %q, want retq", got)
+	}
+
+	if _, ok := parsePressure([]byte("Iterations:        100\n")); ok {
+		t.Error("found resource pressure in a report without it")
+	}
+}
+
+func TestHeat(t *testing.T) {
+	for _, tc := range []struct {
+		x, peak float64
+		want    string
+	}{
+		{0, 1, "#ffffff"},
+		{1, 0, "#ffffff"},
+		{1, 1, "#ff3737"},
+		{0.5, 1, "#ff9b9b"},
+		{2, 1, "#ff3737"},
+	} {
+		if got := heat(tc.x, tc.peak); got != tc.want {
+			t.Errorf("heat(%v, %v) = %s, want %s", tc.x, tc.peak, got, tc.want)
+		}
+	}
+}
+
+func TestWriteSVG(t *testing.T) {
+	report := depsReport(t)
+	regions := []mcaRegion{
+		{name: "main.f<int> & co", text: report},
+		{name: "empty", text: []byte("Iterations:        100\n")},
+		{text: report},
+	}
+	var buf bytes.Buffer
+	if err := writeSVG(&buf, regions); err != nil {
+		t.Fatal(err)
+	}
+
+	// The SVG is well-formed, with a rect for the background and
+	// one per cell of the grids of the two regions with pressure.
+	var (
+		rects int
+		texts []string
+		in    bool
+	)
+	d := xml.NewDecoder(&buf)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if tok.Name.Local == "rect" {
+				rects++
+			}
+			in = tok.Name.Local == "text" || tok.Name.Local == "title"
+		case xml.CharData:
+			if in {
+				texts = append(texts, string(tok))
+			}
+		case xml.EndElement:
+			in = false
+		}
+	}
+	if want := 1 + 2*12*10; rects != want {
+		t.Errorf("got %d rects, want %d", rects, want)
+	}
+	for _, want := range []string{
+		"main.f<int> & co",
+		"resource pressure by instruction",
+		"SKLPort7",
+		"movq %rax, 8(%rsp) on SKLPort4: 1.00",
+	} {
+		found := false
+		for _, s := range texts {
+			found = found || s == want
+		}
+		if !found {
+			t.Errorf("no text %q", want)
+		}
+	}
+	if strings.Contains(strings.Join(texts, "\n"), "empty") {
+		t.Error("got a grid for a region without resource pressure")
+	}
+
+	if err := writeSVG(io.Discard, regions[1:2]); err == nil {
+		t.Error("got no error without resource pressure")
+	}
+}
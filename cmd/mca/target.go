@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+// target describes the machine that a binary was built for.
+type target struct {
+	goos   string
+	goarch string
+	// machine is the machine named by the file header, like
+	// "EM_RISCV", if it is not a GOARCH that mca knows.
+	machine string
+	// triple is the LLVM target triple.
+	triple string
+	// cpu and mattr are the suggested llvm-mca -mcpu and -mattr.
+	// mattr is empty if there is no suggestion.
+	cpu   string
+	mattr string
+}
+
+// goEnv are the GOOS and GOARCH that go is run with, like
+// "GOOS=linux", as set by -goos and -goarch. They override those of
+// the binaries too.
+var goEnv []string
+
+// goEnvVar returns the variable k of goEnv, if it is set.
+func goEnvVar(k string) (string, bool) {
+	for _, e := range goEnv {
+		if strings.HasPrefix(e, k+"=") {
+			return e[len(k)+1:], true
+		}
+	}
+	return "", false
+}
+
+// detectTarget detects the target of the binary at path from its
+// file header and, for Go binaries, its build settings, unless
+// goEnv overrides them.
+//
+// If a is non-nil, it overrides the detected GOARCH.
+func detectTarget(path string, a *mca.Arch) (target, error) {
+	var t target
+	if err := t.readHeader(path); err != nil {
+		return target{}, err
+	}
+	settings := buildSettings(path)
+	for _, k := range []string{"GOOS", "GOARCH"} {
+		if s, ok := goEnvVar(k); ok {
+			settings[k] = s
+		}
+	}
+	if err := t.resolve(settings, a); err != nil {
+		return target{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// envTarget returns the target that go build builds for, from
+// $GOOS, $GOARCH and the variables like $GOAMD64 that pick the
+// CPU, without building anything.
+//
+// If a is non-nil, it overrides the GOARCH.
+func envTarget(a *mca.Arch) (target, error) {
+	t := target{goos: runtime.GOOS, goarch: runtime.GOARCH}
+	settings := make(map[string]string)
+	for _, k := range []string{"GOOS", "GOARCH", "GOAMD64", "GO386", "GOARM64"} {
+		s, ok := goEnvVar(k)
+		if !ok {
+			s = os.Getenv(k)
+		}
+		if s != "" {
+			settings[k] = s
+		}
+	}
+	if err := t.resolve(settings, a); err != nil {
+		return target{}, err
+	}
+	return t, nil
+}
+
+// resolve sets t's GOOS and GOARCH from the build settings, if
+// they have them, and its triple and suggested CPU from those.
+//
+// If a is non-nil, it overrides the GOARCH.
+func (t *target) resolve(settings map[string]string, a *mca.Arch) error {
+	if s := settings["GOOS"]; s != "" {
+		t.goos = s
+	}
+	if s := settings["GOARCH"]; s != "" {
+		t.goarch = s
+	}
+	if a != nil {
+		t.goarch = a.Name
+	}
+
+	t.triple = llvmTriple(t.goos, t.goarch)
+	switch t.goarch {
+	case "amd64":
+		switch settings["GOAMD64"] {
+		case "v2", "v3", "v4":
+			t.cpu = "x86-64-" + settings["GOAMD64"]
+		default:
+			t.cpu = "x86-64"
+		}
+	case "386":
+		// llvm-mca has no scheduling model for the CPUs that
+		// GO386=sse2 and softfloat build for, pentium4 and
+		// i686, so suggest the oldest 32-bit CPU that has one.
+		t.cpu = "yonah"
+	case "arm64":
+		if t.goos == "darwin" || t.goos == "ios" {
+			t.cpu = "apple-m1"
+		} else {
+			t.cpu = "neoverse-n1"
+		}
+		// GOARM64 is vX.Y[,lse][,crypto].
+		// v8.0 is the baseline, which needs no features.
+		if v := settings["GOARM64"]; v != "" {
+			f := strings.Split(v, ",")
+			var attrs []string
+			if f[0] != "v8.0" {
+				attrs = append(attrs, "+"+f[0]+"a")
+			}
+			for _, a := range f[1:] {
+				attrs = append(attrs, "+"+a)
+			}
+			t.mattr = strings.Join(attrs, ",")
+		}
+	default:
+		return fmt.Errorf("unsupported GOARCH %q", t.goarch)
+	}
+	return nil
+}
+
+// llvmTriple returns the LLVM target triple for GOOS and GOARCH,
+// or "" if GOARCH is not supported.
+func llvmTriple(goos, goarch string) string {
+	vendorOS := map[string]string{
+		"linux":   "unknown-linux-gnu",
+		"darwin":  "apple-darwin",
+		"windows": "pc-windows-msvc",
+		"freebsd": "unknown-freebsd",
+	}[goos]
+	if vendorOS == "" {
+		vendorOS = "unknown-unknown"
+	}
+	cpu := map[string]string{
+		"amd64": "x86_64",
+		"386":   "i686",
+		"arm64": "aarch64",
+	}[goarch]
+	if cpu == "" {
+		return ""
+	}
+	return cpu + "-" + vendorOS
+}
+
+// hasMCAFlag reports whether the llvm-mca arguments args set the
+// flag name, like "mtriple".
+func hasMCAFlag(args []string, name string) bool {
+	for _, a := range args {
+		a = strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		if a == name || strings.HasPrefix(a, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// targetArgs returns the llvm-mca arguments args with the flags
+// that select the target of the binary at path, unless they are
+// already set. a, if non-nil, overrides the binary's GOARCH, and
+// mcpu, if non-empty, is the -mcpu to pass.
+//
+// llvm-mca defaults to the host's target, and the host's CPU is
+// only valid for a binary built for the host's GOARCH.
+func targetArgs(path string, a *mca.Arch, mcpu string, args []string) []string {
+	t, err := detectTarget(path, a)
+	return t.args(err == nil, mcpu, args)
+}
+
+// envTargetArgs is like targetArgs for the target of envTarget.
+func envTargetArgs(a *mca.Arch, mcpu string, args []string) []string {
+	t, err := envTarget(a)
+	return t.args(err == nil, mcpu, args)
+}
+
+// args adds the llvm-mca flags for t, if known, to args, as
+// described by targetArgs.
+func (t target) args(known bool, mcpu string, args []string) []string {
+	if known {
+		if !hasMCAFlag(args, "mtriple") && !hasMCAFlag(args, "march") {
+			args = append(args[:len(args):len(args)], "-mtriple="+t.triple)
+		}
+		if mcpu == "" && t.goarch != runtime.GOARCH {
+			mcpu = t.cpu
+		}
+	}
+	if mcpu != "" && !hasMCAFlag(args, "mcpu") {
+		args = append(args[:len(args):len(args)], "-mcpu="+mcpu)
+	}
+	return args
+}
+
+// detectArch returns the architecture of the binary at path from
+// its file header, or nil if it is not a known architecture.
+func detectArch(path string) *mca.Arch {
+	var t target
+	if err := t.readHeader(path); err != nil {
+		return nil
+	}
+	a, _ := mca.LookupArch(t.goarch)
+	return a
+}
+
+// checkArch returns an error if the binary at path is not for one
+// of the architectures that mca supports. The parser and the
+// detection of returns and branches are only right for those.
+// Binaries without a file header that mca can read, like wasm
+// modules, are left to the disassembler.
+func checkArch(path string) error {
+	var t target
+	if err := t.readHeader(path); err != nil {
+		return nil
+	}
+	if _, ok := mca.LookupArch(t.goarch); ok {
+		return nil
+	}
+	return fmt.Errorf("%s: unsupported architecture %s (supported: %s)",
+		path, t.machine, strings.Join(mca.ArchNames(), ", "))
+}
+
+// readHeader sets t's GOOS and GOARCH from the binary's file
+// header.
+func (t *target) readHeader(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if ef, err := elf.NewFile(f); err == nil {
+		t.goos = "linux"
+		if ef.OSABI == elf.ELFOSABI_FREEBSD {
+			t.goos = "freebsd"
+		}
+		switch ef.Machine {
+		case elf.EM_X86_64:
+			t.goarch = "amd64"
+		case elf.EM_386:
+			t.goarch = "386"
+		case elf.EM_AARCH64:
+			t.goarch = "arm64"
+		default:
+			t.machine = ef.Machine.String()
+		}
+	} else if mf, err := macho.NewFile(f); err == nil {
+		t.goos = "darwin"
+		switch mf.Cpu {
+		case macho.CpuAmd64:
+			t.goarch = "amd64"
+		case macho.Cpu386:
+			t.goarch = "386"
+		case macho.CpuArm64:
+			t.goarch = "arm64"
+		default:
+			t.machine = mf.Cpu.String()
+		}
+	} else if pf, err := pe.NewFile(f); err == nil {
+		t.goos = "windows"
+		switch pf.Machine {
+		case pe.IMAGE_FILE_MACHINE_AMD64:
+			t.goarch = "amd64"
+		case pe.IMAGE_FILE_MACHINE_I386:
+			t.goarch = "386"
+		case pe.IMAGE_FILE_MACHINE_ARM64:
+			t.goarch = "arm64"
+		default:
+			t.machine = fmt.Sprintf("PE machine %#x", pf.Machine)
+		}
+	} else {
+		return fmt.Errorf("%s: unrecognized binary format", path)
+	}
+	return nil
+}
+
+// noBuildSettings is set by -n, which runs no commands, so that
+// the target of a binary is only that of its file header.
+var noBuildSettings bool
+
+// buildSettings returns the build settings, like GOAMD64, that
+// "go version -m" reports for the binary at path. Binaries
+// without build information have none, and neither do any under
+// noBuildSettings.
+func buildSettings(path string) map[string]string {
+	settings := make(map[string]string)
+	if noBuildSettings {
+		return settings
+	}
+	out, err := command(goTool, "version", "-m", path).Output()
+	if err != nil {
+		return settings
+	}
+	return parseBuildSettings(out)
+}
+
+// parseBuildSettings returns the build settings in out, the
+// output of "go version -m". Go 1.17 and earlier report none.
+func parseBuildSettings(out []byte) map[string]string {
+	settings := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		f := strings.SplitN(strings.TrimSpace(s.Text()), "\t", 2)
+		if len(f) != 2 || f[0] != "build" {
+			continue
+		}
+		kv := strings.SplitN(f[1], "=", 2)
+		if len(kv) == 2 {
+			settings[kv[0]] = kv[1]
+		}
+	}
+	return settings
+}
+
+// mcaFlags returns the llvm-mca flags for t.
+func (t target) mcaFlags() []string {
+	flags := []string{"-mtriple=" + t.triple, "-mcpu=" + t.cpu}
+	if t.mattr != "" {
+		flags = append(flags, "-mattr="+t.mattr)
+	}
+	return flags
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+func TestBuildSettings(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		// header is the target of the binary's file header.
+		header target
+		// settings are some of the build settings it reports.
+		settings map[string]string
+		want     target
+	}{
+		// Go 1.17 reports no build settings, so the target is
+		// that of the header.
+		{
+			"goversion_go1.17.txt",
+			target{goos: "linux", goarch: "amd64"},
+			map[string]string{},
+			target{goos: "linux", goarch: "amd64", triple: "x86_64-unknown-linux-gnu", cpu: "x86-64"},
+		},
+		{
+			"goversion_go1.27_amd64.txt",
+			target{goos: "linux", goarch: "amd64"},
+			map[string]string{"GOOS": "linux", "GOARCH": "amd64", "GOAMD64": "v3", "-trimpath": "true"},
+			target{goos: "linux", goarch: "amd64", triple: "x86_64-unknown-linux-gnu", cpu: "x86-64-v3"},
+		},
+		{
+			"goversion_go1.27_darwin_arm64.txt",
+			target{goos: "darwin", goarch: "arm64"},
+			map[string]string{"GOOS": "darwin", "GOARCH": "arm64", "GOARM64": "v8.2,lse"},
+			target{goos: "darwin", goarch: "arm64", triple: "aarch64-apple-darwin", cpu: "apple-m1", mattr: "+v8.2a,+lse"},
+		},
+		{
+			"goversion_devel.txt",
+			target{goos: "windows", goarch: "386"},
+			map[string]string{"GOOS": "windows", "GOARCH": "386", "GO386": "softfloat", "-ldflags": `"-s -w -X main.version=v1.2.3"`},
+			target{goos: "windows", goarch: "386", triple: "i686-pc-windows-msvc", cpu: "yonah"},
+		},
+	} {
+		out, err := os.ReadFile("testdata/" + tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		settings := parseBuildSettings(out)
+		if len(tc.settings) == 0 && len(settings) != 0 {
+			t.Errorf("%s: got settings %q, want none", tc.file, settings)
+		}
+		for k, v := range tc.settings {
+			if settings[k] != v {
+				t.Errorf("%s: %s = %q, want %q", tc.file, k, settings[k], v)
+			}
+		}
+		got := tc.header
+		if err := got.resolve(settings, nil); err != nil {
+			t.Errorf("%s: %v", tc.file, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.file, got, tc.want)
+		}
+	}
+}
+
+func TestResolveGOARCH(t *testing.T) {
+	// The build settings of a binary cross-compiled for arm64
+	// override the header, and -isa overrides both.
+	settings := map[string]string{"GOOS": "linux", "GOARCH": "arm64", "GOARM64": "v8.0,crypto"}
+	got := target{goos: "linux", goarch: "amd64"}
+	if err := got.resolve(settings, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := target{goos: "linux", goarch: "arm64", triple: "aarch64-unknown-linux-gnu", cpu: "neoverse-n1", mattr: "+crypto"}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	a, _ := mca.LookupArch("amd64")
+	got = target{goos: "linux", goarch: "amd64"}
+	if err := got.resolve(settings, a); err != nil {
+		t.Fatal(err)
+	}
+	want = target{goos: "linux", goarch: "amd64", triple: "x86_64-unknown-linux-gnu", cpu: "x86-64"}
+	if got != want {
+		t.Errorf("-isa amd64: got %+v, want %+v", got, want)
+	}
+
+	got = target{goos: "linux"}
+	if err := got.resolve(map[string]string{"GOARCH": "riscv64"}, nil); err == nil {
+		t.Errorf("GOARCH=riscv64: got %+v, want an error", got)
+	}
+}
@@ -0,0 +1,61 @@
+// This is the program whose execution trace trace_parsed.txt
+// decodes. It was generated with
+//
+//	go build -trimpath -o fx . && ./fx
+//	go tool trace -d=parsed trace.out > trace_parsed.txt
+//
+// main runs hot while another goroutine runs warm, with CPU
+// profiling enabled so that the trace has CPU samples.
+package main
+
+import (
+	"os"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+)
+
+//go:noinline
+func hot(n int) int {
+	s := 0
+	for i := 0; i < n; i++ {
+		s += i * i
+	}
+	return s
+}
+
+//go:noinline
+func warm(n int) int {
+	s := 0
+	for i := 0; i < n; i++ {
+		s ^= i
+	}
+	return s
+}
+
+var sink int
+
+func main() {
+	tf, _ := os.Create("trace.out")
+	pf, _ := os.Create("cpu.out")
+	pprof.StartCPUProfile(pf)
+	trace.Start(tf)
+	done := make(chan bool)
+	go func() {
+		t := time.Now()
+		for time.Since(t) < 150*time.Millisecond {
+			sink += warm(1e5)
+		}
+		done <- true
+	}()
+	t := time.Now()
+	for time.Since(t) < 150*time.Millisecond {
+		sink += hot(1e5)
+		sink += hot(1e5)
+	}
+	<-done
+	trace.Stop()
+	pprof.StopCPUProfile()
+	tf.Close()
+	pf.Close()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// traceSymbols returns the n functions that were sampled most
+// often in the execution trace at path.
+//
+// The trace must contain CPU samples, which the runtime only
+// records when CPU profiling is enabled alongside tracing (e.g.,
+// "go test -trace=trace.out -cpuprofile=cpu.out"). If goid is
+// positive, only samples from that goroutine are counted.
+//
+// The trace is decoded with "go tool trace -d=parsed" so that
+// the trace format does not need to be parsed here.
+func traceSymbols(path string, goid int64, n int) ([]string, error) {
+	cmd := command(goTool, "tool", "trace", "-d=parsed", path)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go tool trace: %w", err)
+	}
+	syms, err := hottest(out, goid, n)
+	if err != nil {
+		return nil, err
+	}
+	if len(syms) == 0 {
+		if goid > 0 {
+			return nil, fmt.Errorf("%s: no CPU samples for goroutine %d", path, goid)
+		}
+		return nil, fmt.Errorf("%s: no CPU samples in trace (was CPU profiling enabled?)", path)
+	}
+	return syms, nil
+}
+
+// hottest returns the n functions that were sampled most often in
+// out, the output of "go tool trace -d=parsed", most often first,
+// counting samples from goid as traceSymbols does.
+func hottest(out []byte, goid int64, n int) ([]string, error) {
+	counts := make(map[string]int)
+	var (
+		sample bool // in a StackSample that should be counted
+		stack  bool // saw the StackSample's "Stack="
+	)
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		t := s.Text()
+		switch {
+		case strings.Contains(t, " StackSample "):
+			sample, stack = goid <= 0 || traceGoroutine(t) == goid, false
+		case !sample:
+		case t == "Stack=":
+			stack = true
+		case stack && strings.HasPrefix(t, "\t") && !strings.HasPrefix(t, "\t\t"):
+			// The first frame is the leaf: "\tpkg.fn @ 0x54336b".
+			fn := strings.TrimSpace(t[1:])
+			if i := strings.LastIndex(fn, " @ "); i >= 0 {
+				fn = fn[:i]
+			}
+			counts[fn]++
+			sample = false
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	syms := make([]string, 0, len(counts))
+	for fn := range counts {
+		syms = append(syms, fn)
+	}
+	sort.Slice(syms, func(i, j int) bool {
+		if counts[syms[i]] != counts[syms[j]] {
+			return counts[syms[i]] > counts[syms[j]]
+		}
+		return syms[i] < syms[j]
+	})
+	if n > 0 && len(syms) > n {
+		syms = syms[:n]
+	}
+	return syms, nil
+}
+
+// traceGoroutine returns the goroutine in the "G=" field of
+// a parsed trace event, or -1 if there is none.
+func traceGoroutine(event string) int64 {
+	for _, f := range strings.Fields(event) {
+		if strings.HasPrefix(f, "G=") {
+			id, err := strconv.ParseInt(f[len("G="):], 10, 64)
+			if err != nil {
+				return -1
+			}
+			return id
+		}
+	}
+	return -1
+}
+
+// symbolsRegexp returns a regexp for "go tool objdump -s" that
+// matches exactly the symbols syms.
+func symbolsRegexp(syms []string) string {
+	quoted := make([]string, len(syms))
+	for i, s := range syms {
+		quoted[i] = regexp.QuoteMeta(s)
+	}
+	return "^(?:" + strings.Join(quoted, "|") + ")$"
+}
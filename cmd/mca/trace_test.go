@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestHottest(t *testing.T) {
+	out, err := os.ReadFile("testdata/trace_parsed.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		goid int64
+		n    int
+		want []string
+	}{
+		// main.hot and main.warm have 8 samples each, so
+		// they are in order of their names.
+		{"all", 0, 0, []string{"main.hot", "main.warm"}},
+		{"top", 0, 1, []string{"main.hot"}},
+		{"main goroutine", 1, 0, []string{"main.hot"}},
+		{"other goroutine", 10, 5, []string{"main.warm"}},
+		{"no samples", 2, 0, nil},
+	} {
+		got, err := hottest(out, tc.goid, tc.n)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(got) == 0 && len(tc.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestHottestCounts(t *testing.T) {
+	// Only each sample's leaf frame counts, and not the stacks
+	// of other events.
+	const out = `M=1 P=0 G=1 StackSample Time=1
+Stack=
+	main.f @ 0x1000
+		main.go:3
+	main.main @ 0x2000
+		main.go:9
+M=1 P=0 G=1 StackSample Time=2
+Stack=
+	main.g @ 0x1100
+		main.go:5
+	main.f @ 0x1000
+		main.go:3
+M=1 P=0 G=1 StackSample Time=3
+Stack=
+	main.g @ 0x1100
+		main.go:5
+M=1 P=0 G=1 StateTransition Time=4 GoID=1 Running->Waiting Reason="chan receive"
+Stack=
+	main.f @ 0x1000
+		main.go:3
+TransitionStack=
+	main.f @ 0x1000
+		main.go:3
+`
+	got, err := hottest([]byte(out), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"main.g", "main.f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTraceGoroutine(t *testing.T) {
+	for _, tc := range []struct {
+		event string
+		want  int64
+	}{
+		{"M=30431 P=0 G=1 StackSample Time=13852519724416", 1},
+		{"M=-1 P=-1 G=-1 Sync Time=13852519695552 N=1", -1},
+		{"M=30431 P=-1 StateTransition Time=13852519723904", -1},
+		{"M=30431 P=0 G=x StackSample Time=13852519724416", -1},
+	} {
+		if got := traceGoroutine(tc.event); got != tc.want {
+			t.Errorf("traceGoroutine(%q) = %d, want %d", tc.event, got, tc.want)
+		}
+	}
+}
+
+func TestSymbolsRegexp(t *testing.T) {
+	got := symbolsRegexp([]string{"main.f", "pkg.(*T).M"})
+	if want := `^(?:main\.f|pkg\.\(\*T\)\.M)$`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	exec "golang.org/x/sys/execabs"
+)
+
+func versionCmd(args []string) error {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s version [options]\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	toolFlags()
+	fs.Parse(args)
+
+	writeVersion(os.Stdout)
+	return nil
+}
+
+// writeVersion writes mca's version and the versions of the go
+// and llvm-mca commands that it runs.
+func writeVersion(w io.Writer) {
+	version := "(unknown)"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = info.Main.Version
+	}
+	fmt.Fprintf(w, "%s %s (built with %s)\n", os.Args[0], version, runtime.Version())
+	for _, cmd := range [][]string{
+		{goTool, "version"},
+		{mcaTool, "--version"},
+	} {
+		out, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
+		if err != nil {
+			fmt.Fprintf(w, "%s: %v\n", cmd[0], err)
+			continue
+		}
+		// Only keep llvm-mca's version and host, not its
+		// list of targets.
+		if i := bytes.Index(out, []byte("\n\n")); i >= 0 {
+			out = out[:i]
+		}
+		fmt.Fprintf(w, "%s\n", bytes.TrimSpace(out))
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	mca "github.com/ericlagergren/go-llvm-mca"
+)
+
+// isWasm reports whether the file at path is a Wasm module.
+func isWasm(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var magic [4]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return false
+	}
+	return string(magic[:]) == "\x00asm"
+}
+
+// wasmObjdump returns the "wasm-objdump -d" output for the
+// functions in bin that match symReg.
+func wasmObjdump(bin, symReg string) ([]byte, error) {
+	re, err := regexp.Compile(symReg)
+	if err != nil {
+		return nil, err
+	}
+	cmd := command("wasm-objdump", "-d", bin)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	keep := false
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		t := s.Text()
+		if name, ok := mca.WasmObjdumpFormat.Symbol(t); ok {
+			keep = re.MatchString(name)
+		} else if mca.WasmObjdumpFormat.Skip(t) {
+			continue
+		}
+		if keep {
+			b.WriteString(t)
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes(), s.Err()
+}
+
+// runWasm prints the disassembly of the functions in the Wasm
+// module bin that match symReg.
+//
+// llvm-mca does not model Wasm, so there is no analysis, and
+// mcaOnly reports whether any options that only affect the
+// analysis were set.
+func runWasm(bin, symReg string, cfg mca.Config, mcaOnly bool) error {
+	if mcaOnly {
+		return useErr("-brief, -by-bottleneck, -follow-calls, -per-symbol, -svg, and -threshold-port need llvm-mca, which does not support wasm")
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s is a wasm module, which llvm-mca does not support; printing its disassembly instead\n", os.Args[0], bin)
+	out, err := wasmObjdump(bin, symReg)
+	if err != nil {
+		return err
+	}
+	if len(out) == 0 {
+		return fmt.Errorf("no functions in %s match %s", bin, symReg)
+	}
+	cfg.Format = mca.WasmObjdumpFormat
+	cfg.Regions, cfg.AutoRegions = false, false
+	return cfg.Fix(os.Stdout, bytes.NewReader(out))
+}
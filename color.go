@@ -0,0 +1,178 @@
+package mca
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// ANSI escape sequences for colorWriter.
+const (
+	colorMnemonic = "\x1b[32m" // green
+	colorPos      = "\x1b[36m" // cyan
+	colorInstr    = "\x1b[33m" // yellow
+	colorReset    = "\x1b[0m"
+)
+
+// colorWriter colors the mnemonic, file:line, and encoding of
+// each instruction line with ANSI escape sequences.
+//
+// It sees the output after it has been laid out, so the escape
+// sequences do not throw off the alignment. Lines that are not
+// instructions, like labels and reports, are written unchanged.
+type colorWriter struct {
+	w io.Writer
+	// cols are the metadata columns of the comments, in order,
+	// and sep, if set, separates them instead of spaces.
+	cols []string
+	sep  string
+	// instrSep separates the bytes of the encodings.
+	instrSep string
+	// contextSym reports whether each instruction is prefixed
+	// with its symbol.
+	contextSym bool
+	buf        []byte
+}
+
+var _ io.Writer = (*colorWriter)(nil)
+
+func (cw *colorWriter) Write(p []byte) (int, error) {
+	cw.buf = append(cw.buf, p...)
+	for {
+		i := bytes.IndexByte(cw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := io.WriteString(cw.w, cw.color(string(cw.buf[:i]))+"\n"); err != nil {
+			return 0, err
+		}
+		cw.buf = cw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes any buffered partial line.
+func (cw *colorWriter) Flush() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(cw.w, string(cw.buf))
+	cw.buf = cw.buf[:0]
+	return err
+}
+
+// color returns the line s with its columns colored, if it is an
+// instruction.
+func (cw *colorWriter) color(s string) string {
+	i := 0
+	if strings.HasPrefix(s, "  ") || strings.HasPrefix(s, "+ ") {
+		i = 2
+	} else if !cw.contextSym {
+		return s
+	}
+	if i == len(s) || isSpace(s[i]) || strings.HasPrefix(s[i:], "//") || strings.HasPrefix(s[i:], "#") {
+		// A comment, a dedupe count, or a wrapped comment.
+		return s
+	}
+	if cw.contextSym {
+		j := strings.IndexAny(s[i:], " \t")
+		if j < 0 {
+			// A label.
+			return s
+		}
+		i += j
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+	}
+	var b strings.Builder
+	b.WriteString(s[:i])
+	s = s[i:]
+	j := strings.IndexAny(s, " \t")
+	if j < 0 {
+		j = len(s)
+	}
+	b.WriteString(colorMnemonic + s[:j] + colorReset)
+	s = s[j:]
+
+	j = strings.Index(s, "// ")
+	if j < 0 {
+		b.WriteString(s)
+		return b.String()
+	}
+	j += len("// ")
+	b.WriteString(s[:j])
+	s = s[j:]
+	for _, col := range cw.cols {
+		if col == MetaGoAsm {
+			// The Go assembly has spaces, so the columns after
+			// it cannot be found.
+			break
+		}
+		j := strings.IndexAny(s, " \t")
+		switch {
+		case cw.sep != "":
+			j = strings.Index(s, cw.sep)
+		case col == MetaInstr:
+			j = cw.instrEnd(s)
+		}
+		if j < 0 {
+			j = len(s)
+		}
+		switch col {
+		case MetaFile:
+			b.WriteString(colorPos + s[:j] + colorReset)
+		case MetaInstr:
+			b.WriteString(colorInstr + s[:j] + colorReset)
+		default:
+			b.WriteString(s[:j])
+		}
+		s = s[j:]
+		k := 0
+		if cw.sep != "" && strings.HasPrefix(s, cw.sep) {
+			k = len(cw.sep)
+		}
+		for k < len(s) && isSpace(s[k]) {
+			k++
+		}
+		b.WriteString(s[:k])
+		s = s[k:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// instrEnd returns the length of the encoding at the start of s,
+// whose bytes may be separated by spaces.
+func (cw *colorWriter) instrEnd(s string) int {
+	j := 0
+	for {
+		k := strings.IndexAny(s[j:], " \t")
+		if k < 0 {
+			return len(s)
+		}
+		j += k
+		rest := s[j:]
+		if cw.instrSep == "" || !strings.HasPrefix(rest, cw.instrSep) || !isHexByte(rest[len(cw.instrSep):], cw.instrSep) {
+			return j
+		}
+		j += len(cw.instrSep)
+	}
+}
+
+// isHexByte reports whether s begins with a byte in lowercase hex
+// that ends the column or is followed by sep.
+func isHexByte(s, sep string) bool {
+	if len(s) < 2 || !isLowerHex(s[0]) || !isLowerHex(s[1]) {
+		return false
+	}
+	return len(s) == 2 || isSpace(s[2]) || strings.HasPrefix(s[2:], sep)
+}
+
+func isLowerHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
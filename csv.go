@@ -0,0 +1,23 @@
+package mca
+
+import (
+	"encoding/hex"
+	"strconv"
+)
+
+// csvHeader is the header row of fix -csv output.
+var csvHeader = []string{"symbol", "file", "line", "offset", "instr", "goasm", "gnuasm"}
+
+// csvRecord returns the fix -csv row for l in the TEXT symbol
+// with the label sym.
+func csvRecord(l Line, sym string) []string {
+	return []string{
+		sym,
+		l.File,
+		strconv.Itoa(l.Line),
+		"0x" + strconv.FormatUint(l.Offset, 16),
+		hex.EncodeToString(l.Instr),
+		l.GoAsm,
+		l.GNUAsm,
+	}
+}
@@ -0,0 +1,62 @@
+package mca
+
+import (
+	"strings"
+)
+
+// A symbol matched by -s can be data rather than code, like a
+// table in a C object linked into a cgo binary, in which case the
+// disassembler dumps its bytes without instructions. Fix collects
+// such lines until the symbol's first instruction and, if there is
+// none, replaces them with a single comment holding the symbol's
+// bytes rather than skipping or failing on each line.
+
+// dataLine is a line of a symbol's contents that is not an
+// instruction.
+type dataLine struct {
+	l Line
+	// undecoded is set if l is a byte that the disassembler
+	// could not decode, as parsed by splitUndecoded.
+	undecoded bool
+	t         string
+	n         int
+	err       error
+}
+
+// splitData parses a line that holds bytes but no instruction,
+// like
+//
+//	0x4d2f40	0102030405060708
+//
+// optionally preceded by a file:line and followed by "?".
+func splitData(s string) (Line, bool) {
+	f := strings.Fields(s)
+	if len(f) > 0 && !strings.HasPrefix(f[0], "0x") && strings.Contains(f[0], ":") {
+		f = f[1:]
+	}
+	if len(f) < 2 || len(f) > 3 || (len(f) == 3 && f[2] != "?") || !strings.HasPrefix(f[0], "0x") {
+		return Line{}, false
+	}
+	off, rest, err := readHexUint(f[0][len("0x"):])
+	if err != nil || rest != "" {
+		return Line{}, false
+	}
+	b, rest, err := readHex(f[1])
+	if err != nil || rest != "" || len(b) == 0 {
+		return Line{}, false
+	}
+	return Line{Offset: off, Instr: b}, true
+}
+
+// dataHex returns the bytes of d, and of the undecoded bytes u
+// that follow them, as hex with sep between the bytes.
+func dataHex(d []dataLine, u []Line, sep string) string {
+	var b []byte
+	for _, l := range d {
+		b = append(b, l.l.Instr...)
+	}
+	for _, l := range u {
+		b = append(b, l.Instr...)
+	}
+	return formatInstr(b, sep)
+}
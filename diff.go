@@ -0,0 +1,283 @@
+package mca
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// LCS returns, for each element of a and b, whether it is part
+// of a longest common subsequence of a and b.
+func LCS(a, b []string) (inA, inB []bool) {
+	// n[i][j] is the length of the LCS of a[i:] and b[j:].
+	n := make([][]int32, len(a)+1)
+	for i := range n {
+		n[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				n[i][j] = n[i+1][j+1] + 1
+			case n[i+1][j] >= n[i][j+1]:
+				n[i][j] = n[i+1][j]
+			default:
+				n[i][j] = n[i][j+1]
+			}
+		}
+	}
+	inA, inB = make([]bool, len(a)), make([]bool, len(b))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			inA[i], inB[j] = true, true
+			i++
+			j++
+		case n[i+1][j] >= n[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return inA, inB
+}
+
+// Baseline is a prior dump to compare against, as the canonical
+// GNU assembly of each symbol's instructions.
+type Baseline struct {
+	syms map[string][]string
+}
+
+// symKey returns the key that identifies the symbol name across
+// dumps, which is its mangled name without the source path.
+//
+// name is either the remainder of a TEXT line or a label.
+func symKey(name string) string {
+	name = mangle(TextName(strings.TrimSuffix(name, ":")))
+	if i := strings.Index(name, "_SB_"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// AsmKey returns the form of the GNU assembly s that is compared
+// with a baseline.
+func AsmKey(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// sinceKey returns the key of l, an instruction in sym, that is
+// compared with a baseline.
+//
+// Branches within sym are keyed without their target, which
+// moves whenever code is added before it.
+func sinceKey(l Line, sym string, start uint64) string {
+	s := AsmKey(canonicalAsm(l, sym, start))
+	if l.HasTarget && l.TargetSym == "" {
+		s = localTarget(s)
+	}
+	return s
+}
+
+// localTarget replaces the target of the canonical branch s with
+// ".".
+func localTarget(s string) string {
+	i := strings.LastIndexAny(s, ", ")
+	return s[:i+1] + "."
+}
+
+// ReadBaseline reads a baseline from the file at path, which is
+// either "go tool objdump -gnu" output or the output of fix.
+//
+// Branch targets in objdump output are canonicalized. Those in
+// fix output are compared as is, so fix output makes the best
+// baseline when it was written with -canonical.
+func ReadBaseline(path string, a *Arch) (*Baseline, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &Baseline{syms: make(map[string][]string)}
+	var (
+		sym     string
+		start   uint64
+		started bool
+	)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		t := s.Text()
+		switch {
+		case strings.HasPrefix(t, "TEXT "):
+			sym, started = strings.TrimPrefix(t, "TEXT "), false
+			b.syms[symKey(sym)] = nil
+			continue
+		case strings.TrimSpace(t) == "",
+			strings.HasPrefix(t, "#"),
+			strings.HasPrefix(t, "//"):
+			continue
+		case !strings.HasPrefix(t, " ") && !strings.HasPrefix(t, "\t") && !strings.HasPrefix(t, "+"):
+			// A label.
+			sym, started = strings.TrimSuffix(t, ":"), false
+			b.syms[symKey(sym)] = nil
+			continue
+		}
+		if sym == "" {
+			sym = "headerless"
+		}
+		k := symKey(sym)
+		if l, err := ParseLine(t); err == nil {
+			a.Classify(&l)
+			if !started {
+				start, started = l.Offset, true
+			}
+			b.syms[k] = append(b.syms[k], sinceKey(l, sym, start))
+			continue
+		}
+		// fix output, possibly marked by a previous -since.
+		t = strings.TrimPrefix(t, "+")
+		if i := strings.Index(t, "//"); i >= 0 {
+			t = t[:i]
+		}
+		t = AsmKey(t)
+		if t == "" {
+			continue
+		}
+		// Canonical branches within sym look like "jmp main.f+0x1c".
+		if i := strings.LastIndexAny(t, ", "); i >= 0 {
+			if j := strings.LastIndex(t, "+0x"); j > i && symKey(t[i+1:j]) == k {
+				t = localTarget(t)
+			}
+		}
+		b.syms[k] = append(b.syms[k], t)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
+
+// added reports, for each of the keys of sym's instructions,
+// whether it was added since the baseline.
+func (b *Baseline) added(sym string, keys []string) []bool {
+	_, in := LCS(b.syms[symKey(sym)], keys)
+	for i := range in {
+		in[i] = !in[i]
+	}
+	return in
+}
+
+// diffInsn is an instruction in a symbolDiff.
+type diffInsn struct {
+	// Index is the instruction's index in its symbol.
+	Index int `json:"index"`
+	// Asm is the instruction's key, as compared with the
+	// baseline: its canonical GNU assembly, with local branch
+	// targets replaced by ".".
+	Asm string `json:"asm"`
+	// Offset is the instruction's address. It is only set for
+	// instructions in the current dump.
+	Offset *uint64 `json:"offset,omitempty"`
+}
+
+// diffChange is an instruction that replaced a baseline
+// instruction at the same position.
+type diffChange struct {
+	Old diffInsn `json:"old"`
+	New diffInsn `json:"new"`
+}
+
+// symbolDiff is the difference between one symbol's
+// instructions in the baseline and in the current dump.
+type symbolDiff struct {
+	// Symbol is the key that identifies the symbol across
+	// dumps: its mangled name without the source path.
+	Symbol  string       `json:"symbol"`
+	Added   []diffInsn   `json:"added"`
+	Removed []diffInsn   `json:"removed"`
+	Changed []diffChange `json:"changed"`
+	// Baseline and Current are the number of instructions in
+	// each dump, and Delta is Current minus Baseline.
+	Baseline int `json:"baseline_instructions"`
+	Current  int `json:"current_instructions"`
+	Delta    int `json:"instruction_delta"`
+}
+
+// diffReport is the output of fix -diff-json.
+type diffReport struct {
+	Symbols []symbolDiff `json:"symbols"`
+	// Added, Removed, Changed and Delta are the sums over
+	// Symbols.
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Changed int `json:"changed"`
+	Delta   int `json:"instruction_delta"`
+}
+
+func (r *diffReport) add(d symbolDiff) {
+	r.Symbols = append(r.Symbols, d)
+	r.Added += len(d.Added)
+	r.Removed += len(d.Removed)
+	r.Changed += len(d.Changed)
+	r.Delta += d.Delta
+}
+
+// diff compares sym's instructions, lines, and their keys with
+// the baseline.
+//
+// Between two instructions common to both dumps, each removed
+// instruction is paired with an added instruction, in order, as
+// a change. Any left over are added or removed.
+func (b *Baseline) diff(sym string, keys []string, lines []Line) symbolDiff {
+	base := b.syms[symKey(sym)]
+	inA, inB := LCS(base, keys)
+	d := symbolDiff{
+		Symbol:   symKey(sym),
+		Added:    []diffInsn{},
+		Removed:  []diffInsn{},
+		Changed:  []diffChange{},
+		Baseline: len(base),
+		Current:  len(keys),
+		Delta:    len(keys) - len(base),
+	}
+	for i, j := 0, 0; i < len(base) || j < len(keys); {
+		var removed, added []diffInsn
+		for ; i < len(base) && !inA[i]; i++ {
+			removed = append(removed, diffInsn{Index: i, Asm: base[i]})
+		}
+		for ; j < len(keys) && !inB[j]; j++ {
+			off := lines[j].Offset
+			added = append(added, diffInsn{Index: j, Asm: keys[j], Offset: &off})
+		}
+		for len(removed) > 0 && len(added) > 0 {
+			d.Changed = append(d.Changed, diffChange{Old: removed[0], New: added[0]})
+			removed, added = removed[1:], added[1:]
+		}
+		d.Removed = append(d.Removed, removed...)
+		d.Added = append(d.Added, added...)
+		// Skip the common instruction.
+		i++
+		j++
+	}
+	return d
+}
+
+// missing returns the diffs of the baseline's symbols whose keys
+// are not in seen, which were removed entirely.
+func (b *Baseline) missing(seen map[string]bool) []symbolDiff {
+	var names []string
+	for k := range b.syms {
+		if !seen[k] {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	diffs := make([]symbolDiff, len(names))
+	for i, k := range names {
+		diffs[i] = b.diff(k, nil, nil)
+	}
+	return diffs
+}
@@ -0,0 +1,116 @@
+package mca
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLCS(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		// inA and inB mark the elements of a and b in the
+		// LCS with 'x'.
+		inA, inB string
+	}{
+		{"", "", "", ""},
+		{"abc", "", "...", ""},
+		{"", "abc", "", "..."},
+		{"abc", "abc", "xxx", "xxx"},
+		{"abc", "xyz", "...", "..."},
+		{"abc", "aXbc", "xxx", "x.xx"},
+		{"aXbc", "abc", "x.xx", "xxx"},
+		// Of equally long subsequences, the one that skips
+		// elements of a first is chosen.
+		{"abcd", "acbd", "x.xx", "xx.x"},
+		{"abab", "baba", ".xxx", "xxx."},
+		{"aaa", "aa", "xx.", "xx"},
+	} {
+		a, b := strings.Split(tc.a, ""), strings.Split(tc.b, "")
+		inA, inB := LCS(a, b)
+		if got := marks(inA); got != tc.inA {
+			t.Errorf("LCS(%q, %q): a is %q, want %q", tc.a, tc.b, got, tc.inA)
+		}
+		if got := marks(inB); got != tc.inB {
+			t.Errorf("LCS(%q, %q): b is %q, want %q", tc.a, tc.b, got, tc.inB)
+		}
+	}
+}
+
+// marks returns in as a string of 'x' for true and '.' for false.
+func marks(in []bool) string {
+	var b strings.Builder
+	for _, x := range in {
+		if x {
+			b.WriteByte('x')
+		} else {
+			b.WriteByte('.')
+		}
+	}
+	return b.String()
+}
+
+// sinceAdded returns the instructions that c.Since marks as added
+// in the assembly out.
+func sinceAdded(out string) []string {
+	var added []string
+	for _, l := range strings.Split(out, "\n") {
+		if strings.HasPrefix(l, "+") {
+			added = append(added, strings.TrimSpace(l[1:]))
+		}
+	}
+	return added
+}
+
+func TestFixSince(t *testing.T) {
+	dump := readFile(t, "testdata/dump_amd64.txt")
+	old := readFile(t, "testdata/old_amd64.txt")
+	dir := t.TempDir()
+	write := func(name, s string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	since := func(path string) []string {
+		b, err := ReadBaseline(path, archAMD64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sinceAdded(fix(t, Config{Arch: archAMD64, Since: b}, dump))
+	}
+
+	// sum squares each element since old_amd64.txt. The loop
+	// branches moved, but are within sum, so they match.
+	want := []string{"mov (%rax,%rcx,8),%rsi", "imul %rsi,%rsi", "add %rsi,%rdx"}
+	for _, tc := range []struct {
+		name string
+		path string
+	}{
+		{"objdump", "testdata/old_amd64.txt"},
+		{"canonical", write("canonical.s", fix(t, Config{Arch: archAMD64, Canonical: true}, old))},
+	} {
+		if got := since(tc.path); !equalStrings(got, want) {
+			t.Errorf("%s baseline: got added %q, want %q", tc.name, got, want)
+		}
+	}
+
+	// The output of -since is itself a baseline, whose
+	// markers are ignored.
+	b, err := ReadBaseline("testdata/old_amd64.txt", archAMD64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marked := fix(t, Config{Arch: archAMD64, Canonical: true, Since: b}, dump)
+	if got := since(write("marked.s", marked)); len(got) != 0 {
+		t.Errorf("-since baseline of the same dump: got added %q", got)
+	}
+
+	// Every instruction of a symbol missing from the baseline
+	// is added.
+	if got, want := since(write("empty.s", "")), strings.Count(dump, "\n  "); len(got) != want {
+		t.Errorf("empty baseline: got %d added, want %d", len(got), want)
+	}
+}
@@ -0,0 +1,149 @@
+package mca
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// energyClass is a coarse class of instructions with similar
+// energy cost.
+type energyClass int
+
+const (
+	energyNop energyClass = iota
+	energyALU
+	energyBranch
+	energyCall
+	energyMul
+	energyDiv
+	energyFP
+	energyFPDiv
+	energyVector
+)
+
+// energyCosts are the approximate energy costs of each class,
+// relative to a simple integer operation.
+//
+// They are not measurements. They roughly follow the number of
+// uops and the kind of execution unit that each class uses on
+// current cores: a multiply occupies a longer pipeline than an
+// add, a divide is microcoded or iterates for many cycles, and
+// vector units are wider.
+var energyCosts = [...]float64{
+	energyNop:    0.3,
+	energyALU:    1,
+	energyBranch: 1,
+	energyCall:   2,
+	energyMul:    3,
+	energyDiv:    20,
+	energyFP:     2,
+	energyFPDiv:  12,
+	energyVector: 3,
+}
+
+// energyMemory is the approximate additional energy cost of an
+// instruction that loads or stores, assuming an L1 hit.
+const energyMemory = 2
+
+// energy returns l's approximate energy cost relative to a simple
+// integer operation.
+//
+// A nil arch tries every known architecture.
+func (a *Arch) energy(l Line) float64 {
+	m, ops := l.mnemonic(), l.operands()
+	var (
+		class energyClass
+		mem   bool
+	)
+	switch {
+	case a != nil:
+		class, mem = a.class(m, ops)
+	case strings.Contains(l.GNUAsm, "%"):
+		class, mem = x86Class(m, ops)
+	default:
+		class, mem = arm64Class(m, ops)
+	}
+	switch l.kind {
+	case kindBranch, kindJump:
+		class = energyBranch
+	case kindCall, kindReturn:
+		class = energyCall
+	}
+	e := energyCosts[class]
+	if mem {
+		e += energyMemory
+	}
+	return e
+}
+
+func x86Class(m string, ops []string) (energyClass, bool) {
+	mem := false
+	if m != "lea" && m != "leaq" && m != "leal" && !strings.HasPrefix(m, "nop") {
+		for _, op := range ops {
+			if strings.Contains(op, "(") {
+				mem = true
+			}
+		}
+	}
+	vector := false
+	for _, op := range ops {
+		if strings.Contains(op, "%ymm") || strings.Contains(op, "%zmm") {
+			vector = true
+		}
+	}
+	// Scalar SSE and AVX instructions end in "ss" or "sd".
+	scalar := strings.HasSuffix(m, "ss") || strings.HasSuffix(m, "sd")
+	switch {
+	case strings.HasPrefix(m, "nop"), m == "pause", m == "endbr64", m == "endbr32":
+		return energyNop, mem
+	case strings.Contains(m, "sqrt"),
+		scalar && (strings.HasPrefix(m, "div") || strings.HasPrefix(m, "vdiv")):
+		return energyFPDiv, mem
+	case strings.HasPrefix(m, "div"), strings.HasPrefix(m, "idiv"):
+		return energyDiv, mem
+	case strings.HasPrefix(m, "mul"), strings.HasPrefix(m, "imul"):
+		return energyMul, mem
+	case vector:
+		return energyVector, mem
+	case scalar,
+		strings.HasPrefix(m, "cvt"), strings.HasPrefix(m, "vcvt"),
+		strings.HasPrefix(m, "xorp"), strings.HasPrefix(m, "ucomis"):
+		return energyFP, mem
+	}
+	return energyALU, mem
+}
+
+func arm64Class(m string, ops []string) (energyClass, bool) {
+	mem := strings.HasPrefix(m, "ld") || strings.HasPrefix(m, "st")
+	vector := false
+	for _, op := range ops {
+		if strings.HasPrefix(op, "v") || strings.HasPrefix(op, "q") || strings.HasPrefix(op, "{") {
+			vector = true
+		}
+	}
+	switch {
+	case m == "nop", m == "yield", strings.HasPrefix(m, "hint"), m == "bti":
+		return energyNop, mem
+	case m == "fdiv", m == "fsqrt":
+		return energyFPDiv, mem
+	case m == "sdiv", m == "udiv":
+		return energyDiv, mem
+	case m == "mul", m == "madd", m == "msub", m == "mneg",
+		m == "smull", m == "umull", m == "smulh", m == "umulh",
+		m == "smaddl", m == "umaddl":
+		return energyMul, mem
+	case vector && !mem:
+		return energyVector, mem
+	case strings.HasPrefix(m, "f"), strings.HasPrefix(m, "scvtf"), strings.HasPrefix(m, "ucvtf"):
+		return energyFP, mem
+	}
+	return energyALU, mem
+}
+
+// writeEnergy writes a symbol's approximate energy total as a
+// comment.
+func writeEnergy(w io.Writer, sym string, total float64, n int) {
+	fmt.Fprintf(w, "// approximate relative energy of %s: %.1f for %d instructions (1.0 = one integer add)\n",
+		TextName(sym), total, n)
+}
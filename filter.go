@@ -0,0 +1,88 @@
+package mca
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EncodingFilter matches instructions whose leading bytes, ANDed
+// with mask, equal value.
+type EncodingFilter struct {
+	Mask  []byte
+	Value []byte
+}
+
+// ParseEncodingFilter parses a filter of the form MASK/VALUE,
+// where MASK and VALUE are hex byte strings of the same length,
+// like "fff0/0f80" for the two-byte jcc encodings.
+func ParseEncodingFilter(s string) (*EncodingFilter, error) {
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return nil, errors.New("missing / between mask and value")
+	}
+	mask, err := hex.DecodeString(strings.TrimPrefix(s[:i], "0x"))
+	if err != nil {
+		return nil, errors.New("invalid mask: " + err.Error())
+	}
+	value, err := hex.DecodeString(strings.TrimPrefix(s[i+1:], "0x"))
+	if err != nil {
+		return nil, errors.New("invalid value: " + err.Error())
+	}
+	if len(mask) == 0 || len(mask) != len(value) {
+		return nil, errors.New("mask and value must be the same, non-zero length")
+	}
+	return &EncodingFilter{Mask: mask, Value: value}, nil
+}
+
+// match reports whether instr matches f.
+func (f *EncodingFilter) match(instr []byte) bool {
+	if len(instr) < len(f.Mask) {
+		return false
+	}
+	for i, m := range f.Mask {
+		if instr[i]&m != f.Value[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// AddrRange matches the instructions whose offsets are in
+// [Start, End).
+type AddrRange struct {
+	Start, End uint64
+}
+
+// ParseAddrRange parses a range of the form START:END, where
+// START and END are hex addresses with an optional 0x prefix,
+// like "0x499de0:0x499e10". END is exclusive.
+func ParseAddrRange(s string) (*AddrRange, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, errors.New("missing : between start and end")
+	}
+	start, err := strconv.ParseUint(strings.TrimPrefix(s[:i], "0x"), 16, 64)
+	if err != nil {
+		return nil, errors.New("invalid start: " + err.Error())
+	}
+	end, err := strconv.ParseUint(strings.TrimPrefix(s[i+1:], "0x"), 16, 64)
+	if err != nil {
+		return nil, errors.New("invalid end: " + err.Error())
+	}
+	if start >= end {
+		return nil, errors.New("start must be before end")
+	}
+	return &AddrRange{Start: start, End: end}, nil
+}
+
+func (r *AddrRange) String() string {
+	return fmt.Sprintf("%#x:%#x", r.Start, r.End)
+}
+
+// contains reports whether off is in r.
+func (r *AddrRange) contains(off uint64) bool {
+	return off >= r.Start && off < r.End
+}
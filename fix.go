@@ -0,0 +1,1803 @@
+// Package mca converts the output of "go tool objdump -gnu" into
+// assembly that llvm-mca accepts.
+//
+// The mca command in cmd/mca is built on it.
+package mca
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Config configures Fix. The zero Config emits the GNU assembly
+// of every instruction in the dialect that objdump printed.
+type Config struct {
+	// File, Offset, Instr, and GoAsm comment each instruction
+	// with its file:line, offset, encoding, and Go assembly.
+	File   bool
+	Offset bool
+	Instr  bool
+	GoAsm  bool
+	// InstrSep, if set, separates the bytes of the encodings that
+	// Instr adds, so that " " prints "f9 40 07 e0" like objdump's
+	// raw bytes instead of "f94007e0".
+	InstrSep string
+	// MetaOrder, if set, is the order of the File, Offset, Instr
+	// and GoAsm columns, as MetaFile, MetaOffset, MetaInstr and
+	// MetaGoAsm. Columns that are not listed follow in that
+	// order; only the columns that are enabled are written.
+	MetaOrder []string
+	// MetaSep, if set, separates those columns instead of a tab,
+	// which aligns them.
+	MetaSep string
+	// MetaLabels labels each of those columns, like "src=x.go:5"
+	// and "off=0x1000", so that they can be told apart when
+	// some are disabled.
+	MetaLabels bool
+	// Arch is the input's architecture, or nil if unknown.
+	Arch *Arch
+	// Format is the input's format, or nil to detect it from
+	// the first line.
+	Format *InputFormat
+	// Headerless is the symbol used for instructions that
+	// precede the first TEXT line, like those in a dump that was
+	// sliced mid-function. If empty, such input is an error.
+	Headerless string
+	// MinWidth, TabWidth, and Padding are the minimum width of
+	// a column, the width of a tab, and the padding added to
+	// each column when aligning them. If TabWidth is zero, they
+	// are 18, 8, and 1.
+	MinWidth int
+	TabWidth int
+	Padding  int
+	// Raw separates columns with single tabs instead of
+	// aligning them, so that a long instruction does not
+	// change the layout of the lines around it. 0xff bytes are
+	// written verbatim, as with EscapeOff.
+	Raw bool
+	// EscapeOff writes 0xff bytes in the input verbatim
+	// instead of dropping them. Either way, they do not change
+	// the layout of the lines around them.
+	EscapeOff bool
+	// Regions wraps each TEXT symbol in LLVM-MCA-BEGIN and
+	// LLVM-MCA-END markers so that llvm-mca analyzes each
+	// symbol as its own code region.
+	Regions bool
+	// AutoRegions, if Regions is not set, sets it once the input
+	// turns out to have more than one TEXT symbol.
+	AutoRegions bool
+	// WrapWidth, if positive, wraps comments in lines longer
+	// than wrapWidth columns.
+	WrapWidth int
+	// CheckSpills, if set, makes fix fail after writing its
+	// output if any symbol has more than MaxSpills instructions
+	// that spill registers to or reload them from the stack.
+	CheckSpills bool
+	MaxSpills   int
+	// Dedupe replaces each symbol's instructions with its
+	// distinct instructions and how often each occurs.
+	Dedupe bool
+	// Since, if non-nil, marks the instructions that are not in
+	// the baseline with a leading "+".
+	Since *Baseline
+	// Symtab, if non-nil, is used to annotate direct branches
+	// and calls with the name of their target.
+	Symtab *Symtab
+	// Encoding, if non-nil, only emits instructions whose
+	// encoding matches it.
+	Encoding *EncodingFilter
+	// Addr, if non-nil, only emits instructions whose offsets
+	// are in it, like a hot loop that a profile found.
+	Addr *AddrRange
+	// Filter, if non-nil, only emits instructions whose GNU
+	// assembly matches it, and Exclude, if non-nil, drops those
+	// whose GNU assembly matches it. Branch targets are labeled
+	// as though every instruction were emitted.
+	Filter  *regexp.Regexp
+	Exclude *regexp.Regexp
+	// AlignComments pads with spaces so that the trailing
+	// comments of each symbol's instructions start at the same
+	// column.
+	AlignComments bool
+	// Dialect is the dialect of the emitted assembly.
+	Dialect string
+	// Normalize rewrites PC-relative operands, like x86 %rip
+	// displacements and the addresses of arm64 adr, adrp and
+	// literal loads, to a fixed displacement. llvm-mca schedules
+	// the instructions the same, but the addresses are lost. It
+	// also strips comments, like Go frame annotations, that
+	// follow an instruction's GNU assembly.
+	Normalize bool
+	// MaxLineLen, if positive, warns about instructions whose
+	// GNU assembly is longer than maxLineLen characters, which
+	// usually means that the line was misparsed.
+	MaxLineLen int
+	// Stop is when to stop emitting instructions: StopNone (or
+	// empty) or StopRet.
+	Stop string
+	// StopAt, if non-nil, are the returns at which StopRet
+	// stops, as mnemonics or whole instructions, instead of
+	// those of Arch.
+	StopAt []string
+	// StopMatch, if non-nil, stops each symbol at its first
+	// instruction whose GNU assembly matches it, whatever Stop
+	// is. StartMatch, if non-nil, skips each symbol's
+	// instructions before the first one whose GNU assembly
+	// matches it. Together they select a region of each
+	// symbol.
+	StopMatch  *regexp.Regexp
+	StartMatch *regexp.Regexp
+	// KeepStop emits the instruction that Stop or StopMatch
+	// stops at, like a return whose cost should be counted,
+	// instead of replacing it with a "stopping at" comment.
+	KeepStop bool
+	// StopComment is the text of the comment that replaces the
+	// instruction at which Stop or StopMatch stops, before the
+	// instruction. If empty, it is "stopping at".
+	StopComment string
+	// Quiet omits the comments that mark where Stop, StopMatch
+	// and Limit cut each symbol short.
+	Quiet bool
+	// SumByFile appends the instruction count and size per
+	// source file.
+	SumByFile bool
+	// GroupByFile writes a "// ==== file.go ====" header before
+	// each run of a symbol's instructions from the same source
+	// file. The instructions are not reordered.
+	GroupByFile bool
+	// Labels emits a label before each instruction that is the
+	// target of a direct branch in the input and rewrites the
+	// branches to use it, so that llvm-mca can see loops. Only
+	// the targets that are kept are labeled; branches to the
+	// others keep their address.
+	Labels bool
+	// Limit, if positive, is the maximum number of instructions
+	// kept from each symbol.
+	Limit int
+	// Summary appends the instruction count and size of each
+	// symbol and, if there are several, their total.
+	Summary bool
+	// Moves appends a report of redundant-looking
+	// register-to-register moves.
+	Moves bool
+	// ContextSym, if set, prefixes each instruction with its
+	// enclosing symbol's name or mangled name.
+	ContextSym string
+	// OnUnknown is how to handle instructions that could not
+	// be decoded: UnknownComment (or empty), UnknownSkip or
+	// UnknownError. The undecoded bytes of C prologues that
+	// can be reassembled are not unknown.
+	OnUnknown string
+	// AsmOut, if non-nil, is also written the instructions as
+	// assembly for the system assembler, to check that they
+	// reassemble to the same bytes: a .text directive, each
+	// symbol as a .globl label, the GNU assembly without
+	// comments, the labels of branch targets as with Labels,
+	// and undecoded bytes as .byte data, unless OnUnknown is
+	// UnknownSkip. The instructions are selected as for w.
+	AsmOut io.Writer
+	// Split, if non-nil, is called with the label of each symbol,
+	// without the colon, for a writer to write the symbol to
+	// instead of w, which Fix closes once the symbol is written
+	// (but not if Fix fails). Anything outside the symbols, like
+	// summaries, is still written to w. Each writer has one
+	// symbol, so Regions and AutoRegions are ignored.
+	Split func(name string) (io.WriteCloser, error)
+	// Progress, if non-nil, is called as the input is read with
+	// the number of TEXT symbols and instructions read so far:
+	// at each symbol, every 10000 instructions, and at the end.
+	Progress func(symbols, insns int)
+	// Strict fails on lines that cannot be parsed instead of
+	// skipping them with a warning, and on instructions whose
+	// encodings are implausibly long or short for Arch instead
+	// of warning about them.
+	Strict bool
+	// Verbose warns about each line that could not be parsed,
+	// with the reason, besides the summary of how many were.
+	Verbose bool
+	// Warn, if non-nil, is called with each warning, like the
+	// number of lines that were skipped because they could not be
+	// parsed. Otherwise warnings are discarded.
+	Warn func(msg string)
+	// Source keeps the Go source lines that "go tool objdump -S"
+	// prints before their instructions as comments. Otherwise
+	// they are skipped. They are always skipped with Canonical,
+	// Dedupe, Since, or output other than assembly.
+	Source bool
+	// Debug adds each instruction's input line as a trailing
+	// comment, quoted, and comments the lines that were skipped
+	// because they could not be parsed with the reason.
+	Debug bool
+	// Color colors each instruction's mnemonic, file:line, and
+	// encoding with ANSI escape sequences.
+	Color bool
+	// CollapseRet emits every instruction, like StopNone, and
+	// numbers each symbol's returns.
+	CollapseRet bool
+	// Energy adds each instruction's approximate relative
+	// energy cost and appends each symbol's total.
+	Energy bool
+	// DiffJSON writes a diffReport of the differences from
+	// Since instead of assembly.
+	DiffJSON bool
+	// Symbols, if non-nil, only emits the symbols whose names
+	// match it.
+	Symbols *regexp.Regexp
+	// Legend begins the output with a comment block that
+	// explains each column.
+	Legend bool
+	// JSON emits a JSON array of JSONSymbols instead of
+	// assembly.
+	JSON bool
+	// NDJSON emits one JSON insnRecord per line for each
+	// instruction instead of assembly.
+	NDJSON bool
+	// CSV emits one CSV row per instruction, after a header
+	// row, instead of assembly.
+	CSV bool
+	// BytesOnly emits the encodings of each symbol's
+	// instructions in hex, one line per symbol, instead of
+	// assembly, with BytesSep between instructions.
+	BytesOnly bool
+	BytesSep  string
+	// Canonical emits a form of the output that only changes
+	// when the instructions change, for diffing and golden
+	// files. It
+	//
+	//   - strips the file:line, offset, encoding, and Go assembly
+	//     columns,
+	//   - strips the source path from each symbol's label,
+	//   - collapses whitespace in the GNU assembly to single
+	//     spaces and does not align columns,
+	//   - rewrites direct branch and call targets to the target's
+	//     symbol (from the Go assembly) or to an offset from the
+	//     start of the current symbol ("main.f+0x1c"),
+	//   - rewrites PC-relative addresses (x86 %rip displacements
+	//     and arm64 adr/adrp operands) to the referenced symbol
+	//     from the Go assembly, or removes them if there is none.
+	Canonical bool
+
+	// written, if non-nil, is set to the offsets of the labels
+	// of branch targets that Fix writes, for Labels.
+	written map[uint64]bool
+}
+
+const (
+	// DialectGNU emits assembly as printed by objdump.
+	DialectGNU = "gnu"
+	// DialectLLVM rewrites assembly that LLVM's assembly parser
+	// does not accept.
+	DialectLLVM = "llvm"
+)
+
+const (
+	// UnknownComment replaces each instruction that could not
+	// be decoded with a comment.
+	UnknownComment = "comment"
+	// UnknownSkip drops instructions that could not be decoded.
+	UnknownSkip = "skip"
+	// UnknownError fails on the first instruction that could
+	// not be decoded.
+	UnknownError = "error"
+)
+
+const (
+	// StopNone emits every instruction in each TEXT symbol,
+	// including any returns in the middle of a function.
+	StopNone = "none"
+	// StopRet stops each symbol at its first return, which
+	// drops everything after an early return.
+	StopRet = "ret"
+)
+
+// Fix reads "go tool objdump -gnu" output (or another of the
+// formats that DetectFormat knows) from r and writes it to w as
+// assembly that llvm-mca accepts.
+func (c Config) Fix(w io.Writer, r io.Reader) error {
+	if c.Canonical {
+		c.File, c.Offset, c.Instr, c.GoAsm = false, false, false, false
+		c.ContextSym = ""
+		c.Symtab = nil
+	}
+	if c.CollapseRet {
+		c.Stop = StopNone
+	}
+	if c.Split != nil {
+		c.Regions, c.AutoRegions = false, false
+	}
+	// labels are the offsets of the branch targets to label,
+	// and asmw, if non-nil, writes c.AsmOut, which is always
+	// labeled.
+	var (
+		labels map[uint64]bool
+		asmw   *asmWriter
+	)
+	if c.Labels || c.AsmOut != nil {
+		in, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		all := branchLabels(in, c.Format, c.Arch)
+		r = bytes.NewReader(in)
+		if c.Labels && c.written == nil {
+			// Only label the targets that are kept, which
+			// depends on every option that selects
+			// instructions, so find them by fixing the input
+			// without the output. A branch to a target that
+			// is not kept keeps its address, as there would
+			// be no label for it.
+			//
+			// If the input cannot be fixed, this fails at the
+			// same line as below, which labels the targets
+			// before it.
+			dry := c
+			dry.AsmOut, dry.Split, dry.Progress, dry.Warn = nil, nil, nil, nil
+			dry.written = make(map[uint64]bool)
+			dry.Fix(ioutil.Discard, bytes.NewReader(in))
+			labels = dry.written
+		} else if c.Labels {
+			labels = all
+		}
+		if c.AsmOut != nil {
+			asmw = newAsmWriter(c.AsmOut, all)
+		}
+	}
+	// nd, if non-nil, writes the NDJSON records. Each record is
+	// a single write, so a reader sees it as soon as it is read.
+	var (
+		nd    *json.Encoder
+		ndErr error
+	)
+	if c.NDJSON {
+		nd = json.NewEncoder(w)
+		w = ioutil.Discard
+	}
+	// bw, if non-nil, is written the encodings for c.BytesOnly,
+	// and bytesN counts those of the current symbol.
+	var (
+		bw     *bufio.Writer
+		bytesN int
+	)
+	if c.BytesOnly {
+		bw = bufio.NewWriter(w)
+		w = ioutil.Discard
+	}
+	// cw, if non-nil, writes the CSV rows.
+	var cw *csv.Writer
+	if c.CSV {
+		cw = csv.NewWriter(w)
+		cw.Write(csvHeader)
+		w = ioutil.Discard
+	}
+	// diffs, if non-nil, collects the diff of each symbol with
+	// c.since, which is written in place of the assembly.
+	var (
+		diffs    *diffReport
+		diffed   = make(map[string]bool)
+		diffJSON = w
+	)
+	if c.DiffJSON {
+		diffs = new(diffReport)
+		w = ioutil.Discard
+	}
+	// syms, if non-nil, collects the symbols for c.json.
+	var (
+		syms    []JSONSymbol
+		symsOut = w
+	)
+	if c.JSON {
+		syms = []JSONSymbol{}
+		w = ioutil.Discard
+	}
+	// sw, if non-nil, switches the output between w and symOut,
+	// the writer of the current symbol for c.Split.
+	var (
+		sw     *switchWriter
+		symOut io.WriteCloser
+	)
+	if c.Split != nil {
+		sw = &switchWriter{base: w, w: w}
+		w = sw
+	}
+	// colw, if non-nil, colors the laid out output.
+	var colw *colorWriter
+	if c.Color {
+		colw = &colorWriter{
+			w:          w,
+			cols:       c.metaOrder(),
+			sep:        c.MetaSep,
+			instrSep:   c.InstrSep,
+			contextSym: c.ContextSym != "",
+		}
+		w = colw
+	}
+	// hw, if non-nil, holds the output of the first symbol until
+	// it is known whether there are more, and so whether it needs
+	// region markers.
+	var hw *holdWriter
+	if c.AutoRegions && !c.Regions {
+		hw = &holdWriter{w: w}
+		w = hw
+	}
+	minwidth, tabwidth, padding := c.MinWidth, c.TabWidth, c.Padding
+	if tabwidth == 0 {
+		minwidth, tabwidth, padding = 18, 8, 1
+	}
+	var ww *wrapWriter
+	if c.WrapWidth > 0 {
+		ww = &wrapWriter{w: w, width: c.WrapWidth, tabwidth: tabwidth}
+		w = ww
+	}
+	var aw *alignWriter
+	padchar := byte('\t')
+	if c.AlignComments {
+		aw = &alignWriter{w: w, tabwidth: tabwidth}
+		w = aw
+		padchar = ' '
+	}
+	var tw flushWriter
+	if c.Raw {
+		tw = rawWriter{w}
+	} else {
+		if c.EscapeOff {
+			w = unescapeWriter{w}
+		}
+		tw = &escapeWriter{
+			flushWriter: tabwriter.NewWriter(w, minwidth, tabwidth, padding, padchar, 0),
+			keep:        c.EscapeOff,
+		}
+	}
+	// flushWriters flushes the writers that wrap w.
+	flushWriters := func() error {
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if aw != nil {
+			if err := aw.Flush(); err != nil {
+				return err
+			}
+		}
+		if ww != nil {
+			if err := ww.Flush(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// sym is the current TEXT symbol.
+	var sym string
+	// start is the offset of sym's first instruction, if
+	// started.
+	var (
+		start   uint64
+		started bool
+	)
+	// starts are the offsets of the first instructions of the
+	// symbols emitted so far, so that a symbol that objdump
+	// prints twice is emitted once, and dup is set while
+	// skipping such a symbol.
+	var (
+		starts = make(map[uint64]bool)
+		dup    bool
+	)
+	// opened is set once sym's label has been written, and
+	// opened labels are the labels written so far. The label of
+	// a symbol whose label was already written is only written
+	// at its first instruction, if it is not a duplicate.
+	var (
+		opened       bool
+		openedLabels = make(map[string]bool)
+	)
+	// symLabels are the labels of the symbols seen so far, and
+	// taken and renamed are the labels in use and those that
+	// were given a suffix because another symbol's name mangles
+	// to the same label.
+	var (
+		symLabels = make(map[string]string)
+		taken     = make(map[string]bool)
+		renamed   = make(map[string]bool)
+	)
+	label := func(name string) string {
+		// The flags and sizes of a TEXT line in Go assembly
+		// syntax are written as a comment by open, not mangled
+		// into the label.
+		if _, _, ok := textFrame(name); c.Canonical || ok {
+			name = TextName(name)
+		}
+		if l, ok := symLabels[name]; ok {
+			return l
+		}
+		l := Mangle(name)
+		for i := 1; taken[l]; i++ {
+			l = fmt.Sprintf("%s_%d:", strings.TrimSuffix(Mangle(name), ":"), i)
+			renamed[l] = true
+		}
+		symLabels[name] = l
+		taken[l] = true
+		return l
+	}
+	files := make(fileSums)
+	var sizes symSums
+	moves := moveFinder{arch: c.Arch}
+	spills := spillCounts{arch: c.Arch}
+	// rets is the number of returns in sym emitted so far.
+	var rets int
+	// file is the source file of sym's last instruction emitted
+	// so far, for c.GroupByFile.
+	var file string
+	// kept is the number of sym's instructions kept so far,
+	// for c.limit.
+	var kept int
+	// begun is set once sym's instructions have reached
+	// c.StartMatch.
+	var begun bool
+	// emit writes l, marking it if it was added since c.since.
+	// meta are the metadata columns of each instruction's
+	// comment.
+	meta := c.metaOrder()
+	// writeLabel writes the label of the branch target at off.
+	writeLabel := func(off uint64) {
+		fmt.Fprintf(tw, "%s:\n", labelName(off))
+		if c.written != nil {
+			c.written[off] = true
+		}
+	}
+	emit := func(l Line, added bool) {
+		if syms != nil {
+			s := &syms[len(syms)-1]
+			s.Instructions = append(s.Instructions, newJSONLine(l, c))
+			return
+		}
+		if nd != nil {
+			if ndErr == nil {
+				ndErr = nd.Encode(newInsnRecord(l, TextName(sym), added))
+			}
+			return
+		}
+		if cw != nil {
+			cw.Write(csvRecord(l, strings.TrimSuffix(label(sym), ":")))
+			return
+		}
+		if bw != nil {
+			if bytesN > 0 {
+				bw.WriteString(c.BytesSep)
+			}
+			bw.WriteString(formatInstr(l.Instr, c.InstrSep))
+			bytesN++
+			return
+		}
+		if c.GroupByFile && l.File != "" && l.File != file {
+			fmt.Fprintf(tw, "// ==== %s ====\n", l.File)
+			file = l.File
+		}
+		if labels[l.Offset] {
+			writeLabel(l.Offset)
+		}
+		if l.IsBranch() && l.HasTarget && labels[l.Target] {
+			l.GNUAsm = labelAsm(l)
+		}
+		mark := ""
+		if added {
+			mark = "+ "
+		}
+		switch c.ContextSym {
+		case "name":
+			fmt.Fprintf(tw, "%s%s\t%s", mark, TextName(sym), l.GNUAsm)
+		case "mangled":
+			fmt.Fprintf(tw, "%s%s\t%s", mark, strings.TrimSuffix(label(sym), ":"), l.GNUAsm)
+		default:
+			if mark == "" {
+				mark = "  "
+			}
+			fmt.Fprintf(tw, "%s%s", mark, l.GNUAsm)
+		}
+		var target string
+		if c.Symtab != nil && l.HasTarget {
+			target, _ = c.Symtab.describe(l.Target, TextName(sym))
+		}
+		if c.File || c.Offset || c.Instr || c.GoAsm || target != "" || c.Energy || (c.CollapseRet && l.IsReturn()) || c.Debug {
+			slash := false
+			printf := func(format string, args ...interface{}) {
+				switch {
+				case !slash:
+					format = "\t// " + format
+					slash = true
+				case c.MetaSep != "":
+					format = c.MetaSep + format
+				default:
+					format = "\t" + format
+				}
+				fmt.Fprintf(tw, format, args...)
+			}
+			for _, col := range meta {
+				printf("%s", c.metaValue(col, l))
+			}
+			if target != "" {
+				printf("-> %s", target)
+			}
+			if c.Energy {
+				printf("~%.1f", c.Arch.energy(l))
+			}
+			if c.CollapseRet && l.IsReturn() {
+				rets++
+				printf("return point %d", rets)
+			}
+			if c.Debug {
+				printf("raw: %q", l.raw)
+			}
+		}
+		fmt.Fprint(tw, "\n")
+	}
+	// pending are sym's instructions and their keys, buffered
+	// until the end of sym to compare them with c.since.
+	var (
+		pending     []Line
+		pendingKeys []string
+	)
+	var counts asmCounts
+	// energy and energyN are sym's total energy and
+	// instruction count.
+	var (
+		energy  float64
+		energyN int
+	)
+	flush := func() {
+		if c.Dedupe {
+			counts.write(tw)
+		}
+		if diffs != nil && sym != "" && !dup && !diffed[symKey(sym)] {
+			diffed[symKey(sym)] = true
+			diffs.add(c.Since.diff(sym, pendingKeys, pending))
+			pending, pendingKeys = pending[:0], pendingKeys[:0]
+		}
+		if len(pending) > 0 {
+			added := c.Since.added(sym, pendingKeys)
+			for i, l := range pending {
+				emit(l, added[i])
+			}
+			pending, pendingKeys = pending[:0], pendingKeys[:0]
+		}
+		if c.Energy && energyN > 0 {
+			writeEnergy(tw, sym, energy, energyN)
+			energy, energyN = 0, 0
+		}
+		if bytesN > 0 {
+			bw.WriteString("\n")
+			bytesN = 0
+		}
+	}
+	// endSplit closes the writer of the current symbol for
+	// c.Split, once it has been flushed.
+	endSplit := func() error {
+		if symOut == nil {
+			return nil
+		}
+		err := symOut.Close()
+		symOut, sw.w = nil, sw.base
+		return err
+	}
+	var open func() error
+	text := func(name string) error {
+		flush()
+		if sym != "" || sw != nil {
+			// Write out the previous symbol so that output
+			// streams and only one symbol is buffered at a time.
+			// Labels end the tabwriter's column blocks anyway, so
+			// this does not change the alignment.
+			if err := flushWriters(); err != nil {
+				return err
+			}
+			if err := endSplit(); err != nil {
+				return err
+			}
+		}
+		if hw != nil && sym != "" {
+			// The second symbol: put the first in a region.
+			begin := fmt.Sprintf("# LLVM-MCA-BEGIN %s\n", strings.TrimSuffix(label(sym), ":"))
+			if err := hw.release(begin); err != nil {
+				return err
+			}
+			hw, c.Regions = nil, true
+		}
+		if c.Regions && opened {
+			fmt.Fprint(tw, "# LLVM-MCA-END\n")
+		}
+		sym = name
+		started, dup, opened = false, false, false
+		rets, kept = 0, 0
+		file = ""
+		begun = false
+		moves.reset()
+		if openedLabels[label(sym)] {
+			return nil
+		}
+		return open()
+	}
+	// open writes the label of sym, to its own writer if
+	// c.Split is set.
+	open = func() error {
+		opened, openedLabels[label(sym)] = true, true
+		if c.Split != nil {
+			f, err := c.Split(strings.TrimSuffix(label(sym), ":"))
+			if err != nil {
+				return err
+			}
+			symOut, sw.w = f, f
+		}
+		if c.Regions {
+			fmt.Fprintf(tw, "# LLVM-MCA-BEGIN %s\n", strings.TrimSuffix(label(sym), ":"))
+		}
+		if renamed[label(sym)] {
+			fmt.Fprintf(tw, "// %s\n", TextName(sym))
+		}
+		if frame, args, ok := textFrame(sym); ok {
+			if args != "" {
+				fmt.Fprintf(tw, "// frame %s, args %s\n", frame, args)
+			} else {
+				fmt.Fprintf(tw, "// frame %s\n", frame)
+			}
+		}
+		fmt.Fprintf(tw, "%s\n", label(sym))
+		if asmw != nil {
+			asmw.symbol(label(sym))
+		}
+		if syms != nil {
+			syms = append(syms, JSONSymbol{
+				Symbol:       strings.TrimSuffix(label(sym), ":"),
+				Instructions: []JSONLine{},
+			})
+		}
+		return nil
+	}
+
+	// unknown handles instructions that could not be decoded
+	// according to c.onUnknown.
+	unknown := func(u []Line, err error) error {
+		if len(u) == 0 {
+			return nil
+		}
+		switch c.OnUnknown {
+		case UnknownError:
+			return err
+		case UnknownSkip:
+			return nil
+		}
+		for _, l := range u {
+			if asmw != nil {
+				asmw.undecoded(l)
+			}
+			fmt.Fprintf(tw, "\t// undecoded %#x: %s\n", l.Offset, formatInstr(l.Instr, c.InstrSep))
+		}
+		return nil
+	}
+
+	// undecoded are the bytes of a possible C prologue
+	// instruction that the disassembler could not decode, and
+	// undecodedErr is the error for the first of them.
+	var (
+		undecoded    []Line
+		undecodedErr error
+	)
+	// skipping is set while reading a symbol that does not
+	// match c.symbols or after its first stop.
+	var skipping bool
+	// unparsed counts the lines skipped because they could not
+	// be parsed, and unparsedErr is the error for the first.
+	var (
+		unparsed    int
+		unparsedErr error
+	)
+	// unparsable handles the line t, number n, that could not be
+	// parsed.
+	unparsable := func(t string, n int, err error) error {
+		err = atLine(err, n)
+		if c.Strict {
+			return err
+		}
+		if unparsed == 0 {
+			unparsedErr = err
+		}
+		if c.Verbose {
+			c.warnf("skipped %v", err)
+		}
+		unparsed++
+		if c.Debug {
+			reason := err.Error()
+			if se, ok := err.(*SyntaxError); ok {
+				reason = "syntax error: " + se.Msg
+			}
+			fmt.Fprintf(tw, "\t// raw: %q (%s)\n", t, reason)
+		}
+		return nil
+	}
+	// data are the lines of the current symbol before its first
+	// instruction that hold bytes but no instruction. If it has
+	// no instructions, it is a data symbol.
+	var data []dataLine
+	// undata handles the lines in data like any other, now that
+	// the symbol turned out to have instructions.
+	undata := func() error {
+		for _, d := range data {
+			var err error
+			if d.undecoded {
+				err = unknown([]Line{d.l}, d.err)
+			} else {
+				err = unparsable(d.t, d.n, d.err)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		data = nil
+		return nil
+	}
+	// endData writes the bytes of the current symbol if it is a
+	// data symbol.
+	endData := func() {
+		if len(data) == 0 {
+			return
+		}
+		fmt.Fprintf(tw, "// data symbol %s: %s\n", TextName(sym), dataHex(data, undecoded, c.InstrSep))
+		data, undecoded = nil, nil
+	}
+	// nsyms and ninsns count the symbols and instructions read,
+	// for c.Progress.
+	var nsyms, ninsns int
+	stops := c.StopAt
+	if stops == nil {
+		stops = c.Arch.stopAt()
+	}
+	format := c.Format
+	var p lineParser
+	// bufio.ScanLines drops the \r of CRLF line endings, so dumps
+	// saved on Windows parse like any other.
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		t := s.Text()
+		if format == nil && strings.TrimSpace(t) != "" {
+			format = DetectFormat(t)
+			if format == WasmObjdumpFormat || format == LLVMObjdumpFormat {
+				// Neither has source positions or Go assembly.
+				c.File, c.GoAsm = false, false
+				meta = c.metaOrder()
+				if colw != nil {
+					colw.cols = meta
+				}
+			}
+		}
+		if c.Legend && format != nil {
+			writeLegend(tw, c)
+			c.Legend = false
+		}
+		if format == nil || format.Skip(t) {
+			continue
+		}
+		if name, ok := format.Symbol(t); ok {
+			nsyms++
+			if c.Progress != nil {
+				c.Progress(nsyms, ninsns)
+			}
+			endData()
+			if err := unknown(undecoded, undecodedErr); err != nil {
+				return err
+			}
+			undecoded = nil
+			if skipping = c.Symbols != nil && !c.Symbols.MatchString(TextName(name)); !skipping {
+				if err := text(name); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if skipping {
+			continue
+		}
+		l, err := p.split(format, t)
+		if err != nil && format == GoObjdumpFormat && isSourceLine(t) {
+			if c.Source && sym != "" && !c.Canonical && !c.Dedupe && c.Since == nil &&
+				syms == nil && nd == nil && cw == nil {
+				fmt.Fprintf(tw, "  // %s\n", strings.ReplaceAll(strings.TrimSpace(t), "\t", " "))
+			}
+			continue
+		}
+		if err != nil {
+			u, ok := splitUndecoded(t)
+			if ok && isCPrologue(append(undecoded, u)) {
+				if len(undecoded) == 0 {
+					undecodedErr = err
+				}
+				undecoded = append(undecoded, u)
+				continue
+			}
+			if sym != "" && !started {
+				d := dataLine{l: u, undecoded: ok, t: t, n: n, err: err}
+				if !ok {
+					d.l, ok = splitData(t)
+				}
+				if ok {
+					// Perhaps a data symbol.
+					for _, u := range undecoded {
+						data = append(data, dataLine{l: u, undecoded: true, err: undecodedErr})
+					}
+					undecoded = nil
+					data = append(data, d)
+					continue
+				}
+			}
+			if err := undata(); err != nil {
+				return err
+			}
+			if err := unknown(undecoded, undecodedErr); err != nil {
+				return err
+			}
+			undecoded = nil
+			if !ok {
+				if err := unparsable(t, n, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := unknown([]Line{u}, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if ninsns++; c.Progress != nil && ninsns%progressInsns == 0 {
+			c.Progress(nsyms, ninsns)
+		}
+		if err := undata(); err != nil {
+			return err
+		}
+		if len(undecoded) > 0 {
+			if j, ok := joinPrologue(undecoded, l); ok {
+				l = j
+			} else if follows(undecoded, l) && isCPrologue(append(undecoded, l)) {
+				// The bogus tail of endbr32.
+				undecoded = append(undecoded, l)
+				continue
+			} else if err := unknown(undecoded, undecodedErr); err != nil {
+				return err
+			}
+			undecoded = nil
+		}
+		if c.Debug {
+			l.raw = t
+		}
+		if l.GNUAsm == "(bad)" {
+			// The disassembler decoded the instruction, but
+			// not its GNU syntax.
+			err := syntaxErr("undecodable instruction", t)
+			if err := unknown([]Line{l}, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.Arch.checkLen(len(l.Instr)); err != nil && l.GNUAsm != "" {
+			if c.Strict {
+				return atLine(syntaxErr(err.Error(), strings.TrimSpace(t)), n)
+			}
+			c.warnf("line %d: instruction at %#x may be misparsed: %v", n, l.Offset, err)
+		}
+		if c.MaxLineLen > 0 && len(l.GNUAsm) > c.MaxLineLen {
+			c.warnf("line %d: %d-character instruction at %#x may be misparsed: %s",
+				n, len(l.GNUAsm), l.Offset, strings.TrimSpace(t))
+		}
+		if l.GNUAsm == "" {
+			// The continuation of a long wasm-objdump encoding,
+			// which is dropped from the instruction's encoding.
+			continue
+		}
+		if sym == "" {
+			if c.Headerless == "" {
+				return fmt.Errorf("input does not begin with a TEXT line (%s)", t)
+			}
+			if err := text(c.Headerless); err != nil {
+				return err
+			}
+		}
+		c.Arch.Classify(&l)
+		if !started {
+			start, started = l.Offset, true
+			if starts[start] {
+				dup, skipping = true, true
+				fmt.Fprintf(tw, "// duplicate %s skipped\n", TextName(sym))
+				if opened && syms != nil {
+					syms = syms[:len(syms)-1]
+				}
+				continue
+			}
+			starts[start] = true
+			if !opened {
+				if err := open(); err != nil {
+					return err
+				}
+			}
+		}
+		var key string
+		if c.Since != nil {
+			key = sinceKey(l, sym, start)
+		}
+		if c.Dialect == DialectLLVM && format == GoObjdumpFormat {
+			l.GNUAsm = c.Arch.llvmAsm(l.GNUAsm)
+		}
+		if c.Normalize {
+			l.GNUAsm = c.Arch.normalize(l.GNUAsm)
+		}
+		// asm is the assembly for c.AsmOut, which cannot be
+		// canonical.
+		asm := l.GNUAsm
+		if c.Canonical {
+			l.GNUAsm = canonicalAsm(l, sym, start)
+		}
+		if c.StartMatch != nil && !begun {
+			if begun = c.StartMatch.MatchString(l.GNUAsm); !begun {
+				if labels[l.Offset] && c.Since == nil && !c.Dedupe {
+					// Keep the label for the branches to l.
+					writeLabel(l.Offset)
+				}
+				if asmw != nil {
+					asmw.drop(l)
+				}
+				continue
+			}
+		}
+		if (c.Stop == StopRet && l.isStop(stops)) || (c.StopMatch != nil && c.StopMatch.MatchString(l.GNUAsm)) {
+			// Skip the rest of sym.
+			skipping = true
+			if !c.KeepStop {
+				flush()
+				text := c.StopComment
+				if text == "" {
+					text = "stopping at"
+				}
+				switch {
+				case c.Quiet:
+				case c.Canonical:
+					fmt.Fprintf(tw, "  // %s %s\n", text, l.GNUAsm)
+				default:
+					fmt.Fprintf(tw, "\t// %s %s\n", text, l.GNUAsm)
+				}
+				continue
+			}
+		}
+		if (c.Encoding != nil && !c.Encoding.match(l.Instr)) ||
+			(c.Addr != nil && !c.Addr.contains(l.Offset)) ||
+			(c.Filter != nil && !c.Filter.MatchString(l.GNUAsm)) ||
+			(c.Exclude != nil && c.Exclude.MatchString(l.GNUAsm)) {
+			if labels[l.Offset] && c.Since == nil && !c.Dedupe {
+				// Keep the label for the branches to l.
+				writeLabel(l.Offset)
+			}
+			if asmw != nil {
+				asmw.drop(l)
+			}
+			continue
+		}
+		if c.Limit > 0 && kept == c.Limit {
+			flush()
+			switch {
+			case c.Quiet:
+			case c.Canonical:
+				fmt.Fprintf(tw, "  // ... truncated (limit %d)\n", c.Limit)
+			default:
+				fmt.Fprintf(tw, "\t// ... truncated (limit %d)\n", c.Limit)
+			}
+			skipping = true
+			continue
+		}
+		kept++
+		if asmw != nil {
+			asmw.insn(l, asm)
+		}
+		if c.Energy {
+			energy += c.Arch.energy(l)
+			energyN++
+		}
+		files.add(l)
+		if c.Summary {
+			sizes.add(sym, l)
+		}
+		if c.Moves {
+			moves.add(sym, l)
+		}
+		if c.CheckSpills {
+			spills.add(sym, l)
+		}
+		if c.Dedupe {
+			counts.add(l.GNUAsm)
+			continue
+		}
+		if c.Since != nil {
+			pending = append(pending, l)
+			pendingKeys = append(pendingKeys, key)
+			continue
+		}
+		emit(l, false)
+	}
+	if c.Progress != nil {
+		c.Progress(nsyms, ninsns)
+	}
+	if unparsed > 0 {
+		if c.Verbose {
+			c.warnf("skipped %d lines that could not be parsed (use -strict to fail instead)", unparsed)
+		} else {
+			c.warnf("skipped %d lines that could not be parsed (use -strict to fail instead, or -v to list them); the first was %v",
+				unparsed, unparsedErr)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	endData()
+	if err := unknown(undecoded, undecodedErr); err != nil {
+		return err
+	}
+	flush()
+	if c.Regions && opened {
+		fmt.Fprint(tw, "# LLVM-MCA-END\n")
+	}
+	if sw != nil {
+		if err := flushWriters(); err != nil {
+			return err
+		}
+		if err := endSplit(); err != nil {
+			return err
+		}
+	}
+	if c.SumByFile {
+		files.write(tw)
+	}
+	if c.Summary {
+		sizes.write(tw)
+	}
+	if c.Moves {
+		moves.write(tw)
+	}
+	if ndErr != nil {
+		return ndErr
+	}
+	if bw != nil {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+	if cw != nil {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+	if syms != nil {
+		enc := json.NewEncoder(symsOut)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(syms); err != nil {
+			return err
+		}
+	}
+	if diffs != nil {
+		for _, d := range c.Since.missing(diffed) {
+			diffs.add(d)
+		}
+		enc := json.NewEncoder(diffJSON)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(diffs); err != nil {
+			return err
+		}
+	}
+	if err := flushWriters(); err != nil {
+		return err
+	}
+	if hw != nil {
+		if err := hw.release(""); err != nil {
+			return err
+		}
+	}
+	if colw != nil {
+		if err := colw.Flush(); err != nil {
+			return err
+		}
+	}
+	if asmw != nil {
+		if err := asmw.Flush(); err != nil {
+			return err
+		}
+	}
+	if c.CheckSpills {
+		return spills.check(c.MaxSpills)
+	}
+	return nil
+}
+
+// canonicalAsm returns l's GNU assembly with its whitespace
+// collapsed and any direct branch target rewritten to be
+// independent of where the linker placed the code.
+//
+// sym is the enclosing TEXT symbol and start is the offset of its
+// first instruction.
+func canonicalAsm(l Line, sym string, start uint64) string {
+	s := strings.Join(strings.Fields(l.GNUAsm), " ")
+	gosym, hasSym := goAsmSym(l.GoAsm)
+	switch m := l.mnemonic(); {
+	case l.HasTarget:
+		target := l.TargetSym
+		if target == "" {
+			target = fmt.Sprintf("%s+%#x", TextName(sym), l.Target-start)
+			if l.Target < start {
+				target = fmt.Sprintf("%s-%#x", TextName(sym), start-l.Target)
+			}
+		}
+		// The target is always the last operand.
+		i := strings.LastIndexAny(s, ", ")
+		return s[:i+1] + target
+	case m == "adr" || m == "adrp":
+		i := strings.LastIndexByte(s, ',')
+		if i < 0 {
+			return s
+		}
+		if !hasSym {
+			return s[:i]
+		}
+		// The address operand may be missing, or not follow a
+		// space.
+		return s[:i+1] + " " + gosym
+	default:
+		return ripRel.ReplaceAllLiteralString(s, gosym+"(%rip)")
+	}
+}
+
+// ripRel matches an x86 RIP-relative memory operand.
+var ripRel = regexp.MustCompile(`-?0x[0-9a-f]+\(%rip\)`)
+
+// goAsmSym returns the symbol operand ("runtime.memmove(SB)") in
+// the Go assembly s, if any.
+func goAsmSym(s string) (string, bool) {
+	for _, f := range strings.Fields(s) {
+		f = strings.TrimSuffix(f, ",")
+		if strings.HasSuffix(f, "(SB)") {
+			f = strings.TrimSuffix(f, "(SB)")
+			return strings.TrimLeft(f, "$"), true
+		}
+	}
+	return "", false
+}
+
+// Line is one line of output from "go tool objdump".
+//
+// It matches
+//
+//	blake2b_arm64.s:334	0xfbf40			f94007e0		MOVD 8(RSP), R0                      // ldr x0, [sp,#8]
+//	blake2b_arm64.s:335	0xfbf44			f94013e1		MOVD 32(RSP), R1                     // ldr x1, [sp,#32]
+type Line struct {
+	File   string
+	Line   int
+	Offset uint64
+	Instr  []byte
+	GoAsm  string
+	GNUAsm string
+
+	// The following are set by Arch.Classify, which Parse and
+	// ParseLine call with a nil Arch.
+
+	kind      insnKind
+	Target    uint64 // branch or call target, if HasTarget
+	HasTarget bool   // the target is known
+	// TargetSym is the symbol that the disassembler resolved the
+	// target of a direct branch or call to, like
+	// "runtime.morestack_noctxt.abi0", if any. It distinguishes
+	// "CALL runtime.f(SB)" from a call to a bare address.
+	TargetSym string
+
+	raw string // the input line, for Config.Debug
+}
+
+// isSourceLine reports whether t is a line of Go source that
+// "go tool objdump -S" prints before the instructions compiled
+// from it: one without an 0x offset in the position's or the
+// offset's place.
+//
+// objdump indents each instruction with two spaces, which gofmt'd
+// source never is, so malformed instructions are not mistaken
+// for source and can fail Strict.
+func isSourceLine(t string) bool {
+	if strings.HasPrefix(t, "  ") {
+		return false
+	}
+	f := strings.Fields(t)
+	for i := 0; i < len(f) && i < 2; i++ {
+		if !strings.HasPrefix(f[i], "0x") {
+			continue
+		}
+		if _, rest, err := readHexUint(f[i][len("0x"):]); err == nil && rest == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// formatInstr returns the encoding b in hex, with sep between
+// its bytes.
+func formatInstr(b []byte, sep string) string {
+	if sep == "" {
+		return hex.EncodeToString(b)
+	}
+	var s strings.Builder
+	for i, c := range b {
+		if i > 0 {
+			s.WriteString(sep)
+		}
+		fmt.Fprintf(&s, "%02x", c)
+	}
+	return s.String()
+}
+
+// ParseLine parses one instruction line of "go tool objdump"
+// output, and classifies it as Arch.Classify does with a nil Arch.
+func ParseLine(s string) (Line, error) {
+	var p lineParser
+	l, err := p.parseLine(s)
+	if err != nil {
+		return Line{}, err
+	}
+	(*Arch)(nil).Classify(&l)
+	return l, nil
+}
+
+// lineParser parses instruction lines, decoding their encodings
+// into a shared buffer so that each line does not allocate.
+//
+// The encodings of the lines it returns stay valid: the buffer is
+// only appended to, and a new one is allocated when it fills.
+type lineParser struct {
+	buf []byte
+}
+
+// maxParseBuf is the size of lineParser's buffer once it has
+// grown.
+const maxParseBuf = 4096
+
+// split parses the instruction line t of format.
+func (p *lineParser) split(format *InputFormat, t string) (Line, error) {
+	if format == GoObjdumpFormat {
+		return p.parseLine(t)
+	}
+	return format.Split(t)
+}
+
+// decodeHex decodes the leading hex digits of s, returning them
+// and the rest of s.
+func (p *lineParser) decodeHex(s string) ([]byte, string, error) {
+	i := 0
+	for i < len(s) && isHex(s[i]) {
+		i++
+	}
+	if i%2 != 0 {
+		return nil, "", hex.ErrLength
+	}
+	n := i / 2
+	if n == 0 {
+		return []byte{}, s, nil
+	}
+	if cap(p.buf)-len(p.buf) < n {
+		size := 2 * cap(p.buf)
+		if size > maxParseBuf {
+			size = maxParseBuf
+		}
+		if size < n {
+			size = n
+		}
+		p.buf = make([]byte, 0, size)
+	}
+	j := len(p.buf)
+	for k := 0; k < i; k += 2 {
+		p.buf = append(p.buf, unhex(s[k])<<4|unhex(s[k+1]))
+	}
+	return p.buf[j:len(p.buf):len(p.buf)], s[i:], nil
+}
+
+// unhex returns the value of the hex digit c.
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// trimLeftSpace returns s without its leading spaces and tabs.
+func trimLeftSpace(s string) string {
+	i := 0
+	for i < len(s) && isSpace(s[i]) {
+		i++
+	}
+	return s[i:]
+}
+
+func (p *lineParser) parseLine(s string) (Line, error) {
+	orig := s
+	s = strings.TrimSpace(s)
+
+	// The position is the innermost frame's file:line. For PCs
+	// without a position, like the padding between functions,
+	// objdump prints ":-1". Use the last colon so that file
+	// names with colons are kept whole.
+	//
+	// With -S, objdump prints the source instead of positions.
+	i := strings.IndexAny(s, " \t")
+	if i < 0 {
+		i = len(s)
+	}
+	var (
+		file string
+		num  int
+	)
+	if pos := s[:i]; !strings.HasPrefix(pos, "0x") {
+		j := strings.LastIndexByte(pos, ':')
+		if j < 0 {
+			return Line{}, syntaxErr("missing colon in file name", orig)
+		}
+		file = pos[:j]
+		n, rest, err := readInt(pos[j+1:])
+		if err != nil {
+			return Line{}, err
+		}
+		if rest != "" {
+			return Line{}, syntaxErr("invalid line number", orig)
+		}
+		num = n
+		s = s[i:]
+	}
+
+	// s was trimmed above, so only its start needs trimming.
+	s = trimLeftSpace(s)
+	if !strings.HasPrefix(s, "0x") {
+		return Line{}, syntaxErr("missing 0x prefix for offset", orig)
+	}
+	s = strings.TrimPrefix(s, "0x")
+	off, s, err := readHexUint(s)
+	if err != nil {
+		return Line{}, err
+	}
+
+	s = trimLeftSpace(s)
+	instr, s, err := p.decodeHex(s)
+	if err != nil {
+		return Line{}, err
+	}
+
+	s = trimLeftSpace(s)
+	i = commentIndex(s)
+	if i < 0 {
+		return Line{}, syntaxErr("missing GNU assembly comments", orig)
+	}
+	// goAsm is empty for instructions that objdump cannot
+	// print as Go assembly.
+	goAsm := strings.TrimRight(s[:i], " \t")
+	gnuAsm := strings.TrimSpace(s[i+len("// "):])
+
+	return Line{
+		File:   file,
+		Line:   num,
+		Offset: off,
+		Instr:  instr,
+		GoAsm:  goAsm,
+		GNUAsm: gnuAsm,
+	}, nil
+}
+
+// readInt reads a decimal integer, which may be negative, from
+// the start of s.
+func readInt(s string) (int, string, error) {
+	i := 0
+	if strings.HasPrefix(s, "-") {
+		i++
+	}
+	for i < len(s) {
+		c := s[i]
+		if c < '0' || c > '9' {
+			break
+		}
+		i++
+	}
+	x, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, "", err
+	}
+	return x, s[i:], nil
+}
+
+func readHexUint(s string) (uint64, string, error) {
+	i := 0
+	for i < len(s) && isHex(s[i]) {
+		i++
+	}
+	x, err := strconv.ParseUint(s[:i], 16, 64)
+	if err != nil {
+		return 0, "", err
+	}
+	return x, s[i:], nil
+}
+
+func readHex(s string) ([]byte, string, error) {
+	i := 0
+	for i < len(s) && isHex(s[i]) {
+		i++
+	}
+	buf, err := hex.DecodeString(s[:i])
+	if err != nil {
+		return nil, "", err
+	}
+	return buf, s[i:], nil
+}
+
+func isHex(c byte) bool {
+	switch {
+	case '0' <= c && c <= '9':
+		return true
+	case 'a' <= c && c <= 'f':
+		return true
+	case 'A' <= c && c <= 'F':
+		return true
+	default:
+		return false
+	}
+}
+
+// commentIndex returns the index of the "// " that begins the
+// GNU assembly comment in the rest of an objdump line, s, or -1
+// if there is none.
+//
+// objdump separates the comment from the Go assembly with
+// whitespace, so a "// " inside an operand, like a string
+// constant, does not begin it.
+func commentIndex(s string) int {
+	for i := 0; ; {
+		j := strings.Index(s[i:], "// ")
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if i == 0 || s[i-1] == ' ' || s[i-1] == '\t' {
+			return i
+		}
+		i++
+	}
+}
+
+func syntaxErr(s, line string) error {
+	return &SyntaxError{Msg: s, Input: line}
+}
+
+// SyntaxError is the error for an input line that cannot be
+// parsed.
+type SyntaxError struct {
+	// N is the line's number, counting from 1, or 0 if unknown.
+	N int
+	// Input is the line.
+	Input string
+	// Msg is what is wrong with it.
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.N > 0 {
+		return fmt.Sprintf("line %d: syntax error: %s (%s)", e.N, e.Msg, e.Input)
+	}
+	return fmt.Sprintf("syntax error: %s (%s)", e.Msg, e.Input)
+}
+
+// atLine returns err, an error for line n of the input, with the
+// line number.
+func atLine(err error, n int) error {
+	var se *SyntaxError
+	if errors.As(err, &se) {
+		se.N = n
+		return err
+	}
+	return fmt.Errorf("line %d: %w", n, err)
+}
+
+// Mangle returns the label for the symbol s, with the characters
+// that assemblers reject replaced by underscores.
+func Mangle(s string) string {
+	return mangle(s) + ":"
+}
+
+// mangle replaces each byte of s that is not a letter, a digit, an
+// underscore, or a dollar sign with an underscore.
+//
+// Besides the punctuation in Go symbols, like "(*T).M", this
+// covers Windows paths ("C:\src\main.go"), "<autogenerated>"
+// source files, and symbols like "type:.eq.T".
+func mangle(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '_', c == '$':
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// flushWriter is a writer that may hold output until it is
+// flushed.
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// rawWriter is a flushWriter that writes through to its
+// writer, for Config.Raw.
+type rawWriter struct {
+	io.Writer
+}
+
+func (rawWriter) Flush() error {
+	return nil
+}
+
+// escapeWriter keeps 0xff bytes from reaching a tabwriter,
+// which reads each as tabwriter.Escape and so stops aligning
+// everything up to the next one. It drops them or, if keep is
+// set, writes them as NUL bytes for unescapeWriter to turn back
+// into 0xff once the columns are aligned. The input has no NUL
+// bytes of its own.
+type escapeWriter struct {
+	flushWriter
+	keep bool
+	buf  []byte
+}
+
+func (e *escapeWriter) Write(p []byte) (int, error) {
+	if bytes.IndexByte(p, tabwriter.Escape) < 0 {
+		return e.flushWriter.Write(p)
+	}
+	e.buf = e.buf[:0]
+	for _, b := range p {
+		switch {
+		case b != tabwriter.Escape:
+			e.buf = append(e.buf, b)
+		case e.keep:
+			e.buf = append(e.buf, 0)
+		}
+	}
+	if _, err := e.flushWriter.Write(e.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// unescapeWriter turns the NUL bytes written by escapeWriter
+// back into 0xff.
+type unescapeWriter struct {
+	w io.Writer
+}
+
+func (u unescapeWriter) Write(p []byte) (int, error) {
+	if bytes.IndexByte(p, 0) < 0 {
+		return u.w.Write(p)
+	}
+	q := bytes.ReplaceAll(p, []byte{0}, []byte{tabwriter.Escape})
+	if _, err := u.w.Write(q); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// progressInsns is how many instructions Fix reads between
+// calls to Config.Progress within a symbol.
+const progressInsns = 10000
+
+// switchWriter writes to w, which is either base or the writer
+// of the current symbol, for Config.Split.
+type switchWriter struct {
+	base, w io.Writer
+}
+
+func (s *switchWriter) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+// holdWriter holds everything written to it until it is
+// released.
+type holdWriter struct {
+	w        io.Writer
+	held     bytes.Buffer
+	released bool
+}
+
+func (h *holdWriter) Write(p []byte) (int, error) {
+	if h.released {
+		return h.w.Write(p)
+	}
+	return h.held.Write(p)
+}
+
+// release writes prefix and then the held output to the
+// underlying writer, to which later writes go directly.
+func (h *holdWriter) release(prefix string) error {
+	h.released = true
+	if _, err := io.WriteString(h.w, prefix); err != nil {
+		return err
+	}
+	_, err := h.w.Write(h.held.Bytes())
+	h.held.Reset()
+	return err
+}
+
+// writeLegend writes a comment block that explains each of the
+// optional parts of the lines that fix emits with cfg.
+func writeLegend(w io.Writer, cfg Config) {
+	var cols [][2]string
+	if cfg.Since != nil {
+		cols = append(cols, [2]string{"+", "the instruction is not in the -since baseline"})
+	}
+	switch cfg.ContextSym {
+	case "name":
+		cols = append(cols, [2]string{"symbol", "the instruction's TEXT symbol"})
+	case "mangled":
+		cols = append(cols, [2]string{"symbol", "the instruction's TEXT symbol, mangled like its label"})
+	}
+	if cfg.Dedupe {
+		cols = append(cols, [2]string{"count", "how many times the instruction occurs in the symbol"})
+	}
+	asm := "the GNU assembly, as printed by objdump"
+	switch {
+	case cfg.Canonical:
+		asm = "the GNU assembly, in canonical form"
+	case cfg.Dialect == DialectLLVM:
+		asm = "the GNU assembly, rewritten where llvm-mca rejects objdump's spelling"
+	}
+	cols = append(cols, [2]string{"assembly", asm})
+	if !cfg.Dedupe {
+		for _, col := range cfg.metaOrder() {
+			name, desc := metaLegend[col][0], metaLegend[col][1]
+			if cfg.MetaLabels {
+				name = metaLabels[col] + name
+			}
+			cols = append(cols, [2]string{name, desc})
+		}
+		if cfg.Symtab != nil {
+			cols = append(cols, [2]string{"-> target", "the symbol, or the offset in this symbol, that a branch or call targets"})
+		}
+		if cfg.Energy {
+			cols = append(cols, [2]string{"~energy", "the approximate energy cost, relative to an integer add"})
+		}
+		if cfg.CollapseRet {
+			cols = append(cols, [2]string{"return point N", "the Nth return in the symbol"})
+		}
+	}
+	width := 0
+	for _, c := range cols {
+		if len(c[0]) > width {
+			width = len(c[0])
+		}
+	}
+	fmt.Fprint(w, "// Columns:\n")
+	for _, c := range cols {
+		fmt.Fprintf(w, "//   %-*s  %s\n", width, c[0], c[1])
+	}
+}
+
+// warnf passes a warning to c.Warn, if it is set.
+func (c Config) warnf(format string, args ...interface{}) {
+	if c.Warn != nil {
+		c.Warn(fmt.Sprintf(format, args...))
+	}
+}
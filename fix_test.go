@@ -0,0 +1,396 @@
+package mca
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fix returns the output of c.Fix for in.
+func fix(t *testing.T, c Config, in string) string {
+	t.Helper()
+	var b strings.Builder
+	if err := c.Fix(&b, strings.NewReader(in)); err != nil {
+		t.Fatalf("Fix: %v", err)
+	}
+	return b.String()
+}
+
+// readFile returns the contents of the file at path.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+// noComment is a dump with a line that has no GNU assembly
+// comment, like the padding of some disassemblers.
+const noComment = "TEXT main.f(SB) /tmp/main.go\n" +
+	"  main.go:3\t\t0x1000\t\t\t31c0\t\t\tXORL AX, AX                          // xor %eax,%eax\n" +
+	"  main.go:3\t\t0x1002\t\t\tcc\t\t\tINT $3\n" +
+	"  main.go:4\t\t0x1003\t\t\tc3\t\t\tRET                                  // retq\n"
+
+func TestFixWarn(t *testing.T) {
+	var warnings []string
+	c := Config{Warn: func(msg string) { warnings = append(warnings, msg) }}
+	out := fix(t, c, noComment)
+	if !strings.Contains(out, "xor %eax,%eax") || !strings.Contains(out, "retq") {
+		t.Errorf("the lines around the one skipped are missing:\n%s", out)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "skipped 1 lines") {
+		t.Errorf("got warnings %q, want one about the skipped line", warnings)
+	}
+
+	warnings = nil
+	c.Verbose = true
+	fix(t, c, noComment)
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "line 3") {
+		t.Errorf("got warnings %q with Verbose, want the skipped line and the count", warnings)
+	}
+
+	// Without Warn, the warnings are dropped.
+	fix(t, Config{}, noComment)
+
+	c = Config{Strict: true}
+	err := c.Fix(&strings.Builder{}, strings.NewReader(noComment))
+	var se *SyntaxError
+	if !errors.As(err, &se) || se.N != 3 {
+		t.Errorf("Strict: got %v, want a syntax error on line 3", err)
+	}
+}
+
+var (
+	labelDef = regexp.MustCompile(`(?m)^(L[0-9a-f]+):$`)
+	labelRef = regexp.MustCompile(`(?m)^\s+b\S*\s.*\b(L[0-9a-f]+)\s`)
+)
+
+func TestFixLabels(t *testing.T) {
+	in := readFile(t, "testdata/dump_arm64.txt")
+	tests := []struct {
+		name string
+		c    Config
+		// label is a label that must be written, or "".
+		label string
+	}{
+		{"all", Config{}, "L8d62c"},
+		{"stop", Config{Stop: StopRet}, "L8d5e0"},
+		{"limit", Config{Limit: 4}, ""},
+		{"symbols", Config{Symbols: regexp.MustCompile(`^main\.find$`), Stop: StopRet}, "L8d60c"},
+		{"start", Config{StartMatch: regexp.MustCompile(`^cmp`)}, "L8d5ec"},
+		{"filter", Config{Exclude: regexp.MustCompile(`^cmp`)}, "L8d5ec"},
+	}
+	for _, tc := range tests {
+		tc.c.Arch, tc.c.Labels = archARM64, true
+		out := fix(t, tc.c, in)
+		defs := make(map[string]bool)
+		for _, m := range labelDef.FindAllStringSubmatch(out, -1) {
+			defs[m[1]] = true
+		}
+		// Every branch to a label has one to branch to, which
+		// llvm-mca needs.
+		for _, m := range labelRef.FindAllStringSubmatch(out, -1) {
+			if !defs[m[1]] {
+				t.Errorf("%s: branch to %s, which is not written:\n%s", tc.name, m[1], out)
+			}
+		}
+		if tc.label != "" && !defs[tc.label] {
+			t.Errorf("%s: %s is not written:\n%s", tc.name, tc.label, out)
+		}
+	}
+
+	// Branches to targets that are not kept keep their address.
+	out := fix(t, Config{Arch: archARM64, Labels: true, Stop: StopRet}, in)
+	if !strings.Contains(out, "b.le .+0x18") {
+		t.Errorf("the branch past main.find's first return was rewritten:\n%s", out)
+	}
+}
+
+// largeDump returns n copies of the symbols of the dump at path,
+// each renamed and moved to its own addresses so that none is a
+// duplicate, for benchmarks.
+func largeDump(b *testing.B, path string, n int) []byte {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+	var out bytes.Buffer
+	for i := 0; i < n; i++ {
+		for _, t := range lines {
+			if strings.HasPrefix(t, "TEXT ") {
+				t = strings.Replace(t, "(SB)", fmt.Sprintf("%d(SB)", i), 1)
+			} else if f := strings.SplitN(t, "\t", 4); len(f) == 4 {
+				if off, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimLeft(f[2], "\t"), "0x"), 16, 64); err == nil {
+					t = strings.Replace(t, f[2], fmt.Sprintf("%#x", off+uint64(i)<<16), 1)
+				}
+			}
+			out.WriteString(t)
+			out.WriteByte('\n')
+		}
+	}
+	return out.Bytes()
+}
+
+func BenchmarkFix(b *testing.B) {
+	in := largeDump(b, "testdata/dump_amd64.txt", 2000)
+	c := Config{File: true, Offset: true, GoAsm: true}
+	b.SetBytes(int64(len(in)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.Fix(ioutil.Discard, bytes.NewReader(in)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseLine(b *testing.B) {
+	const line = "  main.go:23\t\t0x48310e\t\t48c744242801000000\tMOVQ $0x1, 0x28(SP)                  // movq $0x1,0x28(%rsp)\t"
+	var p lineParser
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.parseLine(line); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestFixHeaderless(t *testing.T) {
+	// The dump begins in the middle of main.find.
+	in := readFile(t, "testdata/headerless_arm64.txt")
+	err := (Config{}).Fix(ioutil.Discard, strings.NewReader(in))
+	if err == nil || !strings.Contains(err.Error(), "does not begin with a TEXT line") {
+		t.Errorf("without Headerless: got %v, want an error about the missing TEXT line", err)
+	}
+
+	const want = `# LLVM-MCA-BEGIN sliced
+sliced:
+  add x2, x2, #0x1
+  cmp x1, x2
+  b.le sliced+0x20
+  ldr x4, [x0,x2,lsl #3]
+  cmp x3, x4
+  b.ne sliced+0x0
+  mov x0, x2
+  ret
+  mov x0, #0xffffffffffffffff
+# LLVM-MCA-END
+# LLVM-MCA-BEGIN main_main
+main_main:
+  ldr x16, [x28,#16]
+  cmp sp, x16
+  b.ls main.main+0x68
+# LLVM-MCA-END
+`
+	c := Config{Arch: archARM64, Headerless: "sliced", AutoRegions: true, Canonical: true}
+	if got := fix(t, c, in); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFixEscape(t *testing.T) {
+	// tabwriter reads 0xff as tabwriter.Escape, so an unpaired
+	// one must not stop the columns after it from being aligned.
+	const dump = "TEXT main.f(SB) /tmp/main.go\n" +
+		"  main.go:3\t\t0x1000\t\t\t90\t\t\tNOP                                  // nop # \"a%sb\"\n" +
+		"  main.go:4\t\t0x1001\t\t\tc3\t\t\tRET                                  // retq\n"
+	in, plain := fmt.Sprintf(dump, "\xff"), fmt.Sprintf(dump, "")
+	c := Config{File: true, GoAsm: true}
+	want := fix(t, c, plain)
+	if got := fix(t, c, in); got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+
+	c.EscapeOff = true
+	want = strings.Replace(want, `"ab"`, "\"a\xffb\"", 1)
+	if got := fix(t, c, in); got != want {
+		t.Errorf("EscapeOff: got\n%q\nwant\n%q", got, want)
+	}
+
+	c.Raw = true
+	if got := fix(t, c, in); !strings.Contains(got, "nop # \"a\xffb\"\t") {
+		t.Errorf("Raw: 0xff is not kept:\n%q", got)
+	}
+}
+
+// parseLineTests are lines of amd64 "go tool objdump -gnu" output,
+// whose AT&T operands have commas, parentheses, and '%', '$' and
+// '*' prefixes.
+var parseLineTests = []struct {
+	line   string
+	file   string
+	num    int
+	off    uint64
+	instr  string
+	goAsm  string
+	gnuAsm string
+}{
+	{"  type.go:703\t\t0x401457\t\t660f1f840000000000\tNOPW 0(AX)(AX*1)                     // nopw (%rax,%rax)\t\t",
+		"type.go", 703, 0x401457, "660f1f840000000000", "NOPW 0(AX)(AX*1)", "nopw (%rax,%rax)"},
+	{"  type.go:324\t\t0x401180\t\tff24d1\t\t\tJMP 0(CX)(DX*8)                      // jmpq *(%rcx,%rdx,8)\t",
+		"type.go", 324, 0x401180, "ff24d1", "JMP 0(CX)(DX*8)", "jmpq *(%rcx,%rdx,8)"},
+	{"  bounds.go:110\t\t0x4010b6\t\t488d057b751500\t\tLEAQ 0x15757b(IP), AX                // lea 0x15757b(%rip),%rax\t\t",
+		"bounds.go", 110, 0x4010b6, "488d057b751500", "LEAQ 0x15757b(IP), AX", "lea 0x15757b(%rip),%rax"},
+	{"  mgc.go:1559\t\t0x427350\t\tf348ab\t\t\tREP; STOSQ AX, ES:0(DI)              // rep stos %rax,%es:(%rdi)\t\t",
+		"mgc.go", 1559, 0x427350, "f348ab", "REP; STOSQ AX, ES:0(DI)", "rep stos %rax,%es:(%rdi)"},
+	{"  types.go:37\t\t0x4114ea\t\tf00fb133\t\tLOCK CMPXCHGL SI, 0(BX)              // lock cmpxchg %esi,(%rbx)\t\t",
+		"types.go", 37, 0x4114ea, "f00fb133", "LOCK CMPXCHGL SI, 0(BX)", "lock cmpxchg %esi,(%rbx)"},
+	{"  compare_amd64.s:204\t0x408df1\t\tc5fe6f16\t\tVMOVDQU 0(SI), Y2                    // vmovdqu (%rsi),%ymm2\t\t",
+		"compare_amd64.s", 204, 0x408df1, "c5fe6f16", "VMOVDQU 0(SI), Y2", "vmovdqu (%rsi),%ymm2"},
+	{"  main.go:23\t\t0x48310e\t\t48c744242801000000\tMOVQ $0x1, 0x28(SP)                  // movq $0x1,0x28(%rsp)\t",
+		"main.go", 23, 0x48310e, "48c744242801000000", "MOVQ $0x1, 0x28(SP)", "movq $0x1,0x28(%rsp)"},
+	{"  :-1\t\t\t0x499ea0\t\tcc\t\t\tINT $0x3                             // int3\t",
+		"", -1, 0x499ea0, "cc", "INT $0x3", "int3"},
+}
+
+func TestParseLine(t *testing.T) {
+	for _, tc := range parseLineTests {
+		// objdump aligns its columns with tabs, but any run
+		// of whitespace separates them.
+		f := strings.Split(strings.TrimSpace(tc.line), "\t")
+		var fields []string
+		for _, s := range f {
+			if s != "" {
+				fields = append(fields, s)
+			}
+		}
+		for _, s := range []string{
+			tc.line,
+			strings.Join(fields, " "),
+			" \t " + strings.Join(fields, "  \t \t") + " \t",
+		} {
+			l, err := ParseLine(s)
+			if err != nil {
+				t.Errorf("ParseLine(%q): %v", s, err)
+				continue
+			}
+			if l.File != tc.file || l.Line != tc.num || l.Offset != tc.off ||
+				fmt.Sprintf("%x", l.Instr) != tc.instr || l.GoAsm != tc.goAsm || l.GNUAsm != tc.gnuAsm {
+				t.Errorf("ParseLine(%q) = %s:%d %#x %x %q %q, want %s:%d %#x %s %q %q", s,
+					l.File, l.Line, l.Offset, l.Instr, l.GoAsm, l.GNUAsm,
+					tc.file, tc.num, tc.off, tc.instr, tc.goAsm, tc.gnuAsm)
+			}
+		}
+	}
+}
+
+func TestFixLargeOffset(t *testing.T) {
+	// Offsets above 32 bits, as in binaries with large text or
+	// high load addresses.
+	const dump = "TEXT main.f(SB) /tmp/main.go\n" +
+		"  main.go:3\t\t0xffffffff00001000\t\teb0e\t\t\tJMP 0xffffffff00001010                // jmp 0xffffffff00001010\n" +
+		"  main.go:4\t\t0xffffffff00001002\t\t90\t\t\tNOP                                  // nop\n" +
+		"  main.go:5\t\t0xffffffff00001010\t\tc3\t\t\tRET                                  // retq\n"
+	syms, err := Parse(strings.NewReader(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := syms[0].Lines[0]
+	if l.Offset != 0xffffffff00001000 || !l.HasTarget || l.Target != 0xffffffff00001010 {
+		t.Errorf("got offset %#x and target %#x, %t, want 0xffffffff00001000 and 0xffffffff00001010",
+			l.Offset, l.Target, l.HasTarget)
+	}
+	out := fix(t, Config{Arch: archAMD64, Labels: true, Offset: true}, dump)
+	for _, s := range []string{"jmp Lffffffff00001010\t", "\nLffffffff00001010:\n", "// 0xffffffff00001002\n"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output does not contain %q:\n%s", s, out)
+		}
+	}
+
+	// arm64 targets are relative to the offset.
+	l, err = ParseLine("  main.go:3\t\t0xffffffff00001000\t14000004\t\tJMP 4(PC)                            // b .+0x10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archARM64.Classify(&l)
+	if l.Offset != 0xffffffff00001000 || l.Target != 0xffffffff00001010 {
+		t.Errorf("arm64: got offset %#x and target %#x, want 0xffffffff00001000 and 0xffffffff00001010", l.Offset, l.Target)
+	}
+}
+
+// crlf returns s with Windows line endings.
+func crlf(s string) string {
+	return strings.Replace(s, "\n", "\r\n", -1)
+}
+
+func TestFixCRLF(t *testing.T) {
+	in := readFile(t, "testdata/dump_amd64.txt")
+	for _, tc := range []struct {
+		name string
+		c    Config
+	}{
+		{"default", Config{}},
+		{"columns", Config{File: true, Offset: true, GoAsm: true}},
+		{"canonical", Config{Canonical: true, AutoRegions: true}},
+		{"labels", Config{Labels: true, Stop: StopRet}},
+		{"ndjson", Config{NDJSON: true}},
+		{"csv", Config{CSV: true}},
+	} {
+		tc.c.Arch = archAMD64
+		got := fix(t, tc.c, crlf(in))
+		if strings.Contains(got, "\r") {
+			t.Errorf("%s: \\r in the output:\n%q", tc.name, got)
+		}
+		if want := fix(t, tc.c, in); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.name, got, want)
+		}
+	}
+}
+
+func TestFixEncodingLength(t *testing.T) {
+	// Each line's encoding ran into the Go assembly after it:
+	// "cb" of CBZ on arm64, and the encoding of the next
+	// instruction on amd64.
+	for _, tc := range []struct {
+		arch *Arch
+		dump string
+		want string
+	}{
+		{archARM64, "TEXT main.f(SB) /tmp/main.go\n" +
+			"  main.go:3\t\t0x11120\t\t\tb4000144CBZ R4, 10(PC)                       // cbz x4, .+0x28\n",
+			"5-byte encoding, but arm64 instructions are 4 bytes"},
+		{archAMD64, "TEXT main.f(SB) /tmp/main.go\n" +
+			"  main.go:3\t\t0x401457\t\t660f1f840000000000660f1f840000000000\tNOPW 0(AX)(AX*1)                     // nopw (%rax,%rax)\n",
+			"18-byte encoding, but amd64 instructions are 1 to 15 bytes"},
+	} {
+		var warnings []string
+		c := Config{Arch: tc.arch, Warn: func(msg string) { warnings = append(warnings, msg) }}
+		fix(t, c, tc.dump)
+		if len(warnings) != 1 || !strings.Contains(warnings[0], tc.want) {
+			t.Errorf("%s: got warnings %q, want %q", tc.arch.Name, warnings, tc.want)
+		}
+
+		c.Strict = true
+		err := c.Fix(ioutil.Discard, strings.NewReader(tc.dump))
+		var se *SyntaxError
+		if !errors.As(err, &se) || se.N != 2 || se.Msg != tc.want {
+			t.Errorf("%s: Strict: got %v, want a syntax error on line 2", tc.arch.Name, err)
+		}
+
+		// Without an architecture, any length is accepted.
+		warnings = nil
+		fix(t, Config{Warn: c.Warn}, tc.dump)
+		if len(warnings) != 0 {
+			t.Errorf("%s: without Arch: got warnings %q", tc.arch.Name, warnings)
+		}
+	}
+
+	// The real dumps have no misparsed lines.
+	for _, tc := range parseTests {
+		c := Config{Strict: true, Warn: func(msg string) { t.Errorf("%s: %s", tc.file, msg) }}
+		c.Arch = archAMD64
+		if strings.Contains(tc.file, "arm64") {
+			c.Arch = archARM64
+		}
+		fix(t, c, readFile(t, tc.file))
+	}
+}
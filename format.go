@@ -0,0 +1,241 @@
+package mca
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// InputFormat is the format of a disassembler's output.
+type InputFormat struct {
+	// Name is the format's name, for messages.
+	Name string
+	// Symbol reports whether the line t starts a symbol and, if
+	// so, returns the symbol's name.
+	Symbol func(t string) (string, bool)
+	// Skip reports whether the line t has no instruction, like
+	// a file header.
+	Skip func(t string) bool
+	// Split parses an instruction line.
+	Split func(t string) (Line, error)
+}
+
+// GoObjdumpFormat is "go tool objdump -gnu" output.
+var GoObjdumpFormat = &InputFormat{
+	Name: "go tool objdump",
+	Symbol: func(t string) (string, bool) {
+		if !strings.HasPrefix(t, "TEXT ") {
+			return "", false
+		}
+		return strings.TrimPrefix(t, "TEXT "), true
+	},
+	// objdump separates symbols with blank lines.
+	Skip:  func(t string) bool { return strings.TrimSpace(t) == "" },
+	Split: ParseLine,
+}
+
+// WasmObjdumpFormat is "wasm-objdump -d" output, which looks
+// like
+//
+//	w.wasm:	file format wasm 0x1
+//
+//	Code Disassembly:
+//
+//	000f3a func[12] <main.f>:
+//	 000f3b: 02 7f                      | local[0..1] type=i32
+//	 000f3d: 20 00                      | local.get 0
+//	 000f3f: 0b                         | end
+//
+// Wasm has no source positions or Go assembly, so instructions
+// only have an offset, an encoding, and the instruction text.
+var WasmObjdumpFormat = &InputFormat{
+	Name: "wasm-objdump",
+	Symbol: func(t string) (string, bool) {
+		m := wasmFunc.FindStringSubmatch(t)
+		if m == nil {
+			return "", false
+		}
+		if m[2] != "" {
+			return m[2], true
+		}
+		return "func[" + m[1] + "]", true
+	},
+	Skip: func(t string) bool {
+		t = strings.TrimSpace(t)
+		return t == "" || t == "Code Disassembly:" || isWasmHeader(t)
+	},
+	Split: splitWasm,
+}
+
+// LLVMObjdumpFormat is "llvm-objdump -d" output, which looks
+// like
+//
+//	app:	file format elf64-x86-64
+//
+//	Disassembly of section .text:
+//
+//	0000000000499de0 <main.f>:
+//	  499de0: 48 89 44 24 08               	movq	%rax, 8(%rsp)
+//	  499de9: eb 06                        	jmp	0x499df1 <main.f+0x11>
+//
+// It has no source positions or Go assembly. The symbolized
+// targets and trailing comments that llvm-objdump adds are
+// dropped from the instruction text.
+var LLVMObjdumpFormat = &InputFormat{
+	Name: "llvm-objdump",
+	Symbol: func(t string) (string, bool) {
+		m := llvmFunc.FindStringSubmatch(t)
+		if m == nil {
+			return "", false
+		}
+		return m[1], true
+	},
+	Skip: func(t string) bool {
+		t = strings.TrimSpace(t)
+		return t == "" || t == "..." || strings.HasPrefix(t, "Disassembly of section ") ||
+			isLLVMHeader(t)
+	},
+	Split: splitLLVM,
+}
+
+// DetectFormat returns the format of input whose first
+// non-blank line is t.
+func DetectFormat(t string) *InputFormat {
+	if isWasmHeader(t) || wasmFunc.MatchString(t) {
+		return WasmObjdumpFormat
+	}
+	if isLLVMHeader(t) || llvmFunc.MatchString(t) {
+		return LLVMObjdumpFormat
+	}
+	return GoObjdumpFormat
+}
+
+var wasmFunc = regexp.MustCompile(`^[0-9a-f]+ func\[(\d+)\](?: <(.*)>)?:$`)
+
+// isWasmHeader reports whether t is the file header that
+// wasm-objdump prints first.
+func isWasmHeader(t string) bool {
+	return strings.Contains(t, "file format wasm")
+}
+
+var llvmFunc = regexp.MustCompile(`^[0-9a-f]+ <(.*)>:$`)
+
+// isLLVMHeader reports whether t is the file header that
+// llvm-objdump prints first.
+func isLLVMHeader(t string) bool {
+	return strings.Contains(t, "file format ") && !isWasmHeader(t)
+}
+
+// splitLLVM parses an llvm-objdump instruction line.
+func splitLLVM(s string) (Line, error) {
+	orig := s
+	s = strings.TrimSpace(s)
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return Line{}, syntaxErr("missing colon after offset", orig)
+	}
+	off, rest, err := readHexUint(s[:i])
+	if err != nil || rest != "" {
+		return Line{}, syntaxErr("invalid offset", orig)
+	}
+	s = s[i+1:]
+	j := strings.IndexByte(s, '\t')
+	if j < 0 {
+		return Line{}, syntaxErr("missing tab before instruction", orig)
+	}
+	instr, rest, err := readHex(strings.Join(strings.Fields(s[:j]), ""))
+	if err != nil || rest != "" {
+		return Line{}, syntaxErr("invalid encoding", orig)
+	}
+	asm := s[j+1:]
+	for _, sep := range []string{" # ", " // ", " <"} {
+		if k := strings.Index(asm, sep); k >= 0 {
+			asm = asm[:k]
+		}
+	}
+	asm = strings.Join(strings.Fields(asm), " ")
+	return Line{
+		Offset: off,
+		Instr:  instr,
+		GNUAsm: arm64Relative(asm, off),
+	}, nil
+}
+
+// arm64Relative rewrites the absolute target of an arm64
+// PC-relative instruction at offset off, like "b 0xa1fe8", to
+// the form that "go tool objdump -gnu" prints and LLVM's
+// assembler accepts, like "b .+0x18".
+func arm64Relative(s string, off uint64) string {
+	m, ops := SplitInsn(s)
+	switch {
+	case m == "b", m == "bl", strings.HasPrefix(m, "b."),
+		m == "cbz", m == "cbnz", m == "tbz", m == "tbnz",
+		m == "adr", m == "adrp":
+	default:
+		return s
+	}
+	i := strings.LastIndex(ops, "0x")
+	if i < 0 || (i > 0 && ops[i-1] != ' ') {
+		return s
+	}
+	target, rest, err := readHexUint(ops[i+len("0x"):])
+	if err != nil || rest != "" {
+		return s
+	}
+	return fmt.Sprintf("%s %s.+%#x", m, ops[:i], target-off)
+}
+
+// splitWasm parses a wasm-objdump instruction line.
+//
+// Long encodings continue on the next line with an empty
+// instruction; those lines parse with an empty gnuAsm.
+func splitWasm(s string) (Line, error) {
+	orig := s
+	s = strings.TrimSpace(s)
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return Line{}, syntaxErr("missing colon after offset", orig)
+	}
+	off, rest, err := readHexUint(s[:i])
+	if err != nil || rest != "" {
+		return Line{}, syntaxErr("invalid offset", orig)
+	}
+	s = s[i+1:]
+	j := strings.IndexByte(s, '|')
+	if j < 0 {
+		return Line{}, syntaxErr("missing | before instruction", orig)
+	}
+	instr, rest, err := readHex(strings.Join(strings.Fields(s[:j]), ""))
+	if err != nil || rest != "" {
+		return Line{}, syntaxErr("invalid encoding", orig)
+	}
+	return Line{
+		Offset: off,
+		Instr:  instr,
+		GNUAsm: strings.TrimSpace(s[j+1:]),
+	}, nil
+}
+
+// TextName returns the symbol name from the remainder of a TEXT
+// line, like "main.f(SB) /tmp/main.go".
+func TextName(s string) string {
+	if i := strings.Index(s, "(SB)"); i >= 0 {
+		return s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// textFrameRE matches the remainder of a TEXT line in Go assembly
+// syntax, like "foo(SB), NOSPLIT, $24-16".
+var textFrameRE = regexp.MustCompile(`^[^,]*\(SB\)\s*,(?:.*,)?\s*\$(-?\d+)(?:-(\d+))?\s*$`)
+
+// textFrame returns the frame and argument sizes from the
+// remainder of a TEXT line in Go assembly syntax. args is empty if
+// the line only has a frame size, like "foo(SB), NOSPLIT, $0".
+func textFrame(s string) (frame, args string, ok bool) {
+	m := textFrameRE.FindStringSubmatch(s)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
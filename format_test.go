@@ -0,0 +1,53 @@
+package mca
+
+import "testing"
+
+func TestTextFrame(t *testing.T) {
+	for _, tc := range []struct {
+		in          string
+		frame, args string
+		ok          bool
+	}{
+		{"foo(SB), NOSPLIT, $24-16", "24", "16", true},
+		{"runtime·memmove<ABIInternal>(SB), NOSPLIT|NOFRAME, $0-24", "0", "24", true},
+		{"main.f(SB),$0", "0", "", true},
+		{"main.f(SB), 7, $16-8", "16", "8", true},
+		{"main.f(SB), NOSPLIT, $-8", "-8", "", true},
+		{"main.f(SB), $24-16 \t", "24", "16", true},
+
+		// go tool objdump's TEXT lines have a source path
+		// instead.
+		{"main.f(SB) /tmp/main.go", "", "", false},
+		{"main.(*T).M(SB) C:/src/main.go", "", "", false},
+		{"main.f(SB), NOSPLIT", "", "", false},
+		{"main.f(SB), $x", "", "", false},
+		{"main.f, $24-16", "", "", false},
+	} {
+		frame, args, ok := textFrame(tc.in)
+		if frame != tc.frame || args != tc.args || ok != tc.ok {
+			t.Errorf("textFrame(%q) = %q, %q, %t, want %q, %q, %t",
+				tc.in, frame, args, ok, tc.frame, tc.args, tc.ok)
+		}
+	}
+}
+
+func TestFixTextFrame(t *testing.T) {
+	const in = "TEXT main.f(SB), NOSPLIT|NOFRAME, $24-16\n" +
+		"  main.go:3\t\t0x1000\t\t\t31c0\t\t\tXORL AX, AX                          // xor %eax,%eax\n" +
+		"TEXT main.g(SB), $0\n" +
+		"  main.go:4\t\t0x1004\t\t\tc3\t\t\tRET                                  // retq\n"
+	const want = `# LLVM-MCA-BEGIN main_f
+// frame 24, args 16
+main_f:
+  xor %eax,%eax
+# LLVM-MCA-END
+# LLVM-MCA-BEGIN main_g
+// frame 0
+main_g:
+  retq
+# LLVM-MCA-END
+`
+	if got := fix(t, Config{Arch: archAMD64, Regions: true}, in); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
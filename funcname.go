@@ -0,0 +1,39 @@
+package mca
+
+import (
+	"regexp"
+	"strings"
+)
+
+// FuncRegexp returns an anchored regexp for "go tool objdump -s"
+// that matches the symbol of the Go function name, like
+// "crypto/sha256.block".
+//
+// Methods may be written as "pkg.(*T).M" or "pkg.T.M". The
+// latter matches the method with either a pointer or a value
+// receiver. Generic functions match each of their
+// instantiations, like "pkg.F[go.shape.int]".
+func FuncRegexp(name string) string {
+	// The package path ends at the first dot after its last
+	// slash. If its last element has a dot, like yaml.v3, the
+	// rest still matches, as though it were a method.
+	dir, rest := "", name
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		dir, rest = name[:i+1], name[i+1:]
+	}
+	i := strings.IndexByte(rest, '.')
+	if i < 0 || strings.ContainsAny(rest, "[]") {
+		return "^" + regexp.QuoteMeta(name) + "$"
+	}
+	pkg, parts := dir+rest[:i], strings.Split(rest[i+1:], ".")
+	quoted := make([]string, len(parts))
+	for i, p := range parts {
+		quoted[i] = regexp.QuoteMeta(p)
+	}
+	if len(parts) > 1 && !strings.HasPrefix(parts[0], "(") {
+		// T.M: a method with a pointer or value receiver, or
+		// a closure in the function T.
+		quoted[0] = `(?:\(\*` + quoted[0] + `\)|` + quoted[0] + `)`
+	}
+	return "^" + regexp.QuoteMeta(pkg) + `\.` + strings.Join(quoted, `\.`) + `(?:\[.*\])?$`
+}
@@ -0,0 +1,38 @@
+package mca
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the testdata/*.golden files with the output")
+
+func TestGolden(t *testing.T) {
+	since, err := ReadBaseline("testdata/old_amd64.txt", archAMD64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		file string
+		c    Config
+	}{
+		{"canonical_amd64", "testdata/dump_amd64.txt", Config{Arch: archAMD64, Canonical: true, AutoRegions: true}},
+		{"canonical_arm64", "testdata/dump_arm64.txt", Config{Arch: archARM64, Canonical: true, AutoRegions: true}},
+		{"diffjson_amd64", "testdata/dump_amd64.txt", Config{Arch: archAMD64, Since: since, DiffJSON: true}},
+	} {
+		got := fix(t, tc.c, readFile(t, tc.file))
+		golden := filepath.Join("testdata", tc.name+".golden")
+		if *update {
+			if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if want := readFile(t, golden); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.name, got, want)
+		}
+	}
+}
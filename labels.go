@@ -0,0 +1,63 @@
+package mca
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// branchLabels returns the offsets of the instructions in the
+// disassembly in that are the targets of direct branches in it.
+//
+// If format is nil, it is detected from the first line.
+func branchLabels(in []byte, format *InputFormat, a *Arch) map[uint64]bool {
+	var (
+		offsets = make(map[uint64]bool)
+		targets []uint64
+		p       lineParser
+	)
+	s := bufio.NewScanner(bytes.NewReader(in))
+	for s.Scan() {
+		t := s.Text()
+		if format == nil && strings.TrimSpace(t) != "" {
+			format = DetectFormat(t)
+		}
+		if format == nil || format.Skip(t) {
+			continue
+		}
+		if _, ok := format.Symbol(t); ok {
+			continue
+		}
+		l, err := p.split(format, t)
+		if err != nil {
+			continue
+		}
+		a.Classify(&l)
+		offsets[l.Offset] = true
+		if l.IsBranch() && l.HasTarget {
+			targets = append(targets, l.Target)
+		}
+	}
+	labels := make(map[uint64]bool)
+	for _, t := range targets {
+		if offsets[t] {
+			labels[t] = true
+		}
+	}
+	return labels
+}
+
+// labelName returns the name of the label for the instruction at
+// offset off.
+func labelName(off uint64) string {
+	return fmt.Sprintf("L%x", off)
+}
+
+// labelAsm returns l's GNU assembly with its branch target
+// replaced by the target's label.
+func labelAsm(l Line) string {
+	// The target is always the last operand.
+	i := strings.LastIndexAny(l.GNUAsm, ", \t")
+	return l.GNUAsm[:i+1] + labelName(l.Target)
+}
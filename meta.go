@@ -0,0 +1,100 @@
+package mca
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The metadata columns of each instruction's trailing comment, for
+// Config.MetaOrder.
+const (
+	MetaFile   = "file"
+	MetaOffset = "offset"
+	MetaInstr  = "instr"
+	MetaGoAsm  = "goasm"
+)
+
+// metaColumns are the metadata columns in their default order.
+var metaColumns = []string{MetaFile, MetaOffset, MetaInstr, MetaGoAsm}
+
+// metaLabels are the labels of the metadata columns for
+// Config.MetaLabels.
+var metaLabels = map[string]string{
+	MetaFile:   "src=",
+	MetaOffset: "off=",
+	MetaInstr:  "instr=",
+	MetaGoAsm:  "go=",
+}
+
+// metaLegend are the names and descriptions of the metadata
+// columns for Config.Legend.
+var metaLegend = map[string][2]string{
+	MetaFile:   {"file:line", "the source position"},
+	MetaOffset: {"offset", "the instruction's address"},
+	MetaInstr:  {"encoding", "the encoded instruction, in hex"},
+	MetaGoAsm:  {"Go assembly", "the instruction as printed by go tool objdump"},
+}
+
+// CheckMetaOrder returns an error if order names a column that is
+// not a metadata column, or names one more than once.
+func CheckMetaOrder(order []string) error {
+	seen := make(map[string]bool)
+	for _, s := range order {
+		if _, ok := metaLabels[s]; !ok {
+			return fmt.Errorf("unknown metadata column %q (want %s)", s, strings.Join(metaColumns, ", "))
+		}
+		if seen[s] {
+			return fmt.Errorf("metadata column %q is listed more than once", s)
+		}
+		seen[s] = true
+	}
+	return nil
+}
+
+// metaOrder returns the metadata columns that c writes, in the
+// order that it writes them.
+func (c Config) metaOrder() []string {
+	on := map[string]bool{
+		MetaFile:   c.File,
+		MetaOffset: c.Offset,
+		MetaInstr:  c.Instr,
+		MetaGoAsm:  c.GoAsm,
+	}
+	var cols []string
+	for _, s := range append(c.MetaOrder[:len(c.MetaOrder):len(c.MetaOrder)], metaColumns...) {
+		if on[s] {
+			cols = append(cols, s)
+			on[s] = false
+		}
+	}
+	return cols
+}
+
+// metaValue returns the metadata column col of l.
+func (c Config) metaValue(col string, l Line) string {
+	var s string
+	switch col {
+	case MetaFile:
+		// Assembly input has no source position, and the
+		// padding after a symbol has none that is valid.
+		s = "-"
+		if l.File != "" && l.Line >= 0 {
+			s = fmt.Sprintf("%s:%d", l.File, l.Line)
+		}
+	case MetaOffset:
+		s = fmt.Sprintf("%#x", l.Offset)
+	case MetaInstr:
+		s = formatInstr(l.Instr, c.InstrSep)
+	case MetaGoAsm:
+		// An empty column would shift the columns after it,
+		// and leave a trailing tab.
+		s = l.GoAsm
+		if s == "" {
+			s = "-"
+		}
+	}
+	if c.MetaLabels {
+		s = metaLabels[col] + s
+	}
+	return s
+}
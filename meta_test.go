@@ -0,0 +1,61 @@
+package mca
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetaValue(t *testing.T) {
+	tests := []struct {
+		col  string
+		l    Line
+		want string
+	}{
+		{MetaFile, Line{File: "main.go", Line: 7}, "main.go:7"},
+		// Inputs without source positions, like wasm-objdump's.
+		{MetaFile, Line{}, "-"},
+		// Padding, which objdump prints as ":-1".
+		{MetaFile, Line{Line: -1}, "-"},
+		{MetaOffset, Line{Offset: 0x1000}, "0x1000"},
+		{MetaInstr, Line{Instr: []byte{0x31, 0xc0}}, "31c0"},
+		{MetaGoAsm, Line{GoAsm: "XORL AX, AX"}, "XORL AX, AX"},
+		// Instructions that objdump cannot print as Go assembly.
+		{MetaGoAsm, Line{}, "-"},
+	}
+	for _, tc := range tests {
+		if got := (Config{}).metaValue(tc.col, tc.l); got != tc.want {
+			t.Errorf("metaValue(%s, %+v) = %q, want %q", tc.col, tc.l, got, tc.want)
+		}
+	}
+	got := (Config{MetaLabels: true}).metaValue(MetaFile, Line{})
+	if want := "src=-"; got != want {
+		t.Errorf("metaValue with MetaLabels = %q, want %q", got, want)
+	}
+}
+
+func TestFixPadding(t *testing.T) {
+	const in = "TEXT go:textfipsstart(SB) \n" +
+		"  :-1\t\t\t0x499ea0\t\tcc\t\t\tINT $0x3                             // int3\t\n"
+	out := fix(t, Config{File: true, GoAsm: true}, in)
+	if want := "int3\t\t\t// -\t\t\tINT $0x3\n"; !strings.Contains(out, want) {
+		t.Errorf("got\n%s\nwant a line with %q", out, want)
+	}
+}
+
+func TestFixEmptyGoAsm(t *testing.T) {
+	const in = "TEXT main.f(SB) /tmp/main.go\n" +
+		"  main.go:3\t\t0x1000\t\t\t31c0\t\t\tXORL AX, AX                          // xor %eax,%eax\n" +
+		"  main.go:3\t\t0x1002\t\t\t0f0b\t\t\t// ud2\n" +
+		"  main.go:4\t\t0x1004\t\t\tc3\t\t\tRET                                  // retq\n"
+	syms, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := syms[0].Lines[1]; l.GoAsm != "" || l.GNUAsm != "ud2" {
+		t.Errorf("got Go assembly %q and GNU assembly %q, want \"\" and \"ud2\"", l.GoAsm, l.GNUAsm)
+	}
+	out := fix(t, Config{File: true, GoAsm: true}, in)
+	if want := "  ud2\t\t\t// main.go:3\t\t-\n"; !strings.Contains(out, want) {
+		t.Errorf("got\n%s\nwant a line with %q", out, want)
+	}
+}
@@ -0,0 +1,90 @@
+package mca
+
+import "encoding/hex"
+
+// insnRecord is one instruction in fix -ndjson output.
+type insnRecord struct {
+	// Symbol is the name of the instruction's TEXT symbol.
+	Symbol string `json:"symbol"`
+	// File and Line are the instruction's source position, if
+	// known.
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+	// Offset is the instruction's address.
+	Offset uint64 `json:"offset"`
+	// Encoding is the hex-encoded instruction.
+	Encoding string `json:"encoding"`
+	// GNU is the GNU assembly, rewritten as fix would emit it.
+	GNU string `json:"gnu"`
+	// Go is the Go assembly, if known.
+	Go string `json:"go,omitempty"`
+	// Kind is the instruction's control-flow kind, like
+	// "branch" or "call".
+	Kind string `json:"kind"`
+	// Target is the address of a direct branch or call.
+	Target *uint64 `json:"target,omitempty"`
+	// TargetSymbol is the symbol of Target, if the disassembler
+	// resolved it.
+	TargetSymbol string `json:"target_symbol,omitempty"`
+	// Added is set if the instruction is not in the -since
+	// baseline.
+	Added bool `json:"added,omitempty"`
+}
+
+func newInsnRecord(l Line, sym string, added bool) insnRecord {
+	r := insnRecord{
+		Symbol:   sym,
+		File:     l.File,
+		Line:     l.Line,
+		Offset:   l.Offset,
+		Encoding: hex.EncodeToString(l.Instr),
+		GNU:      l.GNUAsm,
+		Go:       l.GoAsm,
+		Kind:     l.kind.String(),
+		Added:    added,
+	}
+	if l.HasTarget {
+		target := l.Target
+		r.Target = &target
+	}
+	r.TargetSymbol = l.TargetSym
+	return r
+}
+
+// JSONSymbol is one TEXT symbol in fix -json output.
+type JSONSymbol struct {
+	// Symbol is the symbol's mangled name, as used for its
+	// label.
+	Symbol       string     `json:"symbol"`
+	Instructions []JSONLine `json:"instructions"`
+}
+
+// JSONLine is one instruction in fix -json output. The fields
+// other than GNUAsm are omitted unless the matching column is
+// enabled.
+type JSONLine struct {
+	File   string  `json:"file,omitempty"`
+	Line   int     `json:"line,omitempty"`
+	Offset *uint64 `json:"offset,omitempty"`
+	Instr  string  `json:"instr,omitempty"`
+	GoAsm  string  `json:"goAsm,omitempty"`
+	GNUAsm string  `json:"gnuAsm"`
+}
+
+func newJSONLine(l Line, c Config) JSONLine {
+	j := JSONLine{GNUAsm: l.GNUAsm}
+	if c.File {
+		j.File, j.Line = l.File, l.Line
+	}
+	if c.Offset {
+		off := l.Offset
+		j.Offset = &off
+	}
+	if c.Instr {
+		j.Instr = hex.EncodeToString(l.Instr)
+	}
+	if c.GoAsm {
+		j.GoAsm = l.GoAsm
+	}
+	return j
+}
@@ -0,0 +1,73 @@
+package mca
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Symbol is one TEXT symbol of disassembly.
+type Symbol struct {
+	// Name is the symbol's name, like "main.f".
+	Name string
+	// Lines are the symbol's instructions, in order.
+	Lines []Line
+}
+
+// Parse reads "go tool objdump -gnu" output (or another of the
+// formats that DetectFormat knows) from r and returns its
+// instructions grouped by TEXT symbol, in the order that they
+// appear.
+//
+// Unlike Fix, Parse does not rewrite the instructions. Each is
+// classified as Arch.Classify does with a nil Arch, so that its
+// branch kind and target are set. Those that the disassembler
+// could not decode have an empty GNUAsm. The source lines of
+// "go tool objdump -S" output are skipped, and other lines that
+// cannot be parsed are an error.
+func Parse(r io.Reader) ([]Symbol, error) {
+	var (
+		syms   []Symbol
+		format *InputFormat
+		p      lineParser
+	)
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		t := s.Text()
+		if format == nil && strings.TrimSpace(t) != "" {
+			format = DetectFormat(t)
+		}
+		if format == nil || format.Skip(t) {
+			continue
+		}
+		if name, ok := format.Symbol(t); ok {
+			syms = append(syms, Symbol{Name: TextName(name)})
+			continue
+		}
+		l, err := p.split(format, t)
+		if err != nil && format == GoObjdumpFormat && isSourceLine(t) {
+			continue
+		}
+		if err != nil {
+			u, ok := splitUndecoded(t)
+			if !ok {
+				return nil, atLine(err, n)
+			}
+			l = u
+		} else if l.GNUAsm == "" {
+			// The continuation of a long wasm-objdump encoding.
+			continue
+		}
+		if len(syms) == 0 {
+			return nil, fmt.Errorf("line %d: input does not begin with a TEXT line (%s)", n, t)
+		}
+		(*Arch)(nil).Classify(&l)
+		sym := &syms[len(syms)-1]
+		sym.Lines = append(sym.Lines, l)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return syms, nil
+}
@@ -0,0 +1,271 @@
+package mca
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseTests describe the symbols of the dumps in testdata.
+var parseTests = []struct {
+	file string
+	syms []wantSymbol
+}{
+	{"testdata/dump_amd64.txt", []wantSymbol{
+		{"main.sum", 12, 0x483080, 0x4830a1, 1, 0, 2, nil},
+		{"main.find", 13, 0x4830c0, 0x4830e5, 2, 0, 3, nil},
+		{"main.main", 27, 0x483100, 0x483170, 1, 0, 2, []string{"main.sum", "main.find", "os.Exit", "runtime.morestack_noctxt.abi0"}},
+	}},
+	{"testdata/dump_arm64.txt", []wantSymbol{
+		{"main.sum", 12, 0x8d5d0, 0x8d5fc, 1, 1, 2, nil},
+		{"main.find", 16, 0x8d600, 0x8d63c, 2, 3, 3, nil},
+		{"main.main", 32, 0x8d640, 0x8d6bc, 1, 3, 2, []string{"main.sum", "main.find", "os.Exit", "runtime.morestack_noctxt.abi0"}},
+	}},
+}
+
+// wantSymbol is a symbol as Parse should return it.
+type wantSymbol struct {
+	name        string
+	lines       int
+	first, last uint64 // the offsets of the first and last lines
+	rets        int
+	undecoded   int
+	branches    int
+	calls       []string // the symbols called, in order
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range parseTests {
+		f, err := os.Open(tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		syms, err := Parse(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.file, err)
+		}
+		if len(syms) != len(tc.syms) {
+			t.Fatalf("%s: got %d symbols, want %d", tc.file, len(syms), len(tc.syms))
+		}
+		for i, want := range tc.syms {
+			sym := syms[i]
+			if sym.Name != want.name {
+				t.Errorf("%s: symbol %d is %q, want %q", tc.file, i, sym.Name, want.name)
+				continue
+			}
+			if len(sym.Lines) != want.lines {
+				t.Errorf("%s: %s has %d lines, want %d", tc.file, sym.Name, len(sym.Lines), want.lines)
+				continue
+			}
+			first, last := sym.Lines[0].Offset, sym.Lines[len(sym.Lines)-1].Offset
+			if first != want.first || last != want.last {
+				t.Errorf("%s: %s spans %#x-%#x, want %#x-%#x", tc.file, sym.Name, first, last, want.first, want.last)
+			}
+			var (
+				rets, undecoded, branches int
+				calls                     []string
+				prev                      uint64
+			)
+			for j, l := range sym.Lines {
+				if j > 0 && l.Offset <= prev {
+					t.Errorf("%s: %s: offset %#x follows %#x", tc.file, sym.Name, l.Offset, prev)
+				}
+				prev = l.Offset
+				if l.File != "main.go" || l.Line < 5 {
+					t.Errorf("%s: %s: bad position %s:%d", tc.file, sym.Name, l.File, l.Line)
+				}
+				switch {
+				case l.GNUAsm == "":
+					undecoded++
+				case l.IsReturn():
+					rets++
+				case l.IsBranch():
+					branches++
+				case l.IsCall():
+					calls = append(calls, l.TargetSym)
+				}
+			}
+			if rets != want.rets || undecoded != want.undecoded || branches != want.branches {
+				t.Errorf("%s: %s has %d returns, %d undecoded and %d branches, want %d, %d and %d",
+					tc.file, sym.Name, rets, undecoded, branches, want.rets, want.undecoded, want.branches)
+			}
+			if !equalStrings(calls, want.calls) {
+				t.Errorf("%s: %s calls %q, want %q", tc.file, sym.Name, calls, want.calls)
+			}
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFixDump(t *testing.T) {
+	// Fix skips blank lines and lines that are neither TEXT
+	// lines nor instructions, and emits the same instructions as
+	// it would without them.
+	for _, tc := range parseTests {
+		in := readFile(t, tc.file)
+		syms, err := Parse(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		noisy := strings.Replace(in, "\nTEXT ", "\n\n \t\nDisassembly of section .text:\nTEXT ", -1)
+		out := fix(t, Config{NDJSON: true}, noisy)
+		got := make(map[string][]uint64)
+		var names []string
+		dec := json.NewDecoder(strings.NewReader(out))
+		for dec.More() {
+			var r insnRecord
+			if err := dec.Decode(&r); err != nil {
+				t.Fatalf("%s: %v", tc.file, err)
+			}
+			name := symKey(r.Symbol)
+			if len(got[name]) == 0 {
+				names = append(names, name)
+			}
+			got[name] = append(got[name], r.Offset)
+		}
+		if len(names) != len(tc.syms) {
+			t.Errorf("%s: got symbols %q, want %d", tc.file, names, len(tc.syms))
+		}
+		for i, want := range tc.syms {
+			name := mangle(want.name)
+			if i < len(names) && names[i] != name {
+				t.Errorf("%s: symbol %d is %s, want %s", tc.file, i, names[i], name)
+			}
+			var offs []uint64
+			for _, l := range syms[i].Lines {
+				if l.GNUAsm != "" {
+					offs = append(offs, l.Offset)
+				}
+			}
+			if len(got[name]) != want.lines-want.undecoded || fmt.Sprint(got[name]) != fmt.Sprint(offs) {
+				t.Errorf("%s: %s: got offsets %#x, want the %d of %#x", tc.file, name, got[name], want.lines-want.undecoded, offs)
+			}
+		}
+	}
+}
+
+func TestParseCRLF(t *testing.T) {
+	// A \r left on a line would end up in its assembly, where it
+	// hides returns, and in the labels of its symbol.
+	for _, tc := range parseTests {
+		in := readFile(t, tc.file)
+		want, err := Parse(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Parse(strings.NewReader(crlf(in)))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.file, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: CRLF input parses differently", tc.file)
+		}
+		for i, sym := range got {
+			if strings.Contains(Mangle(sym.Name), "\r") {
+				t.Errorf("%s: label %q", tc.file, Mangle(sym.Name))
+			}
+			rets := 0
+			for _, l := range sym.Lines {
+				if l.IsReturn() {
+					rets++
+				}
+			}
+			if rets != tc.syms[i].rets {
+				t.Errorf("%s: %s has %d returns, want %d", tc.file, sym.Name, rets, tc.syms[i].rets)
+			}
+		}
+	}
+
+	// Baselines saved with CRLF line endings compare equal.
+	path := filepath.Join(t.TempDir(), "old_amd64.txt")
+	if err := os.WriteFile(path, []byte(crlf(readFile(t, "testdata/old_amd64.txt"))), 0644); err != nil {
+		t.Fatal(err)
+	}
+	since, err := ReadBaseline(path, archAMD64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fix(t, Config{Arch: archAMD64, Since: since, DiffJSON: true}, readFile(t, "testdata/dump_amd64.txt"))
+	if want := readFile(t, "testdata/diffjson_amd64.golden"); got != want {
+		t.Errorf("CRLF baseline: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseInlined(t *testing.T) {
+	const (
+		a4  = "abs.go:4"
+		a5  = "abs.go:5"
+		m10 = "main.go:10"
+		m11 = "main.go:11"
+	)
+	// older and newer are the positions of norm's instructions
+	// as compiled by Go 1.17 and by Go 1.22 and later.
+	older := []string{a5, a5, a4, m11, a5, a5, a4, m11, m11, a4}
+	newer := []string{m10, a5, a5, a5, a5, a4, m11, a4, m11, m11, m11, a4}
+	for _, tc := range []struct {
+		file string
+		// syms are the positions of each symbol's
+		// instructions.
+		syms map[string][]string
+	}{
+		{"testdata/inline_go1.17.txt", map[string][]string{"main.norm": older}},
+		{"testdata/inline_go1.22.txt", map[string][]string{"main.norm": newer}},
+		{
+			"testdata/inline_go1.27.txt",
+			map[string][]string{
+				"main.norm":        newer,
+				"go:textfipsstart": {":-1"},
+				"go:textfipsend":   {":-1"},
+			},
+		},
+	} {
+		in := readFile(t, tc.file)
+		syms, err := Parse(strings.NewReader(in))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.file, err)
+		}
+		if len(syms) != len(tc.syms) {
+			t.Errorf("%s: got %d symbols, want %d", tc.file, len(syms), len(tc.syms))
+		}
+		for _, sym := range syms {
+			var got []string
+			for _, l := range sym.Lines {
+				got = append(got, fmt.Sprintf("%s:%d", l.File, l.Line))
+			}
+			if want := tc.syms[sym.Name]; !equalStrings(got, want) {
+				t.Errorf("%s: %s: got positions %q, want %q", tc.file, sym.Name, got, want)
+			}
+		}
+
+		// ParseLine extracts the same positions.
+		for _, s := range strings.Split(in, "\n") {
+			if !strings.HasPrefix(s, "  ") {
+				continue
+			}
+			l, err := ParseLine(s)
+			if err != nil {
+				t.Errorf("%s: ParseLine(%q): %v", tc.file, s, err)
+				continue
+			}
+			if want := strings.Fields(s)[0]; fmt.Sprintf("%s:%d", l.File, l.Line) != want {
+				t.Errorf("%s: ParseLine(%q) = %s:%d, want %s", tc.file, s, l.File, l.Line, want)
+			}
+		}
+	}
+}
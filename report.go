@@ -0,0 +1,216 @@
+package mca
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// fileSums counts the instructions and bytes that each source
+// file contributes to the output.
+type fileSums map[string]*fileSum
+
+type fileSum struct {
+	file   string
+	instrs int
+	bytes  int
+}
+
+func (s fileSums) add(l Line) {
+	f, ok := s[l.File]
+	if !ok {
+		f = &fileSum{file: l.File}
+		s[l.File] = f
+	}
+	f.instrs++
+	f.bytes += len(l.Instr)
+}
+
+// write writes the sums as a comment block, sorted by
+// descending instruction count.
+func (s fileSums) write(w io.Writer) {
+	sums := make([]*fileSum, 0, len(s))
+	for _, f := range s {
+		sums = append(sums, f)
+	}
+	sort.Slice(sums, func(i, j int) bool {
+		if sums[i].instrs != sums[j].instrs {
+			return sums[i].instrs > sums[j].instrs
+		}
+		return sums[i].file < sums[j].file
+	})
+	fmt.Fprint(w, "// instructions by file:\n")
+	for _, f := range sums {
+		fmt.Fprintf(w, "//   %s\t%d instrs\t%d bytes\n", f.file, f.instrs, f.bytes)
+	}
+}
+
+// symSums counts the instructions and bytes of each symbol in
+// the output, in order.
+type symSums []symSum
+
+type symSum struct {
+	sym    string
+	instrs int
+	bytes  int
+}
+
+func (s *symSums) add(sym string, l Line) {
+	name := TextName(sym)
+	if n := len(*s); n == 0 || (*s)[n-1].sym != name {
+		*s = append(*s, symSum{sym: name})
+	}
+	f := &(*s)[len(*s)-1]
+	f.instrs++
+	f.bytes += len(l.Instr)
+}
+
+// write writes the sums as a comment block, followed by their
+// total if there is more than one symbol.
+func (s symSums) write(w io.Writer) {
+	var instrs, bytes int
+	for _, f := range s {
+		fmt.Fprintf(w, "// %s: %d instrs, %d bytes\n", f.sym, f.instrs, f.bytes)
+		instrs += f.instrs
+		bytes += f.bytes
+	}
+	if len(s) > 1 {
+		fmt.Fprintf(w, "// total: %d instrs, %d bytes\n", instrs, bytes)
+	}
+}
+
+// moveFinder finds register-to-register moves that are likely
+// redundant: self moves, moves that undo the previous move, and
+// chains of moves through an intermediate register.
+type moveFinder struct {
+	arch  *Arch
+	cands []moveCand
+	// prev is the previous instruction, if it was a move.
+	prev    Line
+	prevSrc string
+	prevDst string
+	hasPrev bool
+}
+
+type moveCand struct {
+	sym  string
+	off  uint64
+	kind string
+	asm  string
+}
+
+// reset forgets the previous instruction, like at the start of
+// a new symbol.
+func (f *moveFinder) reset() {
+	f.hasPrev = false
+}
+
+func (f *moveFinder) add(sym string, l Line) {
+	src, dst, ok := f.arch.regMove(l)
+	if !ok {
+		f.hasPrev = false
+		return
+	}
+	switch {
+	case src == dst && !zeroExtends(dst):
+		f.cands = append(f.cands, moveCand{sym, l.Offset, "self move", l.GNUAsm})
+	case f.hasPrev && f.prevSrc == dst && f.prevDst == src:
+		f.cands = append(f.cands, moveCand{sym, l.Offset, "undoes previous move",
+			f.prev.GNUAsm + "; " + l.GNUAsm})
+	case f.hasPrev && f.prevDst == src:
+		f.cands = append(f.cands, moveCand{sym, f.prev.Offset, "move chain",
+			f.prev.GNUAsm + "; " + l.GNUAsm})
+	}
+	f.prev, f.prevSrc, f.prevDst, f.hasPrev = l, src, dst, true
+}
+
+// zeroExtends reports whether a move to the 32-bit register reg
+// zeroes the rest of the 64-bit register, so moving reg to
+// itself is not redundant.
+func zeroExtends(reg string) bool {
+	reg = strings.TrimPrefix(reg, "%")
+	switch {
+	case len(reg) == 3 && reg[0] == 'e':
+		// %eax
+		return true
+	case strings.HasPrefix(reg, "r") && strings.HasSuffix(reg, "d"):
+		// %r8d
+		return true
+	case strings.HasPrefix(reg, "w"):
+		// arm64 w0
+		return true
+	}
+	return false
+}
+
+func (f *moveFinder) write(w io.Writer) {
+	fmt.Fprint(w, "// redundant move candidates:\n")
+	for _, c := range f.cands {
+		fmt.Fprintf(w, "//   %s\t%#x\t%s\t%s\n", TextName(c.sym), c.off, c.kind, c.asm)
+	}
+}
+
+// spillCounts counts the spills and reloads in each symbol.
+type spillCounts struct {
+	arch  *Arch
+	syms  []string
+	count map[string]int
+}
+
+func (c *spillCounts) add(sym string, l Line) {
+	if c.arch.stackAccess(l) == stackNone {
+		return
+	}
+	if c.count == nil {
+		c.count = make(map[string]int)
+	}
+	if _, ok := c.count[sym]; !ok {
+		c.syms = append(c.syms, sym)
+	}
+	c.count[sym]++
+}
+
+// check returns an error listing the symbols with more than max
+// spills and reloads.
+func (c *spillCounts) check(max int) error {
+	var over []string
+	for _, sym := range c.syms {
+		if n := c.count[sym]; n > max {
+			over = append(over, fmt.Sprintf("%s (%d)", TextName(sym), n))
+		}
+	}
+	if len(over) == 0 {
+		return nil
+	}
+	return fmt.Errorf("more than %d spills and reloads in %s", max, strings.Join(over, ", "))
+}
+
+// asmCounts counts the occurrences of each distinct instruction.
+type asmCounts struct {
+	asms  []string
+	count map[string]int
+}
+
+func (c *asmCounts) add(asm string) {
+	asm = AsmKey(asm)
+	if c.count == nil {
+		c.count = make(map[string]int)
+	}
+	if _, ok := c.count[asm]; !ok {
+		c.asms = append(c.asms, asm)
+	}
+	c.count[asm]++
+}
+
+// write writes each distinct instruction and its count, sorted
+// by descending count, and resets c.
+func (c *asmCounts) write(w io.Writer) {
+	sort.SliceStable(c.asms, func(i, j int) bool {
+		return c.count[c.asms[i]] > c.count[c.asms[j]]
+	})
+	for _, asm := range c.asms {
+		fmt.Fprintf(w, "  %5d  %s\n", c.count[asm], asm)
+	}
+	c.asms, c.count = c.asms[:0], nil
+}
@@ -0,0 +1,174 @@
+package mca
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Sym is a function symbol.
+type Sym struct {
+	Name string
+	Addr uint64
+	Size uint64
+}
+
+// Symtab is a binary's table of function symbols.
+type Symtab struct {
+	syms []Sym // sorted by addr
+}
+
+// ReadSymtab reads the function symbols from the ELF, Mach-O,
+// or PE binary at path.
+func ReadSymtab(path string) (*Symtab, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var syms []Sym
+	if ef, err := elf.NewFile(f); err == nil {
+		syms, err = elfSyms(ef)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	} else if mf, err := macho.NewFile(f); err == nil {
+		syms = machoSyms(mf)
+	} else if pf, err := pe.NewFile(f); err == nil {
+		syms = peSyms(pf)
+	} else {
+		return nil, fmt.Errorf("%s: unrecognized binary format", path)
+	}
+	if len(syms) == 0 {
+		return nil, fmt.Errorf("%s: no symbol table", path)
+	}
+	sort.SliceStable(syms, func(i, j int) bool {
+		return syms[i].Addr < syms[j].Addr
+	})
+	// Mach-O and PE do not record symbol sizes, so infer them
+	// from the next symbol.
+	for i := range syms {
+		if syms[i].Size == 0 && i+1 < len(syms) {
+			syms[i].Size = syms[i+1].Addr - syms[i].Addr
+		}
+	}
+	return &Symtab{syms: syms}, nil
+}
+
+// Lookup returns the symbol containing addr.
+func (t *Symtab) Lookup(addr uint64) (Sym, bool) {
+	i := sort.Search(len(t.syms), func(i int) bool {
+		return t.syms[i].Addr > addr
+	})
+	if i == 0 {
+		return Sym{}, false
+	}
+	s := t.syms[i-1]
+	if addr >= s.Addr+s.Size && s.Size != 0 {
+		return Sym{}, false
+	}
+	return s, true
+}
+
+// Overlapping returns the symbols that overlap [start, end), in
+// order of address. Symbols without a size overlap it if they
+// start in it.
+func (t *Symtab) Overlapping(start, end uint64) []Sym {
+	var syms []Sym
+	for _, s := range t.syms {
+		if s.Addr < end && (s.Addr >= start || s.Addr+s.Size > start) {
+			syms = append(syms, s)
+		}
+	}
+	return syms
+}
+
+// describe describes addr as a symbol plus offset, like
+// "runtime.memmove" or "runtime.memmove+0x10". Addresses within
+// the symbol cur are described by their offset from its start,
+// like "+0x1c".
+func (t *Symtab) describe(addr uint64, cur string) (string, bool) {
+	s, ok := t.Lookup(addr)
+	if !ok {
+		return "", false
+	}
+	off := addr - s.Addr
+	switch {
+	case s.Name == cur:
+		return fmt.Sprintf("+%#x", off), true
+	case off == 0:
+		return s.Name, true
+	default:
+		return fmt.Sprintf("%s+%#x", s.Name, off), true
+	}
+}
+
+func elfSyms(f *elf.File) ([]Sym, error) {
+	esyms, err := f.Symbols()
+	if err != nil {
+		if errors.Is(err, elf.ErrNoSymbols) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var syms []Sym
+	for _, s := range esyms {
+		if elf.ST_TYPE(s.Info) != elf.STT_FUNC {
+			continue
+		}
+		syms = append(syms, Sym{Name: s.Name, Addr: s.Value, Size: s.Size})
+	}
+	return syms, nil
+}
+
+func machoSyms(f *macho.File) []Sym {
+	if f.Symtab == nil {
+		return nil
+	}
+	text := -1
+	for i, s := range f.Sections {
+		if s.Name == "__text" {
+			text = i + 1 // n_sect is 1-based
+			break
+		}
+	}
+	var syms []Sym
+	for _, s := range f.Symtab.Syms {
+		const stab = 0xe0 // N_STAB
+		if s.Type&stab != 0 || int(s.Sect) != text {
+			continue
+		}
+		syms = append(syms, Sym{Name: s.Name, Addr: s.Value})
+	}
+	return syms
+}
+
+func peSyms(f *pe.File) []Sym {
+	var base uint64
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		base = uint64(oh.ImageBase)
+	case *pe.OptionalHeader64:
+		base = oh.ImageBase
+	}
+	var syms []Sym
+	for _, s := range f.Symbols {
+		if s.SectionNumber <= 0 || int(s.SectionNumber) > len(f.Sections) {
+			continue
+		}
+		sect := f.Sections[s.SectionNumber-1]
+		if sect.Name != ".text" {
+			continue
+		}
+		syms = append(syms, Sym{
+			Name: s.Name,
+			Addr: base + uint64(sect.VirtualAddress) + uint64(s.Value),
+		})
+	}
+	return syms
+}
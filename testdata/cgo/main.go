@@ -0,0 +1,41 @@
+// This is the program that cgo_amd64.txt disassembles. It was
+// generated with
+//
+//	CGO_CFLAGS='-O2 -fcf-protection=full' go build -trimpath -o fx .
+//	go tool objdump -gnu -s '^(sum|clamp)$' fx > cgo_amd64.txt
+//
+// -fcf-protection=full starts each C function with endbr64. See
+// ../cgo386 for the 386 equivalent.
+package main
+
+/*
+
+int sum(const int *x, int n) {
+	int s = 0;
+	for (int i = 0; i < n; i++) {
+		s += x[i] * x[i];
+	}
+	return s;
+}
+
+int clamp(int v, int lo, int hi) {
+	if (v < lo) {
+		return lo;
+	}
+	if (v > hi) {
+		return hi;
+	}
+	return v;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+func main() {
+	x := []C.int{1, 2, 3}
+	fmt.Println(C.sum((*C.int)(unsafe.Pointer(&x[0])), C.int(len(x))), C.clamp(7, 0, 5))
+}
@@ -0,0 +1,9 @@
+int clamp(int v, int lo, int hi) {
+	if (v < lo) {
+		return lo;
+	}
+	if (v > hi) {
+		return hi;
+	}
+	return v;
+}
@@ -0,0 +1,17 @@
+// This is the program that cgo_386.txt disassembles. Without a
+// 32-bit C toolchain to link with, clamp.c is compiled into a
+// system object that the Go linker links internally:
+//
+//	gcc -m32 -O2 -fcf-protection=full -fno-asynchronous-unwind-tables \
+//		-fno-pic -c clamp.c -o clamp_386.syso
+//	GOARCH=386 CGO_ENABLED=0 go build -trimpath -o fx .
+//	GOARCH=386 go tool objdump -gnu -s '^clamp$' fx > cgo_386.txt
+//
+// -fcf-protection=full starts clamp with endbr32, which the Go
+// disassembler prints as two "?" lines followed by "push %ds" and
+// "sti".
+package main
+
+func ref()
+
+func main() { ref() }
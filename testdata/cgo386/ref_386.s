@@ -0,0 +1,5 @@
+#include "textflag.h"
+
+TEXT ·ref(SB),NOSPLIT,$0
+	CALL clamp(SB)
+	RET
@@ -0,0 +1,8 @@
+package main
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
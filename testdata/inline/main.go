@@ -0,0 +1,24 @@
+// This is the program that the inline_go*.txt files disassemble,
+// built as module fx. They were generated with
+//
+//	GOTOOLCHAIN=$version go build -trimpath -o fx .
+//	GOTOOLCHAIN=$version go tool objdump -gnu \
+//		-s '^(main\.norm|go:textfips(start|end))$' fx
+//
+// for go1.17.13, go1.22.12 and go1.27.1, each saved as
+// inline_go1.N.txt. abs is inlined into norm, so some of norm's
+// instructions have the positions of abs.go. Go 1.27 also emits
+// the go:textfipsstart and go:textfipsend markers, whose
+// instructions have no position and are printed as ":-1".
+package main
+
+import "os"
+
+func main() {
+	os.Exit(norm(len(os.Args)-5, 3))
+}
+
+//go:noinline
+func norm(x, y int) int {
+	return abs(x) + abs(y)
+}
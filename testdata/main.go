@@ -0,0 +1,37 @@
+// This is the program that the dump_*.txt files disassemble. They
+// were generated with
+//
+//	GOARCH=$arch go build -trimpath -gcflags=-l -o fx .
+//	go tool objdump -gnu -s '^main\.' fx > dump_$arch.txt
+//
+// -gcflags=-l keeps sum and find from being inlined into main.
+//
+// old_amd64.txt, the baseline of diffjson_amd64.golden, was
+// generated the same way from this program with sum adding v
+// instead of v * v. Run go test -update to rewrite the .golden
+// files.
+package main
+
+import "os"
+
+func sum(x []int) int {
+	s := 0
+	for _, v := range x {
+		s += v * v
+	}
+	return s
+}
+
+func find(x []int, v int) int {
+	for i, w := range x {
+		if w == v {
+			return i
+		}
+	}
+	return -1
+}
+
+func main() {
+	x := []int{1, 2, 3}
+	os.Exit(sum(x) + find(x, 2))
+}
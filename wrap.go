@@ -0,0 +1,118 @@
+package mca
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// wrapWriter hard-wraps the trailing comment of each line
+// longer than width columns, continuing the comment on the next
+// line at the same column.
+//
+// Lines without a comment, or whose comment starts past width,
+// are written unchanged.
+type wrapWriter struct {
+	w        io.Writer
+	width    int
+	tabwidth int
+	buf      []byte
+}
+
+var _ io.Writer = (*wrapWriter)(nil)
+
+func (ww *wrapWriter) Write(p []byte) (int, error) {
+	ww.buf = append(ww.buf, p...)
+	for {
+		i := bytes.IndexByte(ww.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := ww.writeLine(string(ww.buf[:i])); err != nil {
+			return 0, err
+		}
+		ww.buf = ww.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes any buffered partial line.
+func (ww *wrapWriter) Flush() error {
+	if len(ww.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(ww.w, string(ww.buf))
+	ww.buf = ww.buf[:0]
+	return err
+}
+
+func (ww *wrapWriter) writeLine(s string) error {
+	i := strings.Index(s, "// ")
+	if i < 0 || ww.columns(s, 0) <= ww.width {
+		_, err := io.WriteString(ww.w, s+"\n")
+		return err
+	}
+	col := ww.columns(s[:i], 0)
+	if col+len("// ") >= ww.width {
+		_, err := io.WriteString(ww.w, s+"\n")
+		return err
+	}
+	indent := strings.Repeat("\t", col/ww.tabwidth) + strings.Repeat(" ", col%ww.tabwidth)
+
+	var b strings.Builder
+	b.WriteString(s[:i])
+	b.WriteString("//")
+	cur := col + len("//")
+	first := true // first word on the current line
+	for rest := s[i+len("//"):]; rest != ""; {
+		// Split off the separator and the next word.
+		j := 0
+		for j < len(rest) && (rest[j] == ' ' || rest[j] == '\t') {
+			j++
+		}
+		sep := rest[:j]
+		k := j
+		for k < len(rest) && rest[k] != ' ' && rest[k] != '\t' {
+			k++
+		}
+		word := rest[j:k]
+		rest = rest[k:]
+		if word == "" {
+			break
+		}
+		if first {
+			// Always start the comment with a single space so
+			// continuation lines line up.
+			sep = " "
+		}
+		next := ww.columns(sep+word, cur)
+		if !first && next > ww.width {
+			b.WriteString("\n")
+			b.WriteString(indent)
+			b.WriteString("//")
+			cur = col + len("//")
+			sep = " "
+			next = ww.columns(sep+word, cur)
+		}
+		b.WriteString(sep)
+		b.WriteString(word)
+		cur = next
+		first = false
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(ww.w, b.String())
+	return err
+}
+
+// columns returns the column reached after writing s starting
+// at column col.
+func (ww *wrapWriter) columns(s string, col int) int {
+	for _, c := range s {
+		if c == '\t' {
+			col += ww.tabwidth - col%ww.tabwidth
+		} else {
+			col++
+		}
+	}
+	return col
+}